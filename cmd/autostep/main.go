@@ -1,22 +1,47 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/autostep/autostep/internal/actions"
+	"github.com/autostep/autostep/internal/audit"
+	"github.com/autostep/autostep/internal/bundle"
+	"github.com/autostep/autostep/internal/cas"
+	"github.com/autostep/autostep/internal/config"
+	"github.com/autostep/autostep/internal/control"
+	"github.com/autostep/autostep/internal/coordinator"
+	"github.com/autostep/autostep/internal/events"
+	"github.com/autostep/autostep/internal/fetch"
 	"github.com/autostep/autostep/internal/logging"
 	"github.com/autostep/autostep/internal/manifest"
 	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/policy"
+	"github.com/autostep/autostep/internal/report"
 	"github.com/autostep/autostep/internal/runner"
+	"github.com/autostep/autostep/internal/seal"
+	"github.com/autostep/autostep/internal/secrets"
+	"github.com/autostep/autostep/internal/signing"
 	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/supervisor"
 	"github.com/autostep/autostep/internal/workflow"
 	service "github.com/kardianos/service"
 )
@@ -29,33 +54,74 @@ var (
 
 func usage() {
 	fmt.Println("autostep usage:")
-	fmt.Println("  autostep run <workflow-name>        # run a workflow once")
-	fmt.Println("  autostep list                       # list available workflows from manifest")
-	fmt.Println("  autostep status                     # show stored run state")
+	fmt.Println("  autostep run <name>[@version]       # run a workflow once (via the service when it is running)")
+	fmt.Println("        [--param name=value] [--detach] [--local]")
+	fmt.Println("  autostep list [--sources]           # list available workflows from manifest (and manifest.d)")
+	fmt.Println("  autostep validate [name|file]...    # check workflows against policy.json (default: all in manifest)")
+	fmt.Println("  autostep verify-cache               # check cached artifacts against the manifest")
+	fmt.Println("  autostep prefetch <name>[@version]  # download a workflow's URL artifacts into the store")
+	fmt.Println("  autostep cache add <file>...        # store files in the content-addressed artifact store")
+	fmt.Println("  autostep cache gc [--dry-run] [--grace 1h]")
+	fmt.Println("                                      # remove stored artifacts nothing references")
+	fmt.Println("  autostep export <name> [--out file] # bundle a workflow with its artifacts (.tar.gz or .zip)")
+	fmt.Println("  autostep import <bundle> [--force]  # verify and install a bundle")
+	fmt.Println("  autostep status [run-id]            # show stored run state")
+	fmt.Println("  autostep report <run-id> [--format md|html|junit|json] [--out file]")
+	fmt.Println("                                      # render a run report")
+	fmt.Println("  autostep events [--run id] [--follow] [--since T]")
+	fmt.Println("                                      # print run events as NDJSON")
+	fmt.Println("  autostep audit verify               # check the audit log's hash chain")
+	fmt.Println("  autostep audit export [--since RFC3339] [--out file]")
+	fmt.Println("                                      # export audit entries as JSON lines")
+	fmt.Println("  autostep sign --key file.key <file>... # sign workflows or the manifest")
+	fmt.Println("  autostep sign --keygen <name>       # create name.key and name.pub")
+	fmt.Println("  autostep sign --verify <file>...    # check signatures against the trust store")
+	fmt.Println("  autostep secret set <name>          # store a secret read from stdin")
+	fmt.Println("  autostep secret list | rm <name>    # list secret names or remove a secret")
+	fmt.Println("  autostep state decrypt [file] --out <file> # decrypt state.json (or a report or output file)")
+	fmt.Println("  autostep cancel <run-id>            # cancel a queued, running or resumable run")
+	fmt.Println("  autostep logs [-n lines] [-f] [--json] # show (and follow) the agent log")
 	fmt.Println("  autostep resume-pending             # resume pending runs (after reboot)")
-	fmt.Println("  autostep serve                      # run as a service/daemon (skeleton)")
+	fmt.Println("  autostep serve                      # run as a service/daemon")
+	fmt.Println("  autostep coordinator [--listen addr] [--dir dir] [--token t]")
+	fmt.Println("                                      # reference job coordinator for pull mode")
 	fmt.Println("  autostep configure-safeboot-service # allow service to start in Safe Mode/Network (Windows)")
 	fmt.Println("  autostep version                    # show version/build info")
 	fmt.Println()
+	fmt.Println("Global flags:")
+	fmt.Println("  --log-level debug|info|warn|error   (default: config.json log_level, else info)")
+	fmt.Println()
 	fmt.Println("Environment:")
 	fmt.Println("  AUTOSTEP_ROOT   override data root (default: ProgramData/Autostep on Windows)")
 }
 
 func main() {
+	actions.RunHelper()
 	root := paths.DefaultRoot()
 	p := paths.FromRoot(root)
 	if err := paths.Ensure(p); err != nil {
 		log.Fatalf("failed to ensure paths: %v", err)
 	}
 
-	logger, err := logging.Setup(p.LogsDir)
+	args, levelName := extractLogLevel(os.Args[1:])
+	level, rot, err := logSettings(p, levelName)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	logger, err := logging.Setup(p.LogsDir, level, rot)
 	if err != nil {
 		log.Fatalf("failed to setup logging: %v", err)
 	}
+	if auditLog, err = audit.Open(p.AuditPath); err != nil {
+		logger.Printf("audit log unavailable: %v", err)
+	}
+	if err := setupSigning(p, logger); err != nil {
+		logger.Fatalf("signing: %v", err)
+	}
 
 	// If running as a Windows service (non-interactive) and no args were supplied,
 	// automatically start service mode so the SCM can launch us without arguments.
-	if len(os.Args) < 2 {
+	if len(args) < 1 {
 		if !service.Interactive() {
 			runService(logger, p)
 			return
@@ -64,32 +130,159 @@ func main() {
 		os.Exit(1)
 	}
 
-	cmd := os.Args[1]
+	recordCommand(logger, args)
+
+	cmd := args[0]
 	switch cmd {
 	case "run":
-		if len(os.Args) < 3 {
+		fs := flag.NewFlagSet("run", flag.ExitOnError)
+		detach := fs.Bool("detach", false, "return after submitting to the service instead of following the run")
+		local := fs.Bool("local", false, "run in this process, never in the service (refused while the service is running)")
+		params := paramFlag{}
+		fs.Var(params, "param", "run parameter as name=value (repeatable)")
+		args := parseArgs(fs, args[1:])
+		if len(args) < 1 {
 			fmt.Println("missing workflow name")
 			usage()
 			os.Exit(1)
 		}
-		workflowName := os.Args[2]
-		if err := runWorkflowOnce(logger, p, workflowName); err != nil {
+		if err := runWorkflow(logger, p, args[0], params, *detach, *local); err != nil {
 			logger.Fatalf("run failed: %v", err)
 		}
 	case "list":
-		if err := listWorkflows(p); err != nil {
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		sources := fs.Bool("sources", false, "show the manifest file each workflow comes from")
+		parseArgs(fs, args[1:])
+		if err := listWorkflows(p, *sources); err != nil {
 			logger.Fatalf("list failed: %v", err)
 		}
+	case "validate":
+		if err := validateWorkflows(p, args[1:]); err != nil {
+			logger.Fatalf("validate failed: %v", err)
+		}
+	case "verify-cache":
+		if err := verifyCache(p); err != nil {
+			logger.Fatalf("verify-cache failed: %v", err)
+		}
+	case "cache":
+		if err := runCache(p, args[1:]); err != nil {
+			logger.Fatalf("cache failed: %v", err)
+		}
+	case "prefetch":
+		if len(args) < 2 {
+			fmt.Println("missing workflow name")
+			usage()
+			os.Exit(1)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := prefetch(ctx, p, args[1])
+		stop()
+		if err != nil {
+			logger.Fatalf("prefetch failed: %v", err)
+		}
 	case "status":
-		if err := showStatus(p); err != nil {
+		runID := ""
+		if len(args) > 1 {
+			runID = args[1]
+		}
+		if err := showStatus(p, runID); err != nil {
 			logger.Fatalf("status failed: %v", err)
 		}
+	case "report":
+		fs := flag.NewFlagSet("report", flag.ExitOnError)
+		format := fs.String("format", "md", "report format: "+strings.Join(report.Formats, ", "))
+		out := fs.String("out", "", "write the report to this file instead of stdout")
+		args := parseArgs(fs, args[1:])
+		if len(args) < 1 {
+			fmt.Println("missing run id")
+			usage()
+			os.Exit(1)
+		}
+		if err := writeReport(p, args[0], *format, *out); err != nil {
+			logger.Fatalf("report failed: %v", err)
+		}
+	case "events":
+		fs := flag.NewFlagSet("events", flag.ExitOnError)
+		runID := fs.String("run", "", "only events of this run")
+		follow := fs.Bool("follow", false, "keep streaming new events")
+		since := fs.String("since", "", "only events at or after this RFC 3339 time or this long ago (e.g. 2h)")
+		parseArgs(fs, args[1:])
+		if err := showEvents(p, *runID, *follow, *since); err != nil {
+			logger.Fatalf("events failed: %v", err)
+		}
+	case "audit":
+		if err := runAudit(p, args[1:]); err != nil {
+			logger.Fatalf("audit failed: %v", err)
+		}
+	case "sign":
+		if err := runSign(p, args[1:]); err != nil {
+			logger.Fatalf("sign failed: %v", err)
+		}
+	case "secret":
+		if err := runSecret(p, args[1:]); err != nil {
+			logger.Fatalf("secret failed: %v", err)
+		}
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		out := fs.String("out", "", "bundle file; .zip writes a zip (default <name>[-<version>].tar.gz)")
+		args := parseArgs(fs, args[1:])
+		if len(args) < 1 {
+			fmt.Println("missing workflow name")
+			usage()
+			os.Exit(1)
+		}
+		if err := exportBundle(p, args[0], *out); err != nil {
+			logger.Fatalf("export failed: %v", err)
+		}
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		force := fs.Bool("force", false, "replace an installed entry of the same version with different content")
+		args := parseArgs(fs, args[1:])
+		if len(args) < 1 {
+			fmt.Println("missing bundle file")
+			usage()
+			os.Exit(1)
+		}
+		if err := importBundle(p, args[0], *force); err != nil {
+			logger.Fatalf("import failed: %v", err)
+		}
+	case "state":
+		if err := runState(p, args[1:]); err != nil {
+			logger.Fatalf("state failed: %v", err)
+		}
+	case "cancel":
+		if len(args) < 2 {
+			fmt.Println("missing run id")
+			usage()
+			os.Exit(1)
+		}
+		if err := cancelRun(logger, p, args[1]); err != nil {
+			logger.Fatalf("cancel failed: %v", err)
+		}
+	case "logs":
+		fs := flag.NewFlagSet("logs", flag.ExitOnError)
+		lines := fs.Int("n", 50, "number of trailing lines to show")
+		follow := fs.Bool("f", false, "keep streaming new lines")
+		raw := fs.Bool("json", false, "print the raw JSON entries")
+		parseArgs(fs, args[1:])
+		if err := showLogs(p, *lines, *follow, *raw); err != nil {
+			logger.Fatalf("logs failed: %v", err)
+		}
 	case "resume-pending":
 		if err := resumePending(logger, p); err != nil {
 			logger.Fatalf("resume failed: %v", err)
 		}
 	case "serve":
 		runService(logger, p)
+	case "coordinator":
+		fs := flag.NewFlagSet("coordinator", flag.ExitOnError)
+		listen := fs.String("listen", "127.0.0.1:8424", "address to listen on")
+		dir := fs.String("dir", ".", "directory holding jobs/ and receiving results/")
+		token := fs.String("token", "", "bearer token agents must send (optional)")
+		parseArgs(fs, args[1:])
+		if err := runCoordinator(logger, *listen, *dir, *token); err != nil {
+			logger.Fatalf("coordinator failed: %v", err)
+		}
 	case "configure-safeboot-service":
 		if err := configureSafeBootService(logger); err != nil {
 			logger.Fatalf("configure safe boot: %v", err)
@@ -103,181 +296,1163 @@ func main() {
 	}
 }
 
-func listWorkflows(p paths.Paths) error {
+// parseArgs parses flags that may appear before or after positional arguments and
+// returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// extractLogLevel removes --log-level (or -log-level) and its value from anywhere in
+// args so that it works before or after the command.
+func extractLogLevel(args []string) ([]string, string) {
+	var rest []string
+	level := ""
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != "log-level" {
+			rest = append(rest, a)
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		level = value
+	}
+	return rest, level
+}
+
+// logSettings returns the log level named on the command line, else the one in
+// config.json, else info, and the rotation settings from config.json. An unreadable
+// config.json is reported later by the commands that need it.
+func logSettings(p paths.Paths, name string) (slog.Level, logging.Rotation, error) {
+	cfg, err := config.Load(p.ConfigPath)
+	if err != nil {
+		cfg = &config.Config{}
+	}
+	rot := logging.Rotation{
+		MaxSize:  int64(cfg.Logs.MaxSizeMB) << 20,
+		MaxAge:   time.Duration(cfg.Logs.MaxAgeHours) * time.Hour,
+		Keep:     cfg.Logs.Keep,
+		Compress: cfg.Logs.Compress,
+	}
+	if name == "" {
+		name = cfg.LogLevel
+	}
+	if name == "" {
+		return slog.LevelInfo, rot, nil
+	}
+	level, err := logging.ParseLevel(name)
+	return level, rot, err
+}
+
+// paramFlag collects repeated name=value flags.
+type paramFlag map[string]string
+
+func (f paramFlag) String() string { return "" }
+
+func (f paramFlag) Set(v string) error {
+	name, value, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("parameter must be name=value, got %q", v)
+	}
+	f[name] = value
+	return nil
+}
+
+func listWorkflows(p paths.Paths, sources bool) error {
 	m, err := manifest.Load(p.Manifest)
 	if err != nil {
 		return fmt.Errorf("load manifest: %w", err)
 	}
+	for _, err := range m.Skipped {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
 
 	fmt.Println("Workflows:")
-	for _, wf := range m.Workflows {
-		fmt.Printf("- %s (path: %s", wf.Name, wf.Path)
-		if wf.Version != "" {
-			fmt.Printf(", version: %s", wf.Version)
+	listed := map[string]bool{}
+	for _, ref := range m.Workflows {
+		if listed[ref.Name] {
+			continue
+		}
+		listed[ref.Name] = true
+		versions := m.Versions(ref.Name)
+		if len(versions) == 1 {
+			printWorkflow(p, "- "+ref.Name, ref.Version, ref, false, sources)
+			continue
+		}
+		fmt.Printf("- %s\n", ref.Name)
+		for _, wf := range versions {
+			printWorkflow(p, "    "+displayVersion(wf.Version), "", wf, m.IsDefault(wf), sources)
 		}
-		fmt.Println(")")
 	}
 	return nil
 }
 
-func showStatus(p paths.Paths) error {
-	store, err := state.Open(p.StatePath)
-	if err != nil {
-		return fmt.Errorf("open state: %w", err)
+// printWorkflow prints one line of the workflow list.
+func printWorkflow(p paths.Paths, label, version string, wf manifest.WorkflowRef, isDefault, sources bool) {
+	fmt.Printf("%s (path: %s", label, wf.Path)
+	if version != "" {
+		fmt.Printf(", version: %s", version)
+	}
+	if isDefault {
+		fmt.Print(", default")
 	}
+	if sources {
+		fmt.Printf(", source: %s", relToRoot(p, wf.Source))
+	}
+	fmt.Println(")")
+}
 
-	data := store.Export()
-	enc := json.NewEncoder(os.Stdout)
-	enc.SetIndent("", "  ")
-	return enc.Encode(data)
+func displayVersion(v string) string {
+	if v == "" {
+		return "(unversioned)"
+	}
+	return v
 }
 
-func runWorkflowOnce(logger *log.Logger, p paths.Paths, workflowName string) error {
-	wf, err := loadWorkflowByName(p, workflowName)
+// relToRoot shortens a path under the data root to one relative to it.
+func relToRoot(p paths.Paths, file string) string {
+	if rel, err := filepath.Rel(p.Root, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return file
+}
+
+// validateWorkflows loads the named workflows (manifest names or files), or every
+// manifest workflow, and reports steps that policy.json would deny. Parameters take their
+// defaults; references without a value are checked as written.
+func validateWorkflows(p paths.Paths, targets []string) error {
+	pol, err := policy.Load(p.PolicyPath)
 	if err != nil {
 		return err
 	}
-	store, err := state.Open(p.StatePath)
+	var files []string
+	m, merr := manifest.Load(p.Manifest)
+	if len(targets) == 0 {
+		if merr != nil {
+			return fmt.Errorf("load manifest: %w", merr)
+		}
+		for _, ref := range m.Workflows {
+			files = append(files, ref.ResolvePath(p.Root))
+		}
+	}
+	problems := 0
+	if merr == nil && len(targets) == 0 {
+		for _, err := range m.Skipped {
+			fmt.Printf("manifest: %v\n", err)
+			problems++
+		}
+	}
+	for _, t := range targets {
+		if _, err := os.Stat(t); err == nil {
+			files = append(files, t)
+			continue
+		}
+		if merr != nil {
+			return fmt.Errorf("load manifest: %w", merr)
+		}
+		ref, err := m.Select(t)
+		if err != nil {
+			return err
+		}
+		files = append(files, ref.ResolvePath(p.Root))
+	}
+
+	hash := func(path string) (string, error) {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return "", policy.ErrHashUnavailable
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+	}
+	// Paths resolve as for a run, except that environment variables this machine lacks
+	// are left as they are.
+	res := p.Resolver("validate")
+	res.Getenv = func(name string) (string, bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			return v, true
+		}
+		return "%" + name + "%", true
+	}
+	for _, file := range files {
+		wf, err := workflow.Load(file)
+		if err != nil {
+			fmt.Printf("%s: %v\n", file, err)
+			problems++
+			continue
+		}
+		defaults := map[string]string{}
+		for _, prm := range wf.Params {
+			if prm.Default != "" {
+				defaults[prm.Name] = prm.Default
+			}
+		}
+		var found []error
+		for _, step := range wf.Steps {
+			expanded, err := step.Expand(func(kind, name string) (string, bool) {
+				if v, ok := defaults[name]; ok && kind == "param" {
+					return v, true
+				}
+				return "${" + kind + ":" + name + "}", true
+			})
+			if err == nil {
+				expanded, err = expanded.ResolvePaths(res.Path, res.Pattern)
+			}
+			if err == nil && isURLCopy(expanded) && expanded.VerifySHA256 == "" {
+				err = fmt.Errorf("step %s: %w (set verify_sha256)", step.ID, fetch.ErrNoHash)
+			}
+			if err == nil {
+				err = pol.Check(expanded, hash)
+			}
+			if err != nil {
+				found = append(found, err)
+			}
+		}
+		if len(found) == 0 {
+			fmt.Printf("%s: ok\n", wf.Name)
+			continue
+		}
+		for _, err := range found {
+			fmt.Printf("%s: %v\n", wf.Name, err)
+		}
+		problems += len(found)
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	return nil
+}
+
+// verifyCache checks every artifact declared in the manifest against the cache and lists
+// files in the cache that no workflow declares. Missing and corrupted artifacts fail it.
+func verifyCache(p paths.Paths) error {
+	m, err := manifest.Load(p.Manifest)
 	if err != nil {
-		return fmt.Errorf("open state: %w", err)
+		return fmt.Errorf("load manifest: %w", err)
+	}
+	// Workflows may declare the same file; each distinct size and hash is checked once.
+	type declared struct {
+		name      string
+		artifact  manifest.Artifact
+		workflows []string
+	}
+	var list []*declared
+	byKey := map[string]*declared{}
+	known := map[string]bool{}   // file names in the cache
+	checked := map[string]bool{} // blob hashes
+	for _, ref := range m.Workflows {
+		for _, a := range ref.Artifacts {
+			name := path.Clean(a.Name())
+			if file, err := a.File(p.ArtifactsDir); err == nil && filepath.Dir(file) == filepath.Join(p.ArtifactsDir, cas.Dir) {
+				checked[a.Hash()] = true
+			}
+			key := fmt.Sprintf("%s\x00%s\x00%d", name, strings.ToLower(a.SHA256), a.Size)
+			d, ok := byKey[key]
+			if !ok {
+				d = &declared{name: name, artifact: a}
+				byKey[key] = d
+				list = append(list, d)
+			}
+			d.workflows = append(d.workflows, ref.Name)
+			known[name] = true
+		}
 	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].name < list[j].name })
 
-	r := runner.New(p, store, logger)
-	runID := fmt.Sprintf("%s-%d", wf.Name, time.Now().UnixNano())
+	problems := 0
+	for _, d := range list {
+		name := d.name
+		err := d.artifact.Check(p.ArtifactsDir)
+		switch {
+		case err == nil:
+			fmt.Printf("ok         %s (%s)\n", name, strings.Join(d.workflows, ", "))
+			continue
+		case errors.Is(err, manifest.ErrArtifactMissing):
+			fmt.Printf("missing    %s (%s)\n", name, strings.Join(d.workflows, ", "))
+		case errors.Is(err, manifest.ErrArtifactCorrupt):
+			fmt.Printf("corrupted  %s (%s): %v\n", name, strings.Join(d.workflows, ", "), err)
+		default:
+			fmt.Printf("error      %s (%s): %v\n", name, strings.Join(d.workflows, ", "), err)
+		}
+		problems++
+	}
 
-	ctx := context.Background()
-	if err := r.RunWorkflow(ctx, runID, wf); err != nil {
-		if errors.Is(err, actions.ErrRebooting) {
-			logger.Printf("workflow %s requested reboot (run %s); will resume automatically on next boot", wf.Name, runID)
+	err = filepath.WalkDir(p.ArtifactsDir, func(file string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() && file == filepath.Join(p.ArtifactsDir, cas.Dir) {
+			return filepath.SkipDir
+		}
+		if e.IsDir() {
 			return nil
 		}
-		return err
+		rel, err := filepath.Rel(p.ArtifactsDir, file)
+		if err != nil {
+			return err
+		}
+		if !known[filepath.ToSlash(rel)] {
+			fmt.Printf("extra      %s\n", filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scan cache: %w", err)
 	}
 
-	logger.Printf("workflow %s completed (run %s)", wf.Name, runID)
+	// Blobs are named by their hash, so those no declaration covered are checked too.
+	blobs, err := cas.List(p.ArtifactsDir)
+	if err != nil {
+		return fmt.Errorf("scan store: %w", err)
+	}
+	keep, keepErr := referencedBlobs(p)
+	if keepErr != nil {
+		fmt.Printf("note: %v; not listing unused blobs\n", keepErr)
+	}
+	for _, b := range blobs {
+		if !checked[b.Hash] {
+			if err := cas.Verify(p.ArtifactsDir, b.Hash); err != nil {
+				fmt.Printf("corrupted  %s: %v\n", cas.Name(b.Hash), err)
+				problems++
+				continue
+			}
+		}
+		if keepErr == nil && !keep[b.Hash] {
+			fmt.Printf("unused     %s (%d bytes)\n", cas.Name(b.Hash), b.Size)
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d artifact(s) missing or corrupted", problems)
+	}
 	return nil
 }
 
-func loadWorkflowByName(p paths.Paths, name string) (*workflow.Workflow, error) {
+// runCache handles the content-addressed artifact store: add stores files and prints
+// the manifest artifact entries aliasing them, gc removes blobs nothing references.
+func runCache(p paths.Paths, args []string) error {
+	if len(args) < 1 {
+		return errors.New("missing subcommand (add or gc)")
+	}
+	switch args[0] {
+	case "add":
+		files := parseArgs(flag.NewFlagSet("cache add", flag.ExitOnError), args[1:])
+		if len(files) == 0 {
+			return errors.New("missing file")
+		}
+		for _, file := range files {
+			hash, size, err := cas.IngestFile(p.ArtifactsDir, file, "")
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			entry, err := json.Marshal(manifest.Artifact{Path: filepath.Base(file), SHA256: hash, Size: size})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s%s\n  %s\n", paths.CacheScheme, cas.Name(hash), entry)
+		}
+		return nil
+	case "gc":
+		fs := flag.NewFlagSet("cache gc", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "only list what would be removed")
+		grace := fs.Duration("grace", time.Hour, "keep blobs stored or used more recently than this")
+		parseArgs(fs, args[1:])
+		keep, err := referencedBlobs(p)
+		if err != nil {
+			return err
+		}
+		removed, err := cas.Collect(p.ArtifactsDir, keep, *grace, *dryRun)
+		verb := "removed"
+		if *dryRun {
+			verb = "would remove"
+		}
+		var freed int64
+		for _, b := range removed {
+			fmt.Printf("%s %s (%d bytes)\n", verb, cas.Name(b.Hash), b.Size)
+			freed += b.Size
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %d blob(s), %d bytes\n", verb, len(removed), freed)
+		return nil
+	default:
+		return fmt.Errorf("unknown cache subcommand %q", args[0])
+	}
+}
+
+// referencedBlobs returns the hashes of the blobs that manifest entries (of any version)
+// name, alias or fetch, that their workflows name literally or fetch in file_copy steps,
+// and that unfinished runs use. A workflow that cannot be loaded is an error, since its
+// references are unknown.
+func referencedBlobs(p paths.Paths) (map[string]bool, error) {
+	keep := map[string]bool{}
+	addSteps := func(file string) error {
+		wf, err := workflow.Load(file)
+		if err != nil {
+			return fmt.Errorf("cannot tell which blobs are in use: %w", err)
+		}
+		for _, name := range bundle.CacheRefs(wf) {
+			if hash, ok := cas.ParseName(name); ok {
+				keep[hash] = true
+			}
+		}
+		for _, step := range wf.Steps {
+			if isURLCopy(step) && cas.ValidHash(step.VerifySHA256) {
+				keep[strings.ToLower(step.VerifySHA256)] = true
+			}
+		}
+		return nil
+	}
 	m, err := manifest.Load(p.Manifest)
-	if err != nil {
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, fmt.Errorf("load manifest: %w", err)
 	}
+	if m != nil && len(m.Skipped) > 0 {
+		// The artifacts of the entries left out are unknown; keep everything.
+		return nil, fmt.Errorf("manifest incomplete, not collecting: %w", m.Skipped[0])
+	}
+	if m != nil {
+		for _, ref := range m.Workflows {
+			for _, hash := range ref.Blobs() {
+				keep[hash] = true
+			}
+			if err := addSteps(ref.ResolvePath(p.Root)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	runs, err := loadRuns(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range runs {
+		if rec.Finished() {
+			continue
+		}
+		for _, hash := range rec.Artifacts {
+			keep[strings.ToLower(hash)] = true
+		}
+		if rec.WorkflowPath != "" {
+			if err := addSteps(rec.WorkflowPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return keep, nil
+}
 
-	ref, ok := m.Find(name)
-	if !ok {
-		return nil, fmt.Errorf("workflow %q not found in manifest", name)
+// isURLCopy reports whether the step is a file_copy from a URL.
+func isURLCopy(step workflow.Step) bool {
+	return strings.EqualFold(step.Action, "file_copy") && fetch.IsURL(step.SrcPath)
+}
+
+// prefetch fetches what a manifest workflow downloads when it runs, its artifacts with a
+// URL and the URL sources of its file_copy steps, into the artifact store, so that the
+// run can proceed without a network (e.g. after a reboot into Safe Mode). Artifacts
+// without a URL are checked.
+func prefetch(ctx context.Context, p paths.Paths, name string) error {
+	m, err := manifest.Load(p.Manifest)
+	if err != nil {
+		return fmt.Errorf("load manifest: %w", err)
 	}
+	ref, err := m.Select(name)
+	if err != nil {
+		return err
+	}
+	wf, err := workflow.Load(ref.ResolvePath(p.Root))
+	if err != nil {
+		return err
+	}
+	problems := 0
+	report := func(label string, fetched bool, err error) {
+		switch {
+		case err != nil:
+			fmt.Printf("failed   %s: %v\n", label, err)
+			problems++
+		case fetched:
+			fmt.Printf("fetched  %s\n", label)
+		default:
+			fmt.Printf("cached   %s\n", label)
+		}
+	}
+	for _, a := range ref.Artifacts {
+		if a.URL == "" {
+			report(a.Name(), false, a.Check(p.ArtifactsDir))
+			continue
+		}
+		fetched, err := a.Fetch(ctx, fetch.Default, p.ArtifactsDir)
+		if err == nil {
+			err = a.Check(p.ArtifactsDir)
+		}
+		report(a.Name(), fetched, err)
+	}
+	for _, step := range wf.Steps {
+		if !isURLCopy(step) {
+			continue
+		}
+		if strings.Contains(step.SrcPath, "${") || strings.Contains(step.VerifySHA256, "${") {
+			fmt.Printf("skipped  step %s: source depends on parameters\n", step.ID)
+			continue
+		}
+		// Fetch errors name the URL already.
+		_, fetched, err := fetch.Default.Fetch(ctx, p.ArtifactsDir, step.SrcPath, step.VerifySHA256)
+		label := fmt.Sprintf("%s (step %s)", step.SrcPath, step.ID)
+		if err != nil {
+			label = "step " + step.ID
+		}
+		report(label, fetched, err)
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d artifact(s) not available", problems)
+	}
+	return nil
+}
 
-	wfPath := ref.Path
-	if !filepath.IsAbs(wfPath) {
-		clean := filepath.Clean(wfPath)
-		// If the manifest path already starts with "workflows", treat it as relative to root to avoid double "workflows/workflows".
-		if strings.HasPrefix(clean, "workflows"+string(filepath.Separator)) || strings.HasPrefix(clean, "workflows/") {
-			wfPath = filepath.Join(p.Root, clean)
-		} else {
-			wfPath = filepath.Join(p.WorkflowsDir, clean)
+// exportBundle writes the named workflow and its artifacts to a bundle file.
+func exportBundle(p paths.Paths, name, out string) error {
+	if out == "" {
+		m, err := manifest.Load(p.Manifest)
+		if err != nil {
+			return fmt.Errorf("load manifest: %w", err)
+		}
+		ref, err := m.Select(name)
+		if err != nil {
+			return err
+		}
+		out = ref.Name + ".tar.gz"
+		if ref.Version != "" {
+			out = ref.Name + "-" + ref.Version + ".tar.gz"
 		}
 	}
+	if err := bundle.Export(p, name, out); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", out)
+	return nil
+}
 
-	return workflow.Load(wfPath)
+// importBundle installs a bundle and reports what changed.
+func importBundle(p paths.Paths, file string, force bool) error {
+	res, err := bundle.Import(p, file, force)
+	if err != nil {
+		return err
+	}
+	version := displayVersion(res.Version)
+	switch {
+	case res.Unchanged:
+		fmt.Printf("%s %s is already installed\n", res.Workflow, version)
+		return nil
+	case res.Replaced:
+		fmt.Printf("replaced %s %s in %s (%d artifact(s) written)\n", res.Workflow, version, relToRoot(p, res.Manifest), res.Artifacts)
+	default:
+		fmt.Printf("installed %s %s in %s (%d artifact(s) written)\n", res.Workflow, version, relToRoot(p, res.Manifest), res.Artifacts)
+	}
+	if res.Version != "" && res.Default != res.Version {
+		fmt.Printf("the default version of %s is still %s; run this one as %s@%s\n", res.Workflow, displayVersion(res.Default), res.Workflow, res.Version)
+	}
+	return nil
 }
 
-func resumePending(logger *log.Logger, p paths.Paths) error {
-	store, err := state.Open(p.StatePath)
+// showStatus prints stored runs, asking the service when it is running so that the
+// view matches what it is executing.
+func showStatus(p paths.Paths, runID string) error {
+	data, err := loadRuns(p)
 	if err != nil {
-		return fmt.Errorf("open state: %w", err)
+		return err
 	}
-	m, err := manifest.Load(p.Manifest)
+
+	var out any = data
+	if runID != "" {
+		rec, ok := data[runID]
+		if !ok {
+			return fmt.Errorf("run %s not found", runID)
+		}
+		out = rec
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// auditLog records CLI commands and state transitions made by this process.
+var auditLog *audit.Log
+
+// openStore opens the state store and records its transitions in the audit log.
+func openStore(p paths.Paths, logger *logging.Logger) (*state.Store, error) {
+	codec, err := dataCodec(p)
 	if err != nil {
-		return fmt.Errorf("load manifest: %w", err)
+		return nil, err
+	}
+	store, err := state.Open(p.StatePath, codec)
+	if err != nil {
+		return nil, fmt.Errorf("open state: %w", err)
 	}
+	store.OnTransition(audit.TransitionHook(auditLog, logger))
+	return store, nil
+}
 
-	exports := store.Export()
-	if len(exports) == 0 {
-		logger.Println("no runs in state")
-		return nil
+// recordCommand adds the CLI command line to the audit log.
+func recordCommand(logger *logging.Logger, args []string) {
+	e := audit.Entry{Source: audit.SourceCLI, Action: args[0], Actor: audit.CurrentUser()}
+	if len(args) > 1 {
+		e.Detail = map[string]string{"args": strings.Join(args[1:], " ")}
+	}
+	if err := auditLog.Record(e); err != nil {
+		logger.Printf("audit: record command: %v", err)
 	}
+}
 
-	r := runner.New(p, store, logger)
-	ctx := context.Background()
-	for runID, rec := range exports {
-		if rec.Status != state.StatusPendingReboot || rec.PendingRebootNext == nil {
-			continue
+// runAudit implements `autostep audit verify|export`.
+func runAudit(p paths.Paths, args []string) error {
+	if len(args) < 1 {
+		return errors.New("missing subcommand (verify or export)")
+	}
+	switch args[0] {
+	case "verify":
+		n, problems, err := audit.Verify(p.AuditPath)
+		if err != nil {
+			return err
 		}
-		ref, ok := m.Find(rec.WorkflowName)
-		if !ok {
-			logger.Printf("manifest entry for workflow %s not found; skipping run %s", rec.WorkflowName, runID)
-			continue
+		if len(problems) > 0 {
+			for _, pr := range problems {
+				fmt.Println(pr)
+			}
+			return fmt.Errorf("audit log %s failed verification (%d entries, %d problems)", p.AuditPath, n, len(problems))
 		}
-		wfPath := ref.Path
-		if !filepath.IsAbs(wfPath) {
-			clean := filepath.Clean(wfPath)
-			if strings.HasPrefix(clean, "workflows"+string(filepath.Separator)) || strings.HasPrefix(clean, "workflows/") {
-				wfPath = filepath.Join(p.Root, clean)
-			} else {
-				wfPath = filepath.Join(p.WorkflowsDir, clean)
+		fmt.Printf("audit log intact: %d entries\n", n)
+		return nil
+	case "export":
+		fs := flag.NewFlagSet("audit export", flag.ExitOnError)
+		sinceFlag := fs.String("since", "", "only entries recorded at or after this RFC 3339 time")
+		out := fs.String("out", "", "write to this file instead of stdout")
+		parseArgs(fs, args[1:])
+		var since time.Time
+		if *sinceFlag != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, *sinceFlag); err != nil {
+				return fmt.Errorf("invalid --since: %w", err)
 			}
 		}
-		wf, err := workflow.Load(wfPath)
+		if *out == "" {
+			return audit.Export(p.AuditPath, os.Stdout, since)
+		}
+		var buf bytes.Buffer
+		if err := audit.Export(p.AuditPath, &buf, since); err != nil {
+			return err
+		}
+		return os.WriteFile(*out, buf.Bytes(), 0o644)
+	default:
+		return fmt.Errorf("unknown audit subcommand %q", args[0])
+	}
+}
+
+// setupSigning installs the signature verifier for the policy in config.json. If
+// config.json cannot be read or is not protected, the policy is unknown and the strictest
+// one, refuse, is applied; the commands that need the rest of the config report the error.
+func setupSigning(p paths.Paths, logger *logging.Logger) error {
+	policy, err := signingPolicy(p.ConfigPath, logger)
+	if err != nil || policy == signing.PolicyOff {
+		return err
+	}
+	trust, err := signing.LoadTrustStore(p.TrustDir)
+	if err != nil {
+		return err
+	}
+	signing.SetVerifier(&signing.Verifier{
+		Trust:  trust,
+		Policy: policy,
+		Warn: func(path string, err error) {
+			logger.Warn("unverified content loaded (signing policy warn)", "path", path, "error", err)
+		},
+	})
+	return nil
+}
+
+// signingPolicy reads the signing policy from config.json. A config.json that accounts
+// other than administrators could have edited (see config.CheckProtected) does not get to
+// choose it, or anyone able to drop in a workflow could switch verification off: the
+// policy is then refuse, as when config.json cannot be read.
+func signingPolicy(path string, logger *logging.Logger) (signing.Policy, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		logger.Warn("cannot read the signing policy; refusing unsigned content", "error", err)
+		return signing.PolicyRefuse, nil
+	}
+	if err := config.CheckProtected(path); err != nil {
+		logger.Warn("config.json is not protected from non-administrators; ignoring its signing policy and refusing unsigned content", "error", err)
+		return signing.PolicyRefuse, nil
+	}
+	return signing.ParsePolicy(cfg.Signing.Policy)
+}
+
+// runSign implements `autostep sign`.
+func runSign(p paths.Paths, args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyPath := fs.String("key", "", "PEM ed25519 private key to sign with")
+	keygen := fs.String("keygen", "", "write a new key pair to <name>.key and <name>.pub")
+	verify := fs.Bool("verify", false, "verify the files' signatures instead of signing them")
+	files := parseArgs(fs, args)
+	switch {
+	case *keygen != "":
+		pub, err := signing.GenerateKey(*keygen)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s.key and %s.pub (key id %s); copy %s.pub to %s on the agents\n",
+			*keygen, *keygen, signing.KeyID(pub), *keygen, p.TrustDir)
+		return nil
+	case len(files) == 0:
+		return errors.New("no files given")
+	case *verify:
+		trust, err := signing.LoadTrustStore(p.TrustDir)
 		if err != nil {
-			logger.Printf("failed to load workflow %s for run %s: %v", wfPath, runID, err)
-			continue
+			return err
 		}
-		start := *rec.PendingRebootNext
-		logger.Printf("resuming run %s workflow %s at step %d", runID, rec.WorkflowName, start)
-		if err := r.ContinueWorkflow(ctx, runID, wf, start); err != nil {
-			logger.Printf("resume run %s failed: %v", runID, err)
+		failed := 0
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err == nil {
+				err = trust.Verify(f, data)
+			}
+			if err != nil {
+				fmt.Printf("%s: %v\n", f, err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s: ok\n", f)
 		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d files failed verification", failed, len(files))
+		}
+		return nil
+	case *keyPath == "":
+		return errors.New("--key is required to sign")
+	}
+	priv, err := signing.LoadPrivateKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := signing.SignFile(f, priv); err != nil {
+			return err
+		}
+		fmt.Printf("signed %s -> %s%s\n", f, f, signing.SigExt)
 	}
 	return nil
 }
 
-// runService installs and runs the service/daemon skeleton.
-func runService(baseLogger *log.Logger, p paths.Paths) {
-	svcConfig := &service.Config{
-		Name:        "Autostep",
-		DisplayName: "Autostep Workflow Agent",
-		Description: "Runs declarative workflows with reboot/resume support.",
+// runSecret implements `autostep secret set|list|rm`. Values are read from stdin, never
+// from the command line, so they stay out of shell history and the audit log.
+func runSecret(p paths.Paths, args []string) error {
+	if len(args) < 1 {
+		return errors.New("missing subcommand (set, list or rm)")
+	}
+	store := secrets.Open(p.SecretsPath, p.KeyPath)
+	switch args[0] {
+	case "set":
+		if len(args) != 2 {
+			return errors.New("usage: autostep secret set <name> (value on stdin)")
+		}
+		value, err := readSecretValue(args[1])
+		if err != nil {
+			return err
+		}
+		if err := store.Set(args[1], value); err != nil {
+			return err
+		}
+		fmt.Printf("secret %s stored\n", args[1])
+		return nil
+	case "list":
+		names, updated, err := store.List()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Printf("%s\t%s\n", name, updated[name].Format(time.RFC3339))
+		}
+		return nil
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("usage: autostep secret rm <name>")
+		}
+		if err := store.Remove(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("secret %s removed\n", args[1])
+		return nil
+	default:
+		return fmt.Errorf("unknown secret subcommand %q", args[0])
+	}
+}
+
+// readSecretValue reads one line when stdin is a terminal, else all of stdin. A single
+// trailing newline is dropped.
+func readSecretValue(name string) (string, error) {
+	var data []byte
+	var err error
+	if info, statErr := os.Stdin.Stat(); statErr == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprintf(os.Stderr, "value for %s (input is shown): ", name)
+		data, err = bufio.NewReader(os.Stdin).ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	} else {
+		data, err = io.ReadAll(os.Stdin)
 	}
-	app := &svcApp{paths: p, baseLogger: baseLogger}
-	s, err := service.New(app, svcConfig)
 	if err != nil {
-		baseLogger.Fatalf("service create: %v", err)
+		return "", fmt.Errorf("read value: %w", err)
 	}
-	if err := s.Run(); err != nil {
-		baseLogger.Fatalf("service run: %v", err)
+	value := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	if value == "" {
+		return "", errors.New("empty value")
 	}
+	return value, nil
 }
 
-type svcApp struct {
-	paths      paths.Paths
-	baseLogger *log.Logger
-	logger     *log.Logger
+// loadRuns returns the stored runs, from the service when it is running.
+func loadRuns(p paths.Paths) (map[string]*state.RunRecord, error) {
+	if c, err := control.Dial(p.ControlAddr); err == nil {
+		data, _, err := c.List()
+		return data, err
+	}
+	codec, err := dataCodec(p)
+	if err != nil {
+		return nil, err
+	}
+	store, err := state.Open(p.StatePath, codec)
+	if err != nil {
+		return nil, fmt.Errorf("open state: %w", err)
+	}
+	return store.Export(), nil
 }
 
-func (a *svcApp) Start(_ service.Service) error {
-	logger, err := logging.Setup(a.paths.LogsDir)
+// writeReport renders the report of a run still in the state file, or of an older run
+// whose JSON report was saved to the reports directory.
+func writeReport(p paths.Paths, runID, format, out string) error {
+	data, err := loadRuns(p)
 	if err != nil {
 		return err
 	}
-	a.logger = logger
-	go func() {
-		if err := resumePending(a.logger, a.paths); err != nil {
-			a.logger.Printf("resume pending error: %v", err)
+	var rep *report.Report
+	if rec, ok := data[runID]; ok {
+		rep = report.ForRun(rec)
+	} else if rep, err = loadSavedReport(p, runID); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("run %s not found", runID)
 		}
-	}()
+		return err
+	}
+	if out == "" {
+		return report.Render(os.Stdout, rep, format)
+	}
+	var buf bytes.Buffer
+	if err := report.Render(&buf, rep, format); err != nil {
+		return err
+	}
+	return os.WriteFile(out, buf.Bytes(), 0o644)
+}
+
+// loadSavedReport reads the JSON report saved when a run finished.
+func loadSavedReport(p paths.Paths, runID string) (*report.Report, error) {
+	codec, err := dataCodec(p)
+	if err != nil {
+		return nil, err
+	}
+	return report.Load(filepath.Join(p.ReportsDir, runID+".json"), codec)
+}
+
+// dataCodec returns the codec for the state file, saved reports and captured output. It
+// seals with the data key when encryption is enabled in config.json, and can read sealed
+// files whenever the key exists, so encryption can be turned off without losing state.
+func dataCodec(p paths.Paths) (*seal.Codec, error) {
+	cfg, err := config.Load(p.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Encryption.Enabled {
+		key, err := seal.LoadOrCreateKey(p.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		return &seal.Codec{Key: key, Encrypt: true}, nil
+	}
+	key, err := seal.LoadKey(p.KeyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &seal.Codec{Key: key}, nil
+}
+
+// runState implements `autostep state decrypt`.
+func runState(p paths.Paths, args []string) error {
+	if len(args) < 1 || args[0] != "decrypt" {
+		return errors.New("usage: autostep state decrypt [file] --out <file>")
+	}
+	fs := flag.NewFlagSet("state decrypt", flag.ExitOnError)
+	out := fs.String("out", "", "write the plaintext to this file ('-' for stdout)")
+	files := parseArgs(fs, args[1:])
+	if *out == "" {
+		return errors.New("--out is required")
+	}
+	src := p.StatePath
+	if len(files) > 0 {
+		src = files[0]
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	key, err := seal.LoadKey(p.KeyPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	plain, err := (&seal.Codec{Key: key}).Decode(data)
+	if err != nil {
+		return fmt.Errorf("decrypt %s: %w", src, err)
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(plain)
+		return err
+	}
+	return os.WriteFile(*out, plain, 0o600)
+}
+
+// runWorkflow submits the workflow to the running service, or runs it in-process when
+// the service is not reachable. With local it never submits, and refuses while the
+// service runs, since both processes would rewrite the state file.
+func runWorkflow(logger *logging.Logger, p paths.Paths, workflowName string, params map[string]string, detach, local bool) error {
+	if c, err := control.Dial(p.ControlAddr); err == nil {
+		if local {
+			return errors.New("the service is running; run without --local to submit to it, or stop the service first")
+		}
+		return runViaService(c, workflowName, params, detach)
+	}
+
+	store, err := openStore(p, logger)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	sup := newLocalSupervisor(ctx, p, store, logger)
+	defer sup.Shutdown(stopTimeout)
+
+	h, err := sup.SubmitByName(workflowName, params)
+	if err != nil {
+		return err
+	}
+	err = h.Wait()
+	switch {
+	case errors.Is(err, runner.ErrInterrupted):
+		logger.Printf("run %s interrupted; the service or `autostep resume-pending` will continue it", h.RunID)
+		return nil
+	case errors.Is(err, actions.ErrRebooting):
+		return nil
+	}
+	return err
+}
+
+// newLocalSupervisor returns a supervisor for runs executed by the CLI itself. Webhook
+// notifications are only queued; the service delivers them.
+func newLocalSupervisor(ctx context.Context, p paths.Paths, store *state.Store, logger *logging.Logger) *supervisor.Supervisor {
+	sup := supervisor.New(ctx, p, store, logger)
+	attachEventLog(sup, logger)
+	attachEventJournal(p, sup, logger)
+	cfg, err := config.Load(p.ConfigPath)
+	if err != nil {
+		logger.Printf("%v; webhooks disabled", err)
+		return sup
+	}
+	attachWebhooks(p, cfg, sup, logger)
+	attachReports(p, cfg, sup, store.Codec(), logger)
+	return sup
+}
+
+// runViaService submits the workflow to the service and follows the run until it
+// stops running. Interrupting the CLI stops following, not the run.
+func runViaService(c *control.Client, workflowName string, params map[string]string, detach bool) error {
+	runID, err := c.Submit(workflowName, params)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("submitted workflow %s to the service (run %s)\n", workflowName, runID)
+	if detach {
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var last *state.RunRecord
+	err = c.Watch(ctx, runID, func(rec *state.RunRecord) {
+		printTransitions(last, rec)
+		last = rec
+	})
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		fmt.Printf("stopped following run %s; it continues in the service (use `autostep cancel %s` to stop it)\n", runID, runID)
+		return nil
+	}
+	if last == nil {
+		return nil
+	}
+	switch last.Status {
+	case state.StatusFailed:
+		return fmt.Errorf("run %s failed: %s", runID, last.LastError)
+	case state.StatusCancelled:
+		return fmt.Errorf("run %s cancelled", runID)
+	case state.StatusPendingReboot:
+		fmt.Printf("run %s is waiting for a reboot; the service will resume it on next boot\n", runID)
+	default:
+		fmt.Printf("run %s %s\n", runID, last.Status)
+	}
 	return nil
 }
 
-func (a *svcApp) Stop(_ service.Service) error {
-	if a.logger != nil {
-		a.logger.Println("service stopping")
+// printTransitions prints the steps whose status changed between two snapshots of a run.
+func printTransitions(prev, cur *state.RunRecord) {
+	for i, st := range cur.Steps {
+		if st.Status == "" {
+			continue
+		}
+		if prev != nil && i < len(prev.Steps) && prev.Steps[i].Status == st.Status {
+			continue
+		}
+		line := fmt.Sprintf("  step %d/%d %s: %s", i+1, cur.TotalSteps, st.StepID, st.Status)
+		if st.Error != "" {
+			line += " (" + st.Error + ")"
+		}
+		fmt.Println(line)
+	}
+}
+
+// cancelRun cancels through the service when it is running; otherwise only runs that
+// are waiting for a reboot or were interrupted can be cancelled, directly in the store.
+func cancelRun(logger *logging.Logger, p paths.Paths, runID string) error {
+	if c, err := control.Dial(p.ControlAddr); err == nil {
+		if err := c.Cancel(runID); err != nil {
+			return err
+		}
+		fmt.Printf("cancelled run %s\n", runID)
+		return nil
+	}
+	store, err := openStore(p, logger)
+	if err != nil {
+		return err
+	}
+	sup := supervisor.New(context.Background(), p, store, logger)
+	defer sup.Shutdown(stopTimeout)
+	if err := sup.Cancel(runID); err != nil {
+		return err
+	}
+	fmt.Printf("cancelled run %s\n", runID)
+	return nil
+}
+
+// showEvents prints journaled run events as NDJSON, optionally following new ones.
+func showEvents(p paths.Paths, runID string, follow bool, since string) error {
+	filter := events.Filter{RunID: runID}
+	if since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			filter.Since = time.Now().Add(-d)
+		} else if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return fmt.Errorf("invalid --since %q: want an RFC 3339 time or a duration", since)
+		}
+	}
+	write := func(_ events.Event, line []byte) error {
+		_, err := fmt.Printf("%s\n", line)
+		return err
+	}
+	if !follow {
+		return events.Replay(p.EventsPath, filter, write)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return events.Follow(ctx, p.EventsPath, filter, write)
+}
+
+// showLogs prints the agent log, rendering JSON entries like the console unless raw is set.
+func showLogs(p paths.Paths, lines int, follow, raw bool) error {
+	format := logging.FormatLine
+	if raw {
+		format = func(line string) string { return line }
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if c, err := control.Dial(p.ControlAddr); err == nil {
+		return c.Logs(ctx, lines, follow, func(line string) { fmt.Println(format(line)) })
+	}
+	return control.TailFile(ctx, filepath.Join(p.LogsDir, logging.FileName), lines, follow, func(line string) error {
+		_, err := fmt.Println(format(line))
+		return err
+	})
+}
+
+// resumePending continues runs waiting on a reboot and runs interrupted by a shutdown
+// in this process. The service does this itself on start, so it refuses while it runs.
+func resumePending(logger *logging.Logger, p paths.Paths) error {
+	if _, err := control.Dial(p.ControlAddr); err == nil {
+		return errors.New("the service is running and resumes pending runs itself")
+	}
+	store, err := openStore(p, logger)
+	if err != nil {
+		return err
+	}
+	if len(store.Export()) == 0 {
+		logger.Println("no runs in state")
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	sup := newLocalSupervisor(ctx, p, store, logger)
+	defer sup.Shutdown(stopTimeout)
+
+	handles, err := sup.ResumePending()
+	for _, h := range handles {
+		h.Wait()
+	}
+	return err
+}
+
+// runCoordinator serves the reference coordinator until interrupted.
+func runCoordinator(logger *logging.Logger, listen, dir, token string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "jobs"), 0o755); err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	srv := &http.Server{Addr: listen, Handler: coordinator.NewServer(dir, token, logger).Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	logger.Printf("coordinator serving %s on http://%s", dir, listen)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
 	return nil
 }
 
-func configureSafeBootService(logger *log.Logger) error {
+func configureSafeBootService(logger *logging.Logger) error {
 	if err := actions.EnsureServiceSafeBoot("Autostep"); err != nil {
 		return err
 	}
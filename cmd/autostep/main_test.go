@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/autostep/autostep/internal/logging"
+	"github.com/autostep/autostep/internal/signing"
+)
+
+func TestSigningPolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes do not control access on Windows")
+	}
+	logger := logging.New(io.Discard, io.Discard, slog.LevelError)
+	tests := []struct {
+		name    string
+		content string // "" for no config.json
+		mode    os.FileMode
+		want    signing.Policy
+	}{
+		{"no config", "", 0, signing.PolicyOff},
+		{"protected off", `{"signing":{"policy":"off"}}`, 0o644, signing.PolicyOff},
+		{"protected warn", `{"signing":{"policy":"warn"}}`, 0o644, signing.PolicyWarn},
+		{"downgraded to off by anyone", `{"signing":{"policy":"off"}}`, 0o666, signing.PolicyRefuse},
+		{"downgraded to warn by the group", `{"signing":{"policy":"warn"}}`, 0o664, signing.PolicyRefuse},
+		{"unreadable", `{"signing":`, 0o644, signing.PolicyRefuse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(path, tt.mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := signingPolicy(path, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("signingPolicy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
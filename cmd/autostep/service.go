@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/autostep/autostep/internal/actions"
+	"github.com/autostep/autostep/internal/api"
+	"github.com/autostep/autostep/internal/config"
+	"github.com/autostep/autostep/internal/control"
+	"github.com/autostep/autostep/internal/coordinator"
+	"github.com/autostep/autostep/internal/eventlog"
+	"github.com/autostep/autostep/internal/events"
+	"github.com/autostep/autostep/internal/logging"
+	"github.com/autostep/autostep/internal/manifest"
+	"github.com/autostep/autostep/internal/metrics"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/pull"
+	"github.com/autostep/autostep/internal/report"
+	"github.com/autostep/autostep/internal/seal"
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/supervisor"
+	"github.com/autostep/autostep/internal/trigger"
+	"github.com/autostep/autostep/internal/webhook"
+	service "github.com/kardianos/service"
+)
+
+// triggerPollInterval is how often the service checks the inbox and manifest for trigger events.
+const triggerPollInterval = 10 * time.Second
+
+// webhookInterval is how often the service retries due webhook notifications.
+const webhookInterval = 15 * time.Second
+
+// stopTimeout bounds how long Stop waits for the current step; it stays below the
+// Windows SCM's default 20s stop wait so the service is not killed mid-checkpoint.
+const stopTimeout = 15 * time.Second
+
+// runService installs and runs the service/daemon.
+func runService(logger *logging.Logger, p paths.Paths) {
+	svcConfig := &service.Config{
+		Name:        "Autostep",
+		DisplayName: "Autostep Workflow Agent",
+		Description: "Runs declarative workflows with reboot/resume support.",
+	}
+	app := &svcApp{paths: p, logger: logger}
+	s, err := service.New(app, svcConfig)
+	if err != nil {
+		logger.Fatalf("service create: %v", err)
+	}
+	if err := s.Run(); err != nil {
+		logger.Fatalf("service run: %v", err)
+	}
+}
+
+type svcApp struct {
+	paths  paths.Paths
+	logger *logging.Logger
+
+	cancel context.CancelFunc
+	sup    *supervisor.Supervisor
+	done   chan struct{}
+}
+
+func (a *svcApp) Start(_ service.Service) error {
+	cfg, err := config.Load(a.paths.ConfigPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStore(a.paths, a.logger)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	a.sup = supervisor.New(ctx, a.paths, store, a.logger)
+	a.done = make(chan struct{})
+	attachEventLog(a.sup, a.logger)
+	attachEventJournal(a.paths, a.sup, a.logger)
+	attachReports(a.paths, cfg, a.sup, store.Codec(), a.logger)
+	if d := attachWebhooks(a.paths, cfg, a.sup, a.logger); d != nil {
+		go d.Run(ctx, webhookInterval)
+	}
+
+	engine, err := trigger.New(a.paths, store, a.logger, launchTriggered(a.sup))
+	if err != nil {
+		cancel()
+		return fmt.Errorf("open triggers: %w", err)
+	}
+
+	var agent *pull.Agent
+	if cfg.Coordinator.URL != "" {
+		if agent, err = a.newPullAgent(cfg.Coordinator); err != nil {
+			a.logger.Printf("pull mode unavailable: %v", err)
+		}
+	}
+
+	if ln, err := control.Listen(a.paths.ControlAddr); err != nil {
+		a.logger.Printf("control endpoint unavailable; CLI commands will run in-process: %v", err)
+	} else {
+		srv := control.NewServer(a.paths, a.sup, a.logger)
+		srv.SetAudit(auditLog)
+		go func() {
+			if err := srv.Serve(ctx, ln); err != nil {
+				a.logger.Printf("control endpoint error: %v", err)
+			}
+		}()
+	}
+
+	if cfg.HTTP.Enabled {
+		if err := a.startAPI(ctx, cfg.HTTP.Listen); err != nil {
+			a.logger.Printf("REST API unavailable: %v", err)
+		}
+	}
+	if cfg.Metrics.Enabled {
+		if err := a.startMetrics(ctx, cfg.Metrics.Listen); err != nil {
+			a.logger.Printf("metrics endpoint unavailable: %v", err)
+		}
+	}
+
+	go func() {
+		defer close(a.done)
+		handles, err := a.sup.ResumePending()
+		if err != nil {
+			a.logger.Printf("resume pending error: %v", err)
+		}
+		for _, h := range handles {
+			h.Wait()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		if agent != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				interval := time.Duration(cfg.Coordinator.PollSeconds) * time.Second
+				if err := agent.Run(ctx, interval); err != nil && !errors.Is(err, context.Canceled) {
+					a.logger.Printf("pull agent error: %v", err)
+				}
+			}()
+		}
+		if a.sup.RebootPending() {
+			a.logger.Println("a run is waiting for reboot; triggers will be evaluated after the next boot")
+			return
+		}
+		var reported string
+		loadManifest := func() (*manifest.Manifest, error) {
+			m, err := manifest.Load(a.paths.Manifest)
+			if err == nil && fmt.Sprint(m.Skipped) != reported {
+				// Logged when the problems change, not on every poll.
+				reported = fmt.Sprint(m.Skipped)
+				for _, err := range m.Skipped {
+					a.logger.Printf("manifest: %v", err)
+				}
+			}
+			return m, err
+		}
+		if err := engine.Run(ctx, loadManifest, triggerPollInterval); err != nil && !errors.Is(err, actions.ErrRebooting) && !errors.Is(err, context.Canceled) {
+			a.logger.Printf("trigger engine error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// startAPI serves the REST API on listen until ctx is cancelled.
+func (a *svcApp) startAPI(ctx context.Context, listen string) error {
+	token, err := api.LoadOrCreateToken(a.paths.APITokenPath)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	apiServer := api.NewServer(a.paths, a.sup, a.logger, token)
+	apiServer.SetAudit(auditLog)
+	srv := &http.Server{
+		Handler:           apiServer.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Printf("REST API error: %v", err)
+		}
+	}()
+	a.logger.Printf("REST API listening on http://%s (token in %s)", ln.Addr(), a.paths.APITokenPath)
+	return nil
+}
+
+// startMetrics serves Prometheus metrics for the runs of the service on listen until
+// ctx is cancelled.
+func (a *svcApp) startMetrics(ctx context.Context, listen string) error {
+	collector := metrics.NewCollector(a.sup.Store())
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	a.sup.Events().Subscribe(collector.Observe)
+	a.sup.Store().OnPersist(collector.ObservePersist)
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", collector.Registry().Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Printf("metrics endpoint error: %v", err)
+		}
+	}()
+	a.logger.Printf("metrics listening on http://%s/metrics", ln.Addr())
+	return nil
+}
+
+// attachWebhooks queues notifications for the configured webhook sinks whenever a run
+// executed by sup completes, fails or waits for a reboot. It returns nil without sinks.
+func attachWebhooks(p paths.Paths, cfg *config.Config, sup *supervisor.Supervisor, logger *logging.Logger) *webhook.Dispatcher {
+	if len(cfg.Webhooks) == 0 {
+		return nil
+	}
+	d, err := webhook.New(p, cfg.Webhooks, logger)
+	if err != nil {
+		logger.Printf("webhooks disabled: %v", err)
+		return nil
+	}
+	sup.OnRunStatus(d.Notify)
+	return d
+}
+
+// attachReports saves a report in each configured format to the reports directory
+// whenever a run executed by sup finishes.
+func attachReports(p paths.Paths, cfg *config.Config, sup *supervisor.Supervisor, codec *seal.Codec, logger *logging.Logger) {
+	var formats []string
+	for _, f := range cfg.Reports.Formats {
+		if !slices.Contains(report.Formats, f) {
+			logger.Printf("reports: ignoring unknown format %q", f)
+			continue
+		}
+		formats = append(formats, f)
+	}
+	if len(formats) == 0 {
+		return
+	}
+	sup.OnRunStatus(func(rec *state.RunRecord) {
+		if !rec.Finished() {
+			return
+		}
+		if err := report.Save(p.ReportsDir, report.ForRun(rec), formats, codec); err != nil {
+			logger.Warn("save run report failed", "run_id", rec.RunID, "error", err)
+		}
+	})
+}
+
+// attachEventJournal persists the run events of sup for `autostep events`.
+func attachEventJournal(p paths.Paths, sup *supervisor.Supervisor, logger *logging.Logger) {
+	j, err := events.OpenJournal(p.EventsPath)
+	if err != nil {
+		logger.Printf("event journal unavailable: %v", err)
+		return
+	}
+	sup.Events().Subscribe(func(e events.Event) {
+		if err := j.Append(e); err != nil {
+			logger.Warn("journal event failed", "run_id", e.RunID, "type", string(e.Type), "error", err)
+		}
+	})
+}
+
+// attachEventLog forwards run events from sup to the system event log when one is
+// available.
+func attachEventLog(sup *supervisor.Supervisor, logger *logging.Logger) {
+	sink, err := eventlog.Open()
+	if err != nil {
+		logger.Debug("system event log unavailable", "error", err)
+		return
+	}
+	sup.SetEventSink(sink)
+}
+
+// newPullAgent sets up polling of the configured coordinator.
+func (a *svcApp) newPullAgent(cfg config.CoordinatorConfig) (*pull.Agent, error) {
+	client, err := coordinator.NewClient(cfg.URL, cfg.Token, cfg.Host)
+	if err != nil {
+		return nil, err
+	}
+	agent, err := pull.New(a.paths, client, a.sup, a.logger)
+	if err != nil {
+		return nil, err
+	}
+	a.logger.Printf("pull mode: polling %s for jobs assigned to %s every %ds", cfg.URL, cfg.Host, cfg.PollSeconds)
+	return agent, nil
+}
+
+// launchTriggered returns a trigger.Launcher that queues manifest workflows on the
+// supervisor and waits for them, so triggered runs execute one at a time.
+func launchTriggered(sup *supervisor.Supervisor) trigger.Launcher {
+	return func(ctx context.Context, ref manifest.WorkflowRef, runID string, params map[string]string) error {
+		h, err := sup.SubmitRef(ctx, ref, runID, params)
+		if err != nil {
+			return err
+		}
+		err = h.Wait()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, supervisor.ErrRebootPending) {
+			return actions.ErrRebooting
+		}
+		return err
+	}
+}
+
+// Stop cancels in-flight work and waits up to stopTimeout for the current step to finish
+// or abort. Aborted steps are recorded as interrupted and resumed on the next start.
+func (a *svcApp) Stop(_ service.Service) error {
+	if a.logger != nil {
+		a.logger.Println("service stopping")
+	}
+	if a.cancel == nil {
+		return nil
+	}
+	deadline := time.Now().Add(stopTimeout)
+	a.cancel()
+	if a.sup.Shutdown(stopTimeout) {
+		select {
+		case <-a.done:
+			a.logger.Println("service stopped")
+			return nil
+		case <-time.After(time.Until(deadline)):
+		}
+	}
+	a.logger.Printf("service stop timed out after %s; in-flight step will be re-run on next start", stopTimeout)
+	return nil
+}
@@ -7,4 +7,4 @@ require (
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require github.com/kardianos/service v1.2.3 // indirect
+require github.com/kardianos/service v1.2.3
@@ -0,0 +1,59 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ProcessOptions restrict a process started by a run step.
+type ProcessOptions struct {
+	RunAs         string // user[:group] or uid[:gid]; DOMAIN\user or user@domain on Windows
+	RunAsPassword string // Windows only: password used to log the account on
+	CPUSeconds    int
+	MemoryMB      int
+	OpenFiles     int
+	Processes     int
+	Env           []string // KEY=value overrides applied last, after the run_as account's HOME etc.
+}
+
+func (o ProcessOptions) limited() bool {
+	return o.CPUSeconds > 0 || o.MemoryMB > 0 || o.OpenFiles > 0 || o.Processes > 0
+}
+
+// RunProcess runs cmd with opts applied and waits for it to exit. cmd.Env is the base
+// environment (nil inherits the agent's). Limits are in place before the process runs
+// any code of its own.
+func RunProcess(cmd *exec.Cmd, opts ProcessOptions) error {
+	if opts.RunAs != "" {
+		release, err := setAccount(cmd, opts)
+		if err != nil {
+			return fmt.Errorf("run_as %s: %w", opts.RunAs, err)
+		}
+		defer release()
+	}
+	if len(opts.Env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, opts.Env...)
+	}
+	if !opts.limited() {
+		return cmd.Run()
+	}
+	return runLimited(cmd, opts)
+}
+
+// RunHelper turns the process into the helper that starts limited steps on Linux if it
+// was executed as one, and does not return then; otherwise it does nothing. main calls
+// it before anything else.
+func RunHelper() {
+	runHelper()
+}
+
+// splitAccount splits "name:group" into its parts.
+func splitAccount(runAs string) (string, string) {
+	name, group, _ := strings.Cut(runAs, ":")
+	return name, group
+}
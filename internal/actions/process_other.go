@@ -0,0 +1,82 @@
+//go:build !windows
+
+package actions
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+func runLimited(cmd *exec.Cmd, opts ProcessOptions) error {
+	if err := startLimited(cmd, opts); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// setAccount makes cmd run as the account named by opts.RunAs, with its supplementary
+// groups, and points HOME, USER and LOGNAME at it when the user is known.
+func setAccount(cmd *exec.Cmd, opts ProcessOptions) (release func(), err error) {
+	name, group := splitAccount(opts.RunAs)
+	cred := &syscall.Credential{}
+	u, err := lookupUser(name)
+	switch {
+	case err == nil:
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+					cred.Groups = append(cred.Groups, uint32(g))
+				}
+			}
+		}
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+	default:
+		uid, perr := strconv.ParseUint(name, 10, 32)
+		if perr != nil {
+			return nil, err
+		}
+		// An unknown numeric uid runs with gid = uid unless a group is given.
+		cred.Uid, cred.Gid = uint32(uid), uint32(uid)
+		cred.Groups = []uint32{}
+	}
+	if group != "" {
+		gid, err := lookupGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		cred.Gid = gid
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = cred
+	return func() {}, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (uint32, error) {
+	if gid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	return uint32(gid), err
+}
@@ -0,0 +1,165 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// LogonToken returns the primary token a run step with run_as starts with. The default
+// logs the account on as a batch job with the step's run_as_password; replace it to
+// obtain tokens another way, e.g. from a credential vault or by S4U logon.
+var LogonToken = logonBatch
+
+var procLogonUserW = windows.NewLazySystemDLL("advapi32.dll").NewProc("LogonUserW")
+
+const (
+	logon32LogonBatch      = 4
+	logon32ProviderDefault = 0
+)
+
+func logonBatch(opts ProcessOptions) (windows.Token, error) {
+	if opts.RunAsPassword == "" {
+		return 0, errors.New("run_as_password is required on Windows")
+	}
+	domain, name := ".", opts.RunAs
+	if d, n, ok := strings.Cut(opts.RunAs, `\`); ok {
+		domain, name = d, n
+	} else if strings.Contains(opts.RunAs, "@") {
+		domain = "" // UPN
+	}
+	name16, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	var domain16 *uint16
+	if domain != "" {
+		if domain16, err = windows.UTF16PtrFromString(domain); err != nil {
+			return 0, err
+		}
+	}
+	password16, err := windows.UTF16PtrFromString(opts.RunAsPassword)
+	if err != nil {
+		return 0, err
+	}
+	var token windows.Token
+	r, _, callErr := procLogonUserW.Call(
+		uintptr(unsafe.Pointer(name16)), uintptr(unsafe.Pointer(domain16)), uintptr(unsafe.Pointer(password16)),
+		logon32LogonBatch, logon32ProviderDefault, uintptr(unsafe.Pointer(&token)))
+	if r == 0 {
+		return 0, fmt.Errorf("logon: %w", callErr)
+	}
+	return token, nil
+}
+
+// setAccount starts cmd with a token for opts.RunAs. The token is closed by release.
+func setAccount(cmd *exec.Cmd, opts ProcessOptions) (release func(), err error) {
+	if _, group := splitAccount(opts.RunAs); group != "" {
+		return nil, errors.New("groups are not supported on Windows")
+	}
+	token, err := LogonToken(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Token = syscall.Token(token)
+	return func() { token.Close() }, nil
+}
+
+// runLimited runs cmd in a job object carrying the limits. Closing the job when the
+// step ends also kills processes the step left behind.
+func runLimited(cmd *exec.Cmd, opts ProcessOptions) error {
+	if opts.OpenFiles > 0 {
+		return errors.New("open_files limit is not supported on Windows")
+	}
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return fmt.Errorf("create job object: %w", err)
+	}
+	defer windows.CloseHandle(job)
+	var info windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	if opts.CPUSeconds > 0 {
+		info.BasicLimitInformation.LimitFlags |= windows.JOB_OBJECT_LIMIT_PROCESS_TIME
+		info.BasicLimitInformation.PerProcessUserTimeLimit = int64(opts.CPUSeconds) * 10_000_000 // 100 ns units
+	}
+	if opts.MemoryMB > 0 {
+		info.BasicLimitInformation.LimitFlags |= windows.JOB_OBJECT_LIMIT_PROCESS_MEMORY
+		info.ProcessMemoryLimit = uintptr(opts.MemoryMB) << 20
+	}
+	if opts.Processes > 0 {
+		info.BasicLimitInformation.LimitFlags |= windows.JOB_OBJECT_LIMIT_ACTIVE_PROCESS
+		info.BasicLimitInformation.ActiveProcessLimit = uint32(opts.Processes)
+	}
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		return fmt.Errorf("set job limits: %w", err)
+	}
+	// The process starts suspended so that it runs no code outside the job.
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_SUSPENDED
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	err = assignToJob(job, cmd.Process.Pid)
+	if err == nil {
+		err = resumeProcess(cmd.Process.Pid)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("apply limits: %w", err)
+	}
+	return cmd.Wait()
+}
+
+// resumeProcess resumes the threads of a process created suspended.
+func resumeProcess(pid int) error {
+	snap, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(snap)
+	var te windows.ThreadEntry32
+	te.Size = uint32(unsafe.Sizeof(te))
+	resumed := false
+	for err = windows.Thread32First(snap, &te); err == nil; err = windows.Thread32Next(snap, &te) {
+		if te.OwnerProcessID != uint32(pid) {
+			continue
+		}
+		h, err := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, te.ThreadID)
+		if err != nil {
+			return err
+		}
+		_, err = windows.ResumeThread(h)
+		windows.CloseHandle(h)
+		if err != nil {
+			return err
+		}
+		resumed = true
+	}
+	if !resumed {
+		return fmt.Errorf("no thread of process %d to resume", pid)
+	}
+	return nil
+}
+
+func assignToJob(job windows.Handle, pid int) error {
+	h, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+	return windows.AssignProcessToJobObject(job, h)
+}
+
+func runHelper() {}
@@ -0,0 +1,122 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// limitHelperArg is the first argument of autostep re-executed as the helper that sets
+// a step's resource limits on itself and then executes the step's command, which
+// inherits them. The limits are thus in place before the command runs, without
+// tracing it, so setuid and file capabilities take effect as they would unlimited.
+const limitHelperArg = "__autostep-limits"
+
+// startLimited starts cmd through the limit helper. The helper reports a failure on a
+// close-on-exec pipe; end of file on it means the command was executed.
+func startLimited(cmd *exec.Cmd, opts ProcessOptions) error {
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("apply limits: %w", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("apply limits: %w", err)
+	}
+	defer r.Close()
+	fd := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+	limits := fmt.Sprintf("%d:%d:%d:%d", opts.CPUSeconds, opts.MemoryMB, opts.OpenFiles, opts.Processes)
+	cmd.Args = append([]string{self, limitHelperArg, strconv.Itoa(fd), limits, cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	msg, _ := io.ReadAll(r)
+	if len(msg) > 0 {
+		cmd.Wait()
+		return errors.New(string(msg))
+	}
+	return nil
+}
+
+// runHelper is the limit helper: os.Args holds limitHelperArg, the report descriptor,
+// the limits, the command's path and its arguments.
+func runHelper() {
+	if len(os.Args) < 6 || os.Args[1] != limitHelperArg {
+		return
+	}
+	fd, err := strconv.Atoi(os.Args[2])
+	if err != nil {
+		os.Exit(126)
+	}
+	report := os.NewFile(uintptr(fd), "limits")
+	syscall.CloseOnExec(fd)
+	err = setLimits(os.Args[3])
+	if err == nil {
+		err = syscall.Exec(os.Args[4], os.Args[5:], os.Environ())
+		err = &exec.Error{Name: os.Args[4], Err: err}
+	} else {
+		err = fmt.Errorf("apply limits: %w", err)
+	}
+	report.WriteString(err.Error())
+	os.Exit(126)
+}
+
+// setLimits sets the limits encoded by startLimited on the current process. Processes
+// counts every process of the account the step runs as (RLIMIT_NPROC).
+func setLimits(encoded string) error {
+	var values [4]int
+	fields := strings.Split(encoded, ":")
+	if len(fields) != len(values) {
+		return fmt.Errorf("malformed limits %q", encoded)
+	}
+	for i, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			return fmt.Errorf("malformed limits %q", encoded)
+		}
+		values[i] = v
+	}
+	limits := []struct {
+		name     string
+		resource int
+		value    int
+		scale    uint64
+	}{
+		{"cpu_seconds", unix.RLIMIT_CPU, values[0], 1},
+		{"memory_mb", unix.RLIMIT_AS, values[1], 1 << 20},
+		{"open_files", unix.RLIMIT_NOFILE, values[2], 1},
+		{"processes", unix.RLIMIT_NPROC, values[3], 1},
+	}
+	for _, l := range limits {
+		if l.value <= 0 {
+			continue
+		}
+		v := uint64(l.value) * l.scale
+		// syscall.Setrlimit, unlike a raw prlimit, keeps the runtime from restoring its
+		// saved open files limit on exec.
+		err := syscall.Setrlimit(l.resource, &syscall.Rlimit{Cur: v, Max: v})
+		if errors.Is(err, unix.EPERM) {
+			// Only a privileged process may raise a hard limit, and the helper already
+			// runs as the step's account.
+			return fmt.Errorf("%s: %w (above the hard limit the step inherits)", l.name, err)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", l.name, err)
+		}
+	}
+	return nil
+}
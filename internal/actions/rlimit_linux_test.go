@@ -0,0 +1,52 @@
+package actions
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestMain lets the test binary serve as the limit helper, as main does for autostep.
+func TestMain(m *testing.M) {
+	RunHelper()
+	os.Exit(m.Run())
+}
+
+func TestRunProcessLimits(t *testing.T) {
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", "ulimit -n; ulimit -v; ulimit -t")
+	cmd.Stdout = &out
+	err := RunProcess(cmd, ProcessOptions{OpenFiles: 32, MemoryMB: 512, CPUSeconds: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Fields(out.String()), []string{"32", "524288", "60"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("limits seen by the process: %v, want %v", got, want)
+	}
+}
+
+func TestRunProcessLimitsExitStatus(t *testing.T) {
+	err := RunProcess(exec.Command("sh", "-c", "exit 3"), ProcessOptions{OpenFiles: 32})
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("RunProcess() = %v, want exit status 3", err)
+	}
+}
+
+func TestRunProcessLimitsExecError(t *testing.T) {
+	dir := t.TempDir()
+	script := dir + "/not-executable"
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := RunProcess(exec.Command(script), ProcessOptions{OpenFiles: 32})
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("RunProcess() = %v, want the exec error", err)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		t.Fatalf("RunProcess() = %v, want the helper's report rather than its exit status", err)
+	}
+}
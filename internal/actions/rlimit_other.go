@@ -0,0 +1,11 @@
+//go:build !windows && !linux
+
+package actions
+
+import "os/exec"
+
+func startLimited(cmd *exec.Cmd, opts ProcessOptions) error {
+	return ErrUnsupported
+}
+
+func runHelper() {}
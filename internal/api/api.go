@@ -0,0 +1,220 @@
+// Package api serves the agent's REST API over HTTP. Every request must carry the
+// bearer token stored in the data root; the listener defaults to loopback.
+//
+//	GET  /v1/workflows          workflows from the manifest with their parameters
+//	GET  /v1/runs               stored runs and queued/running jobs
+//	GET  /v1/runs/{id}          one stored run
+//	POST /v1/runs               submit {"workflow": name, "params": {...}}
+//	POST /v1/runs/{id}/cancel   cancel a queued, running or resumable run
+//	GET  /v1/events[?run_id=]   server-sent step and run transitions
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/autostep/autostep/internal/audit"
+	"github.com/autostep/autostep/internal/manifest"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/supervisor"
+	"github.com/autostep/autostep/internal/workflow"
+)
+
+// Logger is a minimal logging interface.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Server answers REST requests on behalf of the running service.
+type Server struct {
+	paths  paths.Paths
+	sup    *supervisor.Supervisor
+	logger Logger
+	token  string
+	audit  *audit.Log
+}
+
+// NewServer constructs a Server that submits work to sup and accepts token.
+func NewServer(p paths.Paths, sup *supervisor.Supervisor, logger Logger, token string) *Server {
+	return &Server{paths: p, sup: sup, logger: logger, token: token}
+}
+
+// SetAudit records every request, including rejected ones, in l.
+func (s *Server) SetAudit(l *audit.Log) {
+	s.audit = l
+}
+
+// WorkflowInfo describes a manifest workflow in API responses.
+type WorkflowInfo struct {
+	Name    string           `json:"name"`
+	Path    string           `json:"path"`
+	Version string           `json:"version,omitempty"`
+	Source  string           `json:"source,omitempty"` // manifest file defining the workflow
+	Default bool             `json:"default"`          // the version run when none is given
+	Params  []workflow.Param `json:"params,omitempty"`
+	Error   string           `json:"error,omitempty"` // set if the workflow file cannot be loaded
+}
+
+// RunList is the body of GET /v1/runs.
+type RunList struct {
+	Runs []*state.RunRecord   `json:"runs"`
+	Jobs []supervisor.JobInfo `json:"jobs"`
+}
+
+// SubmitRequest is the body of POST /v1/runs.
+type SubmitRequest struct {
+	Workflow string            `json:"workflow"` // "name" or "name@constraint"
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+// SubmitResponse is returned for an accepted submission.
+type SubmitResponse struct {
+	RunID string `json:"run_id"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler returns the authenticated HTTP handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/workflows", s.listWorkflows)
+	mux.HandleFunc("GET /v1/runs", s.listRuns)
+	mux.HandleFunc("GET /v1/runs/{id}", s.getRun)
+	mux.HandleFunc("POST /v1/runs", s.submitRun)
+	mux.HandleFunc("POST /v1/runs/{id}/cancel", s.cancelRun)
+	mux.HandleFunc("GET /v1/events", s.events)
+	return s.authenticate(mux)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			s.record(r, "rejected")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="autostep"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		s.record(r, "")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) record(r *http.Request, outcome string) {
+	detail := map[string]string{}
+	if r.URL.RawQuery != "" {
+		detail["query"] = r.URL.RawQuery
+	}
+	if outcome != "" {
+		detail["outcome"] = outcome
+	}
+	e := audit.Entry{Source: audit.SourceAPI, Action: r.Method + " " + r.URL.Path, Actor: r.RemoteAddr, Detail: detail}
+	if err := s.audit.Record(e); err != nil {
+		s.logger.Printf("audit: record %s: %v", e.Action, err)
+	}
+}
+
+func (s *Server) listWorkflows(w http.ResponseWriter, r *http.Request) {
+	m, err := manifest.Load(s.paths.Manifest)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, err := range m.Skipped {
+		s.logger.Printf("manifest: %v", err)
+	}
+	out := make([]WorkflowInfo, 0, len(m.Workflows))
+	for _, ref := range m.Workflows {
+		info := WorkflowInfo{Name: ref.Name, Path: ref.Path, Version: ref.Version, Source: ref.Source, Default: m.IsDefault(ref)}
+		if wf, err := workflow.Load(ref.ResolvePath(s.paths.Root)); err != nil {
+			info.Error = err.Error()
+		} else {
+			info.Params = wf.Params
+		}
+		out = append(out, info)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) listRuns(w http.ResponseWriter, r *http.Request) {
+	runs := s.sup.Store().Export()
+	out := RunList{Runs: make([]*state.RunRecord, 0, len(runs)), Jobs: s.sup.Jobs()}
+	for _, rec := range runs {
+		out.Runs = append(out.Runs, rec)
+	}
+	sort.Slice(out.Runs, func(i, j int) bool { return out.Runs[i].StartedAt.After(out.Runs[j].StartedAt) })
+	if out.Jobs == nil {
+		out.Jobs = []supervisor.JobInfo{}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) getRun(w http.ResponseWriter, r *http.Request) {
+	rec, ok := s.sup.Store().Run(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "run "+r.PathValue("id")+" not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+func (s *Server) submitRun(w http.ResponseWriter, r *http.Request) {
+	var req SubmitRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "parse request: "+err.Error())
+		return
+	}
+	if req.Workflow == "" {
+		writeError(w, http.StatusBadRequest, "workflow is required")
+		return
+	}
+	h, err := s.sup.SubmitByName(req.Workflow, req.Params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.logger.Printf("api: submitted workflow %s (run %s)", req.Workflow, h.RunID)
+	w.Header().Set("Location", "/v1/runs/"+h.RunID)
+	writeJSON(w, http.StatusAccepted, SubmitResponse{RunID: h.RunID})
+}
+
+func (s *Server) cancelRun(w http.ResponseWriter, r *http.Request) {
+	runID := r.PathValue("id")
+	if _, ok := s.sup.Store().Run(runID); !ok && !s.queued(runID) {
+		writeError(w, http.StatusNotFound, "run "+runID+" not found")
+		return
+	}
+	if err := s.sup.Cancel(runID); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	s.logger.Printf("api: cancelled run %s", runID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) queued(runID string) bool {
+	for _, j := range s.sup.Jobs() {
+		if j.RunID == runID {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
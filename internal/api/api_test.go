@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/autostep/autostep/internal/logging"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/supervisor"
+)
+
+const testToken = "secret-token"
+
+// newServer serves the API for a data root with a quick two-step workflow and a long
+// one in its manifest.
+func newServer(t *testing.T) (*httptest.Server, *supervisor.Supervisor) {
+	t.Helper()
+	p := paths.FromRoot(t.TempDir())
+	files := map[string]string{
+		p.Manifest: `{"workflows":[{"name":"quick","path":"workflows/quick.json"},{"name":"long","path":"workflows/long.json"}]}`,
+		filepath.Join(p.WorkflowsDir, "quick.json"): `{"name":"quick","steps":[{"id":"a","action":"sleep"},{"id":"b","action":"sleep"}]}`,
+		filepath.Join(p.WorkflowsDir, "long.json"):  `{"name":"long","steps":[{"id":"nap","action":"sleep","sleep_seconds":3600}]}`,
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logger := logging.New(io.Discard, io.Discard, slog.LevelError)
+	store, err := state.Open(p.StatePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sup := supervisor.New(context.Background(), p, store, logger)
+	srv := httptest.NewServer(NewServer(p, sup, logger, testToken).Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		sup.Shutdown(5 * time.Second)
+	})
+	return srv, sup
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAuthentication(t *testing.T) {
+	srv, _ := newServer(t)
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"wrong scheme", "Basic " + testToken, http.StatusUnauthorized},
+		{"valid", "Bearer " + testToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/runs", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Fatal("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestSubmitAndCancel(t *testing.T) {
+	srv, sup := newServer(t)
+
+	for body, want := range map[string]int{
+		`{"workflow":"missing"}`:    http.StatusBadRequest,
+		`{}`:                        http.StatusBadRequest,
+		`{"workflow":"long","x":1}`: http.StatusBadRequest,
+	} {
+		if resp := do(t, srv, http.MethodPost, "/v1/runs", body); resp.StatusCode != want {
+			t.Errorf("POST %s: status %d, want %d", body, resp.StatusCode, want)
+		}
+	}
+
+	resp := do(t, srv, http.MethodPost, "/v1/runs", `{"workflow":"long"}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("submit: status %d", resp.StatusCode)
+	}
+	var sub SubmitResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sub); err != nil {
+		t.Fatal(err)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/v1/runs/"+sub.RunID {
+		t.Errorf("Location %q", loc)
+	}
+
+	if resp := do(t, srv, http.MethodPost, "/v1/runs/no-such-run/cancel", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("cancel unknown run: status %d, want 404", resp.StatusCode)
+	}
+	if resp := do(t, srv, http.MethodPost, "/v1/runs/"+sub.RunID+"/cancel", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("cancel: status %d, want 204", resp.StatusCode)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if rec, ok := sup.Store().Run(sub.RunID); ok && rec.Status == state.StatusCancelled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("run not cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp = do(t, srv, http.MethodGet, "/v1/runs/"+sub.RunID, "")
+	var rec state.RunRecord
+	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil || rec.Status != state.StatusCancelled {
+		t.Fatalf("GET run: %+v, %v; want cancelled", rec, err)
+	}
+}
+
+// TestEventStream checks that every transition of a run that finishes well within the
+// old polling interval reaches the stream, in order.
+func TestEventStream(t *testing.T) {
+	srv, _ := newServer(t)
+	resp := do(t, srv, http.MethodGet, "/v1/events", "")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+
+	sub := do(t, srv, http.MethodPost, "/v1/runs", `{"workflow":"quick"}`)
+	if sub.StatusCode != http.StatusAccepted {
+		t.Fatalf("submit: status %d", sub.StatusCode)
+	}
+
+	want := []string{
+		"run running",
+		"step 0 a pending", "step 0 a completed",
+		"step 1 b pending", "step 1 b completed",
+		"run completed",
+	}
+	// Ends the read below if an event never arrives.
+	timer := time.AfterFunc(10*time.Second, func() { resp.Body.Close() })
+	defer timer.Stop()
+	var got []string
+	sc := bufio.NewScanner(resp.Body)
+	var name string
+	for len(got) < len(want) && sc.Scan() {
+		line := sc.Text()
+		if v, ok := strings.CutPrefix(line, "event: "); ok {
+			name = v
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "step":
+			var ev StepEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, fmt.Sprintf("step %d %s %s", ev.StepIndex, ev.StepID, ev.Status))
+		case "run":
+			var ev RunEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatal(err)
+			}
+			if ev.Workflow != "quick" {
+				t.Errorf("run event for workflow %q", ev.Workflow)
+			}
+			got = append(got, "run "+ev.Status)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/autostep/autostep/internal/events"
+	"github.com/autostep/autostep/internal/state"
+)
+
+const (
+	// keepAliveInterval is how often an idle stream sends a comment line so proxies
+	// and clients do not time it out.
+	keepAliveInterval = 15 * time.Second
+	// streamBuffer is how many events a stream holds for a slow client before it is
+	// closed rather than silently missing transitions.
+	streamBuffer = 256
+)
+
+// StepEvent is sent as "event: step" when a step changes status.
+type StepEvent struct {
+	RunID     string `json:"run_id"`
+	Workflow  string `json:"workflow"`
+	StepIndex int    `json:"step_index"`
+	StepID    string `json:"step_id"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// RunEvent is sent as "event: run" when a run changes status.
+type RunEvent struct {
+	RunID    string `json:"run_id"`
+	Workflow string `json:"workflow"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// stepStatus and runStatus give the status a run event moves its step or run to.
+var (
+	stepStatus = map[events.Type]string{
+		events.StepPending:    state.StatusPending,
+		events.StepCompleted:  state.StatusCompleted,
+		events.StepFailed:     state.StatusFailed,
+		events.RunCancelled:   state.StatusCancelled,
+		events.RunInterrupted: state.StatusInterrupted,
+	}
+	runStatus = map[events.Type]string{
+		events.RunStarted:      state.StatusRunning,
+		events.RunResumed:      state.StatusRunning,
+		events.RunCompleted:    state.StatusCompleted,
+		events.RunFailed:       state.StatusFailed,
+		events.RunCancelled:    state.StatusCancelled,
+		events.RunInterrupted:  state.StatusInterrupted,
+		events.RebootRequested: state.StatusPendingReboot,
+	}
+)
+
+// events streams step and run transitions as server-sent events, from the run events
+// the supervisor publishes after the stream opened. A client too slow to keep up gets
+// an "event: error" and the stream ends, so it can reconnect and re-read the runs.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	filter := r.URL.Query().Get("run_id")
+	queue := make(chan events.Event, streamBuffer)
+	overflow := make(chan struct{})
+	unsubscribe := s.sup.Events().Subscribe(func(e events.Event) {
+		if filter != "" && e.RunID != filter {
+			return
+		}
+		select {
+		case queue <- e:
+		case <-overflow:
+		default:
+			// Bus subscribers must not block.
+			close(overflow)
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-queue:
+			err = writeRunEvent(w, e)
+		case <-overflow:
+			writeEvent(w, "error", errorResponse{Error: "events were dropped; reconnect"})
+			flusher.Flush()
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err != nil {
+			return
+		}
+		keepAlive.Reset(keepAliveInterval)
+		flusher.Flush()
+	}
+}
+
+// writeRunEvent writes the step and run transitions e stands for.
+func writeRunEvent(w http.ResponseWriter, e events.Event) error {
+	if status, ok := stepStatus[e.Type]; ok && e.StepIndex != nil {
+		ev := StepEvent{RunID: e.RunID, Workflow: e.Workflow, StepIndex: *e.StepIndex, StepID: e.StepID, Status: status, Error: e.Error}
+		if err := writeEvent(w, "step", ev); err != nil {
+			return err
+		}
+	}
+	if status, ok := runStatus[e.Type]; ok {
+		return writeEvent(w, "run", RunEvent{RunID: e.RunID, Workflow: e.Workflow, Status: status, Error: e.Error})
+	}
+	return nil
+}
+
+func writeEvent(w http.ResponseWriter, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
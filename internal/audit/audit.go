@@ -0,0 +1,348 @@
+// Package audit keeps a tamper-evident, append-only log of state transitions, CLI
+// commands and control/API calls. Each entry carries the SHA-256 hash of the previous
+// entry, so editing or removing an entry breaks the chain; the hash of the newest entry
+// is also kept in a separate head file so that truncating the log is detected too.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autostep/autostep/internal/filelock"
+	"github.com/autostep/autostep/internal/state"
+)
+
+// Entry sources.
+const (
+	SourceState   = "state"
+	SourceCLI     = "cli"
+	SourceControl = "control"
+	SourceAPI     = "api"
+)
+
+// genesis is the previous hash of the first entry.
+var genesis = hex.EncodeToString(make([]byte, sha256.Size))
+
+// Entry is one audit record. Hash is the hex SHA-256 of the entry's JSON encoding with
+// Hash empty.
+type Entry struct {
+	Seq    uint64            `json:"seq"`
+	Time   time.Time         `json:"time"`
+	Source string            `json:"source"`
+	Action string            `json:"action"`
+	Actor  string            `json:"actor,omitempty"`
+	RunID  string            `json:"run_id,omitempty"`
+	Detail map[string]string `json:"detail,omitempty"`
+	Prev   string            `json:"prev"`
+	Hash   string            `json:"hash"`
+}
+
+// head is the content of the head file: the newest entry's sequence number and hash.
+type head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Log is an audit log shared by every process on the host. Appends are serialized with
+// an exclusive lock on "<path>.lock". A nil *Log discards entries.
+type Log struct {
+	path string
+	lock *filelock.File
+	mu   sync.Mutex
+}
+
+// Open opens the audit log at path, creating it on the first append.
+func Open(path string) (*Log, error) {
+	lock, err := filelock.Open(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	return &Log{path: path, lock: lock}, nil
+}
+
+// Close releases the lock file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.lock.Close()
+}
+
+// HeadPath returns the path of the head file for the log at path.
+func HeadPath(path string) string {
+	return path + ".head"
+}
+
+// Record appends an entry. Source, Action, Actor, RunID and Detail are taken from e;
+// the remaining fields are filled in.
+func (l *Log) Record(e Entry) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.lock.Lock(); err != nil {
+		return err
+	}
+	defer l.lock.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+	last, err := lastEntry(f)
+	if err != nil {
+		return err
+	}
+	if err := checkHead(HeadPath(l.path), last); err != nil {
+		return err
+	}
+	e.Seq, e.Prev = 1, genesis
+	if last != nil {
+		e.Seq, e.Prev = last.Seq+1, last.Hash
+	}
+	e.Time = time.Now().UTC()
+	e.Hash = ""
+	if e.Hash, err = hashEntry(e); err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("append audit entry: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync audit log: %w", err)
+	}
+	return writeHead(HeadPath(l.path), head{Seq: e.Seq, Hash: e.Hash})
+}
+
+func hashEntry(e Entry) (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lastEntry returns the final entry of f, or nil if f is empty.
+func lastEntry(f *os.File) (*Entry, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+	// Entries are far smaller than the chunk, so the last line is within it.
+	const chunk = 64 * 1024
+	off := size - chunk
+	if off < 0 {
+		off = 0
+	}
+	buf := make([]byte, size-off)
+	if _, err := f.ReadAt(buf, off); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		buf = buf[i+1:]
+	}
+	var e Entry
+	if err := json.Unmarshal(buf, &e); err != nil {
+		return nil, fmt.Errorf("audit log ends with a damaged entry; run `autostep audit verify`: %w", err)
+	}
+	return &e, nil
+}
+
+// checkHead refuses to extend a log that no longer ends at its head, so that a
+// truncation stays detectable instead of being covered by new entries. A log one entry
+// ahead of its head is an append interrupted before the head was updated.
+func checkHead(path string, last *Entry) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if last == nil {
+			return nil
+		}
+		return errors.New("audit log head file is missing; run `autostep audit verify`")
+	}
+	if err != nil {
+		return err
+	}
+	var h head
+	if err := json.Unmarshal(data, &h); err != nil {
+		return fmt.Errorf("audit log head file is damaged: %w", err)
+	}
+	switch {
+	case last == nil && h.Seq == 0:
+	case last != nil && last.Seq == h.Seq && last.Hash == h.Hash:
+	case last != nil && last.Seq == h.Seq+1 && last.Prev == h.Hash:
+	default:
+		return errors.New("audit log does not end at its head (truncated or modified); run `autostep audit verify`")
+	}
+	return nil
+}
+
+func writeHead(path string, h head) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Problem is an inconsistency found by Verify.
+type Problem struct {
+	Line int    `json:"line"` // 0 for problems with the log as a whole
+	Msg  string `json:"msg"`
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.Msg
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Msg)
+}
+
+// Verify checks the chain of the log at path and that it ends at the recorded head. It
+// returns the number of entries and the problems found; a nil slice means intact.
+func Verify(path string) (int, []Problem, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		f = nil
+	} else if err != nil {
+		return 0, nil, err
+	} else {
+		defer f.Close()
+	}
+
+	var problems []Problem
+	var n int
+	prev, seq := genesis, uint64(0)
+	var lastPrev string
+	if f != nil {
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; sc.Scan(); line++ {
+			var e Entry
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				problems = append(problems, Problem{line, "not a valid entry"})
+				continue
+			}
+			n++
+			if e.Seq != seq+1 {
+				problems = append(problems, Problem{line, fmt.Sprintf("sequence %d follows %d (entries removed or reordered)", e.Seq, seq)})
+			}
+			if e.Prev != prev {
+				problems = append(problems, Problem{line, "previous-hash link broken (an earlier entry was changed or removed)"})
+			}
+			if h, err := hashEntry(e); err != nil || h != e.Hash {
+				problems = append(problems, Problem{line, "hash mismatch (entry was modified)"})
+			}
+			lastPrev, prev, seq = e.Prev, e.Hash, e.Seq
+		}
+		if err := sc.Err(); err != nil {
+			return n, problems, err
+		}
+	}
+
+	data, err := os.ReadFile(HeadPath(path))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if seq > 0 {
+			problems = append(problems, Problem{0, "head file is missing"})
+		}
+	case err != nil:
+		return n, problems, err
+	default:
+		var h head
+		if err := json.Unmarshal(data, &h); err != nil {
+			problems = append(problems, Problem{0, "head file is damaged"})
+		} else if (h.Seq != seq || h.Hash != prev) && (h.Seq+1 != seq || h.Hash != lastPrev) {
+			// One entry past the head is an append interrupted before the head was updated.
+			problems = append(problems, Problem{0, fmt.Sprintf("log ends at entry %d but the head records entry %d (log truncated or modified)", seq, h.Seq)})
+		}
+	}
+	return n, problems, nil
+}
+
+// Export writes the entries of the log at path recorded at or after since to w as JSON
+// lines.
+func Export(path string, w io.Writer, since time.Time) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e Entry
+		if json.Unmarshal(sc.Bytes(), &e) == nil && e.Time.Before(since) {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", sc.Bytes()); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// Logger is a minimal logging interface.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// TransitionHook returns a state.TransitionHook recording every run transition in l.
+func TransitionHook(l *Log, logger Logger) state.TransitionHook {
+	return func(action string, rec *state.RunRecord) {
+		detail := map[string]string{"workflow": rec.WorkflowName, "status": rec.Status}
+		if i := rec.CurrentStepIndex; strings.HasPrefix(action, "step_") || action == "assertions_recorded" {
+			if i >= 0 && i < len(rec.Steps) {
+				detail["step_index"] = strconv.Itoa(i)
+				detail["step_id"] = rec.Steps[i].StepID
+				if rec.Steps[i].Error != "" {
+					detail["error"] = rec.Steps[i].Error
+				}
+			}
+		} else if rec.LastError != "" && (action == "run_cancelled" || action == "run_interrupted") {
+			detail["error"] = rec.LastError
+		}
+		if err := l.Record(Entry{Source: SourceState, Action: action, RunID: rec.RunID, Detail: detail}); err != nil {
+			logger.Printf("audit: record %s for run %s: %v", action, rec.RunID, err)
+		}
+	}
+}
+
+// CurrentUser returns the name of the user running the process, for CLI entries.
+func CurrentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
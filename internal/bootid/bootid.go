@@ -0,0 +1,28 @@
+// Package bootid identifies the current boot of the machine so that per-boot
+// work (boot triggers, log correlation) can be deduplicated across restarts of
+// the agent within the same boot.
+package bootid
+
+import "sync"
+
+// Unknown is returned by Current when no boot identifier can be derived.
+const Unknown = "unknown"
+
+var (
+	once    sync.Once
+	current string
+)
+
+// Current returns an identifier that is stable for the lifetime of the current
+// boot and changes on every reboot. It returns Unknown if the platform does
+// not expose enough information to derive one.
+func Current() string {
+	once.Do(func() {
+		id, err := read()
+		if err != nil || id == "" {
+			id = Unknown
+		}
+		current = id
+	})
+	return current
+}
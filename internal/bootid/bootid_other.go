@@ -0,0 +1,31 @@
+//go:build !windows
+
+package bootid
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+)
+
+// read prefers the kernel boot UUID and falls back to the boot timestamp from /proc/stat.
+func read() (string, error) {
+	if b, err := os.ReadFile("/proc/sys/kernel/random/boot_id"); err == nil {
+		if id := strings.TrimSpace(string(b)); id != "" {
+			return id, nil
+		}
+	}
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if btime, ok := strings.CutPrefix(sc.Text(), "btime "); ok {
+			return "btime-" + strings.TrimSpace(btime), nil
+		}
+	}
+	return "", errors.New("boot time not found in /proc/stat")
+}
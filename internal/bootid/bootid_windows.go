@@ -0,0 +1,25 @@
+//go:build windows
+
+package bootid
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// read uses the kernel boot counter maintained under PrefetchParameters and falls
+// back to the boot time derived from the tick count, rounded to absorb jitter.
+func read() (string, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\Session Manager\Memory Management\PrefetchParameters`, registry.QUERY_VALUE)
+	if err == nil {
+		defer k.Close()
+		if v, _, err := k.GetIntegerValue("BootId"); err == nil {
+			return fmt.Sprintf("bootid-%d", v), nil
+		}
+	}
+	boot := time.Now().Add(-windows.DurationSinceBoot()).Round(10 * time.Second)
+	return fmt.Sprintf("btime-%d", boot.Unix()), nil
+}
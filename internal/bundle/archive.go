@@ -0,0 +1,168 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveWriter adds files to a bundle archive.
+type archiveWriter interface {
+	add(name string, size int64, r io.Reader) error
+	Close() error
+}
+
+// newArchiveWriter writes a zip archive if out ends in ".zip", else a gzipped tar.
+func newArchiveWriter(w io.Writer, out string) archiveWriter {
+	if strings.EqualFold(filepath.Ext(out), ".zip") {
+		return &zipWriter{zw: zip.NewWriter(w)}
+	}
+	gz := gzip.NewWriter(w)
+	return &tarWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+type tarWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarWriter) add(name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: time.Now().UTC(), Typeflag: tar.TypeReg}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(t.tw, r)
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) add(name string, size int64, r io.Reader) error {
+	w, err := z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipWriter) Close() error { return z.zw.Close() }
+
+// extract unpacks the bundle at file into dir, telling zip from gzipped tar by content.
+// Every entry must be a regular file with a valid name; it returns the names.
+func extract(file, dir string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head, err := bufio.NewReader(f).Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return extractZip(f, dir)
+	}
+	return extractTar(f, dir)
+}
+
+func extractTar(f *os.File, dir string) ([]string, error) {
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("not a zip or tar.gz bundle: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read bundle: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("bundle entry %s is not a regular file", hdr.Name)
+		}
+		if err := extractFile(dir, hdr.Name, tr); err != nil {
+			return nil, err
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func extractZip(f *os.File, dir string) ([]string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("read bundle: %w", err)
+	}
+	var names []string
+	for _, zf := range zr.File {
+		if strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+		if !zf.Mode().IsRegular() {
+			return nil, fmt.Errorf("bundle entry %s is not a regular file", zf.Name)
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, fmt.Errorf("read bundle entry %s: %w", zf.Name, err)
+		}
+		err = extractFile(dir, zf.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, zf.Name)
+	}
+	return names, nil
+}
+
+func extractFile(dir, name string, r io.Reader) error {
+	if !validName(name) {
+		return fmt.Errorf("bundle entry %q is not allowed", name)
+	}
+	dst := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	// O_EXCL rejects duplicate entries, which could otherwise replace a verified file.
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", name, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("extract %s: %w", name, err)
+	}
+	return out.Close()
+}
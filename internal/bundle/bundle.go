@@ -0,0 +1,257 @@
+// Package bundle packs a manifest workflow with its artifacts into a single file and
+// installs such bundles on another machine. A bundle is a gzipped tar or a zip holding:
+//
+//	manifest.json      manifest fragment with the one workflow entry, artifacts hashed
+//	SHA256SUMS         "<hex sha256>  <name>" for every other file in the bundle
+//	workflows/<file>   the workflow, and its signature if it has one
+//	artifacts/<name>   the artifacts, by path relative to the artifact cache; blobs
+//	                   named "sha256:<hex>" are stored as artifacts/sha256/<hex>
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/autostep/autostep/internal/cas"
+	"github.com/autostep/autostep/internal/fetch"
+	"github.com/autostep/autostep/internal/manifest"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/signing"
+	"github.com/autostep/autostep/internal/workflow"
+)
+
+// Names of the index files in a bundle.
+const (
+	ManifestName = "manifest.json"
+	SumsName     = "SHA256SUMS"
+)
+
+// Export writes a bundle of the named manifest workflow ("name" or "name@constraint") to
+// out: a zip if out ends in ".zip", else a gzipped tar. Artifacts are those the manifest
+// entry declares, which must be intact, plus cache:// files the steps name literally.
+// URLs are fetched first, and the content of file_copy steps' URL sources is included
+// as blobs, so the bundle works on machines without network access.
+func Export(p paths.Paths, name, out string) error {
+	m, err := manifest.Load(p.Manifest)
+	if err != nil {
+		return fmt.Errorf("load manifest: %w", err)
+	}
+	ref, err := m.Select(name)
+	if err != nil {
+		return err
+	}
+	wfFile := ref.ResolvePath(p.Root)
+	wf, err := workflow.Load(wfFile)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]string{} // bundle name -> source file
+	wfName := "workflows/" + filepath.Base(wfFile)
+	files[wfName] = wfFile
+	if _, err := os.Stat(wfFile + signing.SigExt); err == nil {
+		files[wfName+signing.SigExt] = wfFile + signing.SigExt
+	}
+
+	ctx := context.Background()
+	if err := ref.FetchArtifacts(ctx, fetch.Default, p.ArtifactsDir); err != nil {
+		return err
+	}
+	artifacts := map[string]manifest.Artifact{}
+	for _, a := range ref.Artifacts {
+		if err := a.Check(p.ArtifactsDir); err != nil {
+			return err
+		}
+		a.Path = path.Clean(a.Name())
+		artifacts[a.Path] = a
+	}
+	for _, name := range CacheRefs(wf) {
+		if _, ok := artifacts[name]; !ok {
+			artifacts[name] = manifest.Artifact{Path: name}
+		}
+	}
+	for _, step := range wf.Steps {
+		if !strings.EqualFold(step.Action, "file_copy") || !fetch.IsURL(step.SrcPath) || strings.Contains(step.SrcPath+step.VerifySHA256, "${") {
+			continue
+		}
+		if _, _, err := fetch.Default.Fetch(ctx, p.ArtifactsDir, step.SrcPath, step.VerifySHA256); err != nil {
+			return fmt.Errorf("step %s: %w", step.ID, err)
+		}
+		name := cas.Name(step.VerifySHA256)
+		artifacts[name] = manifest.Artifact{Path: name}
+	}
+	frag := manifest.WorkflowRef{Name: ref.Name, Path: wfName, Version: ref.Version, Triggers: ref.Triggers}
+	for _, name := range sortedKeys(artifacts) {
+		art := artifacts[name]
+		file, err := art.File(p.ArtifactsDir)
+		if err != nil {
+			return err
+		}
+		if art.SHA256, art.Size, err = hashFile(file); err != nil {
+			return fmt.Errorf("artifact %s: %w", name, err)
+		}
+		frag.Artifacts = append(frag.Artifacts, art)
+		files[bundleName(art)] = file
+	}
+
+	return writeBundle(out, manifest.Manifest{Workflows: []manifest.WorkflowRef{frag}}, files)
+}
+
+// CacheRefs returns the cache:// names the workflow's steps use literally, skipping
+// paths built from parameters or environment variables.
+func CacheRefs(wf *workflow.Workflow) []string {
+	var refs []string
+	collect := func(v string) (string, error) {
+		if strings.HasPrefix(v, paths.CacheScheme) && !strings.ContainsAny(v, "$%") {
+			refs = append(refs, path.Clean(strings.ReplaceAll(strings.TrimPrefix(v, paths.CacheScheme), `\`, "/")))
+		}
+		return v, nil
+	}
+	keep := func(v string) (string, error) { return v, nil }
+	for _, step := range wf.Steps {
+		step.ResolvePaths(collect, keep)
+	}
+	return refs
+}
+
+// bundleName returns the name of an artifact's file in a bundle.
+func bundleName(a manifest.Artifact) string {
+	if hash, ok := cas.ParseName(a.Name()); ok {
+		return "artifacts/" + cas.Dir + "/" + hash
+	}
+	return "artifacts/" + path.Clean(a.Name())
+}
+
+// writeBundle writes the fragment, the index and files to out through a temporary file.
+func writeBundle(out string, frag manifest.Manifest, files map[string]string) error {
+	fragData, err := json.MarshalIndent(frag, "", "  ")
+	if err != nil {
+		return err
+	}
+	fragData = append(fragData, '\n')
+	sum := sha256.Sum256(fragData)
+	sums := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), ManifestName)
+	names := sortedKeys(files)
+	for _, name := range names {
+		h, _, err := hashFile(files[name])
+		if err != nil {
+			return err
+		}
+		sums += fmt.Sprintf("%s  %s\n", h, name)
+	}
+
+	tmp := out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	aw := newArchiveWriter(f, out)
+	err = aw.add(ManifestName, int64(len(fragData)), bytes.NewReader(fragData))
+	if err == nil {
+		err = aw.add(SumsName, int64(len(sums)), strings.NewReader(sums))
+	}
+	for _, name := range names {
+		if err != nil {
+			break
+		}
+		err = addFile(aw, name, files[name])
+	}
+	if err == nil {
+		err = aw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+	return os.Rename(tmp, out)
+}
+
+func addFile(aw archiveWriter, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return aw.add(name, info.Size(), f)
+}
+
+// readSums parses a SHA256SUMS index.
+func readSums(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("bundle has no %s: %w", SumsName, err)
+	}
+	defer f.Close()
+	sums := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok || len(sum) != sha256.Size*2 || !validName(name) {
+			return nil, fmt.Errorf("%s: malformed line %q", SumsName, line)
+		}
+		sums[name] = strings.ToLower(sum)
+	}
+	return sums, sc.Err()
+}
+
+// validName reports whether name may appear in a bundle: the index files, or a clean
+// relative slash-separated path under workflows/ or artifacts/.
+func validName(name string) bool {
+	if name == ManifestName || name == SumsName {
+		return true
+	}
+	if strings.ContainsAny(name, `\:`) || path.IsAbs(name) || path.Clean(name) != name {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return strings.HasPrefix(name, "workflows/") || strings.HasPrefix(name, "artifacts/")
+}
+
+func hashFile(file string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,203 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/autostep/autostep/internal/cas"
+	"github.com/autostep/autostep/internal/manifest"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/signing"
+)
+
+// export writes a bundle of version of the patch workflow with the given step
+// destination and artifact content, signed with key if it is set, and returns its path.
+func export(t *testing.T, version, dst, artifact string, key ed25519.PrivateKey) string {
+	t.Helper()
+	p := paths.FromRoot(t.TempDir())
+	wfFile := filepath.Join(p.WorkflowsDir, "patch.json")
+	write(t, wfFile,
+		`{"name":"patch","steps":[{"id":"copy","action":"file_copy","src_path":"cache://driver.sys","dst_path":"`+dst+`"}]}`)
+	if key != nil {
+		if err := signing.SignFile(wfFile, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash, _, err := cas.Ingest(p.ArtifactsDir, strings.NewReader(artifact), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, p.Manifest, `{"workflows":[{"name":"patch","path":"workflows/patch.json","version":"`+version+
+		`","artifacts":[{"path":"driver.sys","sha256":"`+hash+`"}]}]}`)
+	out := filepath.Join(t.TempDir(), "patch.tar.gz")
+	if err := Export(p, "patch", out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tamper rewrites the bundle with the content of entry replaced, leaving the index as it was.
+func tamper(t *testing.T, bundle, entry, content string) string {
+	t.Helper()
+	f, err := os.Open(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tr := tar.NewReader(gz)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == entry {
+			data, found = []byte(content), true
+		}
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !found {
+		t.Fatalf("bundle has no entry %s", entry)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "tampered.tar.gz")
+	if err := os.WriteFile(out, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestImportRejectsTamperedBundle(t *testing.T) {
+	good := export(t, "1.0.0", "C:/drivers/driver.sys", "driver v1", nil)
+	for _, tc := range []struct{ name, entry, content string }{
+		{"workflow", "workflows/patch.json", `{"name":"patch","steps":[]}`},
+		{"artifact", "artifacts/driver.sys", "not the driver"},
+		{"index", SumsName, "0000  workflows/patch.json\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := paths.FromRoot(t.TempDir())
+			_, err := Import(p, tamper(t, good, tc.entry, tc.content), false)
+			if err == nil {
+				t.Fatal("Import() succeeded with a tampered bundle")
+			}
+			if _, err := os.Stat(p.Manifest); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("manifest written despite the failed import: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(manifest.DropInDir(p.Manifest), "patch.json")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("manifest fragment written despite the failed import: %v", err)
+			}
+		})
+	}
+}
+
+func TestImportVersions(t *testing.T) {
+	p := paths.FromRoot(t.TempDir())
+	v1 := export(t, "1.0.0", "C:/drivers/driver.sys", "driver v1", nil)
+
+	res, err := Import(p, v1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Version != "1.0.0" || res.Default != "1.0.0" || res.Artifacts != 1 || res.Unchanged || res.Replaced {
+		t.Fatalf("first import: %+v", res)
+	}
+
+	if res, err = Import(p, v1, false); err != nil || !res.Unchanged {
+		t.Fatalf("same bundle again: %+v, %v; want unchanged", res, err)
+	}
+
+	changed := export(t, "1.0.0", "C:/drivers/other.sys", "driver v1", nil)
+	if _, err := Import(p, changed, false); !errors.Is(err, ErrConflict) {
+		t.Fatalf("same version, other content: %v; want ErrConflict", err)
+	}
+	if res, err = Import(p, changed, true); err != nil || !res.Replaced {
+		t.Fatalf("same version with force: %+v, %v; want replaced", res, err)
+	}
+
+	v2 := export(t, "1.1.0", "C:/drivers/driver.sys", "driver v2", nil)
+	if res, err = Import(p, v2, false); err != nil || res.Default != "1.1.0" {
+		t.Fatalf("newer version: %+v, %v; want it to become the default", res, err)
+	}
+	m, err := manifest.Load(p.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, ref := range m.Versions("patch") {
+		versions = append(versions, ref.Version)
+	}
+	if got := strings.Join(versions, " "); got != "1.1.0 1.0.0" {
+		t.Fatalf("installed versions %s, want 1.1.0 1.0.0", got)
+	}
+}
+
+// TestImportRefusedUnderRefusePolicy imports a bundle with a signed workflow, which
+// the refuse policy accepts, into a manifest fragment that could not be signed.
+func TestImportRefusedUnderRefusePolicy(t *testing.T) {
+	trustDir := t.TempDir()
+	if _, err := signing.GenerateKey(filepath.Join(trustDir, "ops")); err != nil {
+		t.Fatal(err)
+	}
+	key, err := signing.LoadPrivateKey(filepath.Join(trustDir, "ops.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := export(t, "1.0.0", "C:/drivers/driver.sys", "driver v1", key)
+	trust, err := signing.LoadTrustStore(trustDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing.SetVerifier(&signing.Verifier{Trust: trust, Policy: signing.PolicyRefuse})
+	defer signing.SetVerifier(nil)
+
+	p := paths.FromRoot(t.TempDir())
+	if _, err := Import(p, bundle, false); err == nil || !strings.Contains(err.Error(), "signing policy") {
+		t.Fatalf("Import() = %v, want a refusal naming the signing policy", err)
+	}
+	if _, err := os.Stat(filepath.Join(manifest.DropInDir(p.Manifest), "patch.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unsigned fragment written: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package bundle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/autostep/autostep/internal/cas"
+	"github.com/autostep/autostep/internal/manifest"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/signing"
+	"github.com/autostep/autostep/internal/workflow"
+)
+
+// ErrConflict is wrapped by Import errors about an installed workflow version that the
+// bundle would replace without force.
+var ErrConflict = errors.New("conflict")
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Result describes an import.
+type Result struct {
+	Workflow  string
+	Version   string
+	Default   string // the workflow's default version after the import
+	Replaced  bool   // an entry of the same version was replaced
+	Unchanged bool   // the same version with the same content was already installed
+	Artifacts int    // artifacts added to the store
+	Manifest  string // manifest file holding the entry
+}
+
+// Import verifies the bundle at file and installs it into the data root. Files are
+// checked against the index and the fragment's hashes in a staging directory first,
+// then moved into place; the manifest entry is written last, so an interrupted import
+// leaves the manifest as it was. The workflow goes to workflows/<name>/<hash>/.
+//
+// The bundle's version is added next to the installed versions of the workflow, and its
+// artifacts to the content-addressed store (see package cas). An installed entry of the
+// same version with different content is an ErrConflict unless force is set. Under the
+// refuse signing policy nothing is installed, since the manifest entry cannot be signed.
+func Import(p paths.Paths, file string, force bool) (*Result, error) {
+	stage, err := os.MkdirTemp(p.Root, ".import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stage)
+	names, err := extract(file, stage)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySums(stage, names); err != nil {
+		return nil, err
+	}
+	ref, err := readFragment(stage, names)
+	if err != nil {
+		return nil, err
+	}
+	stagedWorkflow := filepath.Join(stage, filepath.FromSlash(ref.Path))
+	if _, err := workflow.Load(stagedWorkflow); err != nil {
+		return nil, err
+	}
+	wfSum, _, err := hashFile(stagedWorkflow)
+	if err != nil {
+		return nil, err
+	}
+
+	// The entry goes to the file that defines this version, else the one defining the
+	// default version, so versions stay together, else to a fragment of its own.
+	target := filepath.Join(manifest.DropInDir(p.Manifest), ref.Name+".json")
+	if m, err := manifest.Load(p.Manifest); err == nil {
+		if len(m.Skipped) > 0 {
+			// A left-out fragment may define this workflow; fix the manifest first.
+			return nil, fmt.Errorf("manifest incomplete: %w", m.Skipped[0])
+		}
+		if def, ok := m.Find(ref.Name); ok {
+			target = def.Source
+		}
+		for _, existing := range m.Versions(ref.Name) {
+			if existing.Version == ref.Version {
+				target = existing.Source
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if _, err := os.Stat(target + signing.SigExt); err == nil {
+		return nil, fmt.Errorf("%s is signed; add the entry from the bundle's %s and re-sign it", target, ManifestName)
+	}
+
+	res := &Result{Workflow: ref.Name, Version: ref.Version, Manifest: target}
+	err = manifest.Update(target, func(m *manifest.Manifest) error {
+		idx := -1
+		for i, existing := range m.Workflows {
+			if existing.Name == ref.Name && existing.Version == ref.Version {
+				idx = i
+			}
+		}
+		if idx >= 0 {
+			existing := m.Workflows[idx]
+			if sameWorkflow(existing.ResolvePath(p.Root), wfSum) && existing.VerifyArtifacts(p.ArtifactsDir) == nil {
+				res.Unchanged = true
+				return errUnchanged
+			}
+			if !force {
+				return fmt.Errorf("%w: %s %s is installed with different content (use --force to replace it)",
+					ErrConflict, ref.Name, displayVersion(existing.Version))
+			}
+			res.Replaced = true
+		}
+		if signing.Refusing() {
+			// The entry would be written unsigned, and the file holding it refused.
+			return fmt.Errorf("the signing policy is refuse and %s would not be signed; add the entry from the bundle's %s and sign the file", target, ManifestName)
+		}
+
+		// Artifacts go to the content-addressed store, where they cannot collide with
+		// other workflows' files of the same name; the entry aliases them by name.
+		// Blobs already stored are ingested again, which marks them as recently used.
+		for i, a := range ref.Artifacts {
+			hash := a.Hash()
+			if !cas.ValidHash(hash) || !cas.Has(p.ArtifactsDir, hash) {
+				res.Artifacts++
+			}
+			staged := filepath.Join(stage, filepath.FromSlash(bundleName(a)))
+			stored, size, err := cas.IngestFile(p.ArtifactsDir, staged, hash)
+			if err != nil {
+				return fmt.Errorf("artifact %s: %w", a.Name(), err)
+			}
+			ref.Artifacts[i].Size = size
+			if _, blob := cas.ParseName(a.Name()); !blob {
+				ref.Artifacts[i].SHA256 = stored
+			}
+		}
+		wfDir := filepath.Join(p.WorkflowsDir, ref.Name, wfSum[:12])
+		base := path.Base(ref.Path)
+		moves := [][2]string{{stagedWorkflow, filepath.Join(wfDir, base)}}
+		if _, err := os.Stat(stagedWorkflow + signing.SigExt); err == nil {
+			moves = append(moves, [2]string{stagedWorkflow + signing.SigExt, filepath.Join(wfDir, base+signing.SigExt)})
+		}
+		for _, mv := range moves {
+			if err := os.MkdirAll(filepath.Dir(mv[1]), 0o755); err != nil {
+				return err
+			}
+			if err := os.Rename(mv[0], mv[1]); err != nil {
+				return fmt.Errorf("install %s: %w", mv[1], err)
+			}
+		}
+
+		ref.Path = filepath.ToSlash(filepath.Join("workflows", ref.Name, wfSum[:12], base))
+		if idx >= 0 {
+			m.Workflows[idx] = ref
+		} else {
+			m.Workflows = append(m.Workflows, ref)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errUnchanged) {
+		return nil, err
+	}
+	if m, err := manifest.Load(p.Manifest); err == nil {
+		if def, ok := m.Find(ref.Name); ok {
+			res.Default = def.Version
+		}
+	}
+	return res, nil
+}
+
+// errUnchanged aborts the manifest update when there is nothing to install.
+var errUnchanged = errors.New("unchanged")
+
+// verifySums checks that the index lists exactly the other files of the bundle and
+// that each matches its hash.
+func verifySums(stage string, names []string) error {
+	sums, err := readSums(filepath.Join(stage, SumsName))
+	if err != nil {
+		return err
+	}
+	present := map[string]bool{}
+	for _, name := range names {
+		if name == SumsName {
+			continue
+		}
+		present[name] = true
+		want, ok := sums[name]
+		if !ok {
+			return fmt.Errorf("bundle file %s is not in %s", name, SumsName)
+		}
+		got, _, err := hashFile(filepath.Join(stage, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("bundle file %s is corrupted: sha256 %s, expected %s", name, got, want)
+		}
+	}
+	for name := range sums {
+		if !present[name] {
+			return fmt.Errorf("bundle file %s listed in %s is missing", name, SumsName)
+		}
+	}
+	return nil
+}
+
+// readFragment reads the bundle's manifest fragment and checks its one entry against
+// the staged files.
+func readFragment(stage string, names []string) (manifest.WorkflowRef, error) {
+	var frag manifest.Manifest
+	data, err := os.ReadFile(filepath.Join(stage, ManifestName))
+	if err != nil {
+		return manifest.WorkflowRef{}, fmt.Errorf("bundle has no %s: %w", ManifestName, err)
+	}
+	if err := json.Unmarshal(data, &frag); err != nil {
+		return manifest.WorkflowRef{}, fmt.Errorf("parse bundle %s: %w", ManifestName, err)
+	}
+	if len(frag.Workflows) != 1 {
+		return manifest.WorkflowRef{}, fmt.Errorf("bundle %s must hold one workflow, has %d", ManifestName, len(frag.Workflows))
+	}
+	ref := frag.Workflows[0]
+	if !namePattern.MatchString(ref.Name) {
+		return ref, fmt.Errorf("bundle workflow name %q is not allowed", ref.Name)
+	}
+	has := map[string]bool{}
+	for _, name := range names {
+		has[name] = true
+	}
+	if !strings.HasPrefix(ref.Path, "workflows/") || !has[ref.Path] {
+		return ref, fmt.Errorf("bundle workflow file %s is missing", ref.Path)
+	}
+	staged := filepath.Join(stage, "artifacts")
+	for i, a := range ref.Artifacts {
+		a.Path = path.Clean(a.Name())
+		ref.Artifacts[i] = a
+		if !has[bundleName(a)] {
+			return ref, fmt.Errorf("bundle artifact %s is missing", a.Path)
+		}
+		if err := a.Check(staged); err != nil {
+			return ref, fmt.Errorf("bundle %w", err)
+		}
+	}
+	return ref, nil
+}
+
+// sameWorkflow reports whether the installed workflow file has the given hash.
+func sameWorkflow(file, sum string) bool {
+	got, _, err := hashFile(file)
+	return err == nil && got == sum
+}
+
+func displayVersion(v string) string {
+	if v == "" {
+		return "(unversioned)"
+	}
+	return v
+}
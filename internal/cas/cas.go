@@ -0,0 +1,233 @@
+// Package cas stores artifacts by content. A blob lives in the artifact cache as
+// sha256/<hex sha256>, is named "sha256:<hex>" in cache:// paths, and is never modified
+// once in place, so workflows shipping different files under the same name cannot
+// collide.
+package cas
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Dir is the subdirectory of the artifact cache holding blobs.
+const Dir = "sha256"
+
+// Prefix starts the name of a blob in cache:// paths.
+const Prefix = "sha256:"
+
+// ErrHashMismatch is returned when ingested content does not have the expected hash.
+var ErrHashMismatch = errors.New("sha256 mismatch")
+
+// ValidHash reports whether h is a lowercase or uppercase hex SHA-256.
+func ValidHash(h string) bool {
+	if len(h) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(h)
+	return err == nil
+}
+
+// ParseName returns the hash of a blob name ("sha256:<hex>").
+func ParseName(name string) (string, bool) {
+	h, ok := strings.CutPrefix(name, Prefix)
+	if !ok || !ValidHash(h) {
+		return "", false
+	}
+	return strings.ToLower(h), true
+}
+
+// Name returns the blob name of a hash.
+func Name(hash string) string {
+	return Prefix + strings.ToLower(hash)
+}
+
+// Path returns the location of the blob with the given hash in the cache directory.
+// The hash must be valid (see ValidHash).
+func Path(cacheDir, hash string) string {
+	return filepath.Join(cacheDir, Dir, strings.ToLower(hash))
+}
+
+// Has reports whether the blob is in the cache directory.
+func Has(cacheDir, hash string) bool {
+	info, err := os.Stat(Path(cacheDir, hash))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Ingest stores the content of r as a blob and returns its hash and size. The content
+// is written to a temporary file, synced and renamed into place, so a blob is either
+// complete or absent. If want is set, content with another hash is rejected with
+// ErrHashMismatch. Storing a blob that is already present refreshes its modification
+// time, which protects it from a concurrent Collect until it is referenced.
+func Ingest(cacheDir string, r io.Reader, want string) (string, int64, error) {
+	if want != "" && !ValidHash(want) {
+		return "", 0, fmt.Errorf("invalid sha256 %q", want)
+	}
+	dir := filepath.Join(cacheDir, Dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp(dir, ".ingest-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("store blob: %w", err)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if want != "" && !strings.EqualFold(hash, want) {
+		return "", 0, fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, strings.ToLower(want), hash)
+	}
+	dst := Path(cacheDir, hash)
+	if Has(cacheDir, hash) {
+		now := time.Now()
+		return hash, n, os.Chtimes(dst, now, now)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return "", 0, fmt.Errorf("store blob: %w", err)
+	}
+	return hash, n, nil
+}
+
+// IngestFile stores the content of file as a blob, like Ingest.
+func IngestFile(cacheDir, file, want string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	return Ingest(cacheDir, f, want)
+}
+
+// Adopt moves file, which must be on the same volume as the store (e.g. a download in
+// progress in its directory), into the store as the blob with the given hash. A file
+// with another hash is left in place and ErrHashMismatch returned.
+func Adopt(cacheDir, file, hash string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, hash) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, strings.ToLower(hash), got)
+	}
+	if Has(cacheDir, hash) {
+		now := time.Now()
+		os.Remove(file)
+		return os.Chtimes(Path(cacheDir, hash), now, now)
+	}
+	if err := os.Rename(file, Path(cacheDir, hash)); err != nil {
+		return fmt.Errorf("store blob: %w", err)
+	}
+	return nil
+}
+
+// Blob describes a stored blob.
+type Blob struct {
+	Hash    string
+	Size    int64
+	ModTime time.Time
+}
+
+// List returns the blobs in the cache directory. Leftover temporary files are not
+// included.
+func List(cacheDir string) ([]Blob, error) {
+	entries, err := os.ReadDir(filepath.Join(cacheDir, Dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var blobs []Blob
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !ValidHash(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, Blob{Hash: strings.ToLower(e.Name()), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return blobs, nil
+}
+
+// Verify rehashes the blob and reports whether its content still matches its name.
+func Verify(cacheDir, hash string) error {
+	f, err := os.Open(Path(cacheDir, hash))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != strings.ToLower(hash) {
+		return fmt.Errorf("%w: content hashes to %s", ErrHashMismatch, got)
+	}
+	return nil
+}
+
+// Collect removes the blobs whose hash is not in keep and that were not modified within
+// grace of now, along with temporary files older than grace left by interrupted
+// ingestions. With dryRun it only reports what it would remove.
+func Collect(cacheDir string, keep map[string]bool, grace time.Duration, dryRun bool) ([]Blob, error) {
+	dir := filepath.Join(cacheDir, Dir)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-grace)
+	var removed []Blob
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return removed, err
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+		blob := ValidHash(e.Name())
+		if blob && keep[strings.ToLower(e.Name())] {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return removed, err
+			}
+		}
+		if blob {
+			removed = append(removed, Blob{Hash: strings.ToLower(e.Name()), Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	return removed, nil
+}
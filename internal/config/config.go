@@ -0,0 +1,132 @@
+// Package config loads optional agent settings from config.json under the data root.
+// A missing file yields the defaults, so a fresh install needs no configuration.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DefaultHTTPListen keeps the REST API on loopback unless configured otherwise.
+const DefaultHTTPListen = "127.0.0.1:8423"
+
+// DefaultMetricsListen keeps the metrics endpoint on loopback unless configured otherwise.
+const DefaultMetricsListen = "127.0.0.1:9423"
+
+// DefaultWebhookTTLHours is how long undelivered webhook notifications are kept by default.
+const DefaultWebhookTTLHours = 7 * 24
+
+// Log rotation defaults.
+const (
+	DefaultLogMaxSizeMB = 10
+	DefaultLogKeep      = 5
+)
+
+// DefaultPollSeconds is how often the agent polls the coordinator by default.
+const DefaultPollSeconds = 30
+
+// Config holds agent settings.
+type Config struct {
+	LogLevel    string            `json:"log_level,omitempty"` // debug|info|warn|error
+	HTTP        HTTPConfig        `json:"http"`
+	Metrics     MetricsConfig     `json:"metrics"`
+	Coordinator CoordinatorConfig `json:"coordinator"`
+	Webhooks    []WebhookConfig   `json:"webhooks,omitempty"`
+	Logs        LogsConfig        `json:"logs"`
+	Reports     ReportsConfig     `json:"reports"`
+	Signing     SigningConfig     `json:"signing"`
+	Encryption  EncryptionConfig  `json:"encryption"`
+}
+
+// EncryptionConfig controls encryption at rest of the state file, saved run reports and
+// captured step output.
+type EncryptionConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SigningConfig controls verification of workflow and manifest signatures.
+type SigningConfig struct {
+	Policy string `json:"policy,omitempty"` // off (default), warn or refuse
+}
+
+// ReportsConfig controls the run reports written to logs/reports when a run finishes.
+type ReportsConfig struct {
+	Formats []string `json:"formats,omitempty"` // md, html, junit and/or json; none by default
+}
+
+// LogsConfig controls rotation of logs/autostep.log.
+type LogsConfig struct {
+	MaxSizeMB   int  `json:"max_size_mb,omitempty"`   // default 10; -1 disables size-based rotation
+	MaxAgeHours int  `json:"max_age_hours,omitempty"` // 0 disables age-based rotation
+	Keep        int  `json:"keep,omitempty"`          // rotated files to keep, default 5; -1 keeps all
+	Compress    bool `json:"compress,omitempty"`      // gzip rotated files
+}
+
+// HTTPConfig controls the optional REST API served in service mode.
+type HTTPConfig struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen,omitempty"` // host:port, default 127.0.0.1:8423
+}
+
+// MetricsConfig controls the optional Prometheus metrics endpoint served in service mode.
+type MetricsConfig struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen,omitempty"` // host:port, default 127.0.0.1:9423
+}
+
+// CoordinatorConfig enables pull mode: the service polls URL for jobs assigned to Host.
+type CoordinatorConfig struct {
+	URL         string `json:"url,omitempty"`
+	Token       string `json:"token,omitempty"`
+	Host        string `json:"host,omitempty"`         // default: the machine's host name
+	PollSeconds int    `json:"poll_seconds,omitempty"` // default 30
+}
+
+// WebhookConfig is a sink notified about run outcomes.
+type WebhookConfig struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret,omitempty"`    // HMAC-SHA256 signing key
+	Events   []string `json:"events,omitempty"`    // default: all events
+	TTLHours int      `json:"ttl_hours,omitempty"` // drop undelivered notifications after this (default 168)
+}
+
+// Load reads config.json, returning defaults if it does not exist.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read config %s: %w", path, err)
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, cfg); err != nil {
+			return nil, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+	if cfg.HTTP.Listen == "" {
+		cfg.HTTP.Listen = DefaultHTTPListen
+	}
+	if cfg.Metrics.Listen == "" {
+		cfg.Metrics.Listen = DefaultMetricsListen
+	}
+	if cfg.Coordinator.PollSeconds <= 0 {
+		cfg.Coordinator.PollSeconds = DefaultPollSeconds
+	}
+	if cfg.Coordinator.Host == "" {
+		cfg.Coordinator.Host, _ = os.Hostname()
+	}
+	if cfg.Logs.MaxSizeMB == 0 {
+		cfg.Logs.MaxSizeMB = DefaultLogMaxSizeMB
+	}
+	if cfg.Logs.Keep == 0 {
+		cfg.Logs.Keep = DefaultLogKeep
+	}
+	for i := range cfg.Webhooks {
+		if cfg.Webhooks[i].TTLHours <= 0 {
+			cfg.Webhooks[i].TTLHours = DefaultWebhookTTLHours
+		}
+	}
+	return cfg, nil
+}
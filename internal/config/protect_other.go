@@ -0,0 +1,36 @@
+//go:build !windows
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// CheckProtected returns an error if accounts other than root and the current user can
+// change the file at path: the file and its directory must be owned by one of them and
+// not writable by group or others (a sticky directory only needs the right owner). A
+// missing file is checked for its directory only.
+func CheckProtected(path string) error {
+	if err := checkOwnerAndMode(path, false); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return checkOwnerAndMode(filepath.Dir(path), true)
+}
+
+func checkOwnerAndMode(path string, dir bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Uid != 0 && int(st.Uid) != os.Geteuid() {
+		return fmt.Errorf("%s is owned by uid %d", path, st.Uid)
+	}
+	if info.Mode().Perm()&0o022 != 0 && !(dir && info.Mode()&os.ModeSticky != 0) {
+		return fmt.Errorf("%s is writable by group or others (mode %v)", path, info.Mode().Perm())
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckProtected(t *testing.T) {
+	tests := []struct {
+		name    string
+		dirMode os.FileMode
+		mode    os.FileMode // 0: no config.json
+		chown   bool        // give config.json to another user
+		wantErr bool
+	}{
+		{"protected", 0o755, 0o644, false, false},
+		{"missing file", 0o755, 0, false, false},
+		{"world-writable file", 0o755, 0o666, false, true},
+		{"group-writable file", 0o755, 0o664, false, true},
+		{"world-writable directory", 0o777, 0o644, false, true},
+		{"world-writable directory, missing file", 0o777, 0, false, true},
+		{"sticky directory", 0o777 | os.ModeSticky, 0o644, false, false},
+		{"owned by another user", 0o755, 0o644, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.chown && os.Geteuid() != 0 {
+				t.Skip("changing the owner needs root")
+			}
+			dir := filepath.Join(t.TempDir(), "root")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "config.json")
+			if tt.mode != 0 {
+				if err := os.WriteFile(path, []byte(`{"signing":{"policy":"refuse"}}`), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(path, tt.mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.chown {
+				if err := os.Chown(path, 65534, 65534); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Chmod(dir, tt.dirMode); err != nil {
+				t.Fatal(err)
+			}
+			if err := CheckProtected(path); (err != nil) != tt.wantErr {
+				t.Errorf("CheckProtected() = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
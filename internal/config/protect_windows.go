@@ -0,0 +1,93 @@
+//go:build windows
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileDeleteChild lets a directory's grantee delete any file in it.
+const fileDeleteChild = 0x40
+
+// Rights that let a grantee change a file, or replace or delete the files of a directory.
+const (
+	fileWriteRights = windows.FILE_WRITE_DATA | windows.FILE_APPEND_DATA | windows.DELETE |
+		windows.WRITE_DAC | windows.WRITE_OWNER | windows.GENERIC_WRITE | windows.GENERIC_ALL
+	dirWriteRights = fileDeleteChild | windows.DELETE | windows.WRITE_DAC | windows.WRITE_OWNER | windows.GENERIC_ALL
+)
+
+// trustedInstaller is the service SID of the Windows Modules Installer, which owns
+// system-managed files.
+const trustedInstaller = "S-1-5-80-956008885-3418522649-1831038044-1858292874-2271446464"
+
+// CheckProtected returns an error if accounts other than SYSTEM, Administrators and the
+// current user can change the file at path: the file and its directory must be owned by
+// one of them, and no other account may be granted write access to the file or the right
+// to delete it or files of the directory. A missing file is checked for its directory only.
+func CheckProtected(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		if err := checkACL(path, fileWriteRights); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return checkACL(filepath.Dir(path), dirWriteRights)
+}
+
+func checkACL(path string, rights windows.ACCESS_MASK) error {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return fmt.Errorf("read security of %s: %w", path, err)
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return fmt.Errorf("read owner of %s: %w", path, err)
+	}
+	if !trusted(owner) {
+		return fmt.Errorf("%s is owned by %s", path, account(owner))
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil || dacl == nil {
+		return fmt.Errorf("%s has no access control list, so everyone can change it", path)
+	}
+	for i := uint32(0); i < uint32(dacl.AceCount); i++ {
+		var ace *windows.ACCESS_ALLOWED_ACE
+		if err := windows.GetAce(dacl, i, &ace); err != nil {
+			return fmt.Errorf("read access control list of %s: %w", path, err)
+		}
+		if ace.Header.AceType != windows.ACCESS_ALLOWED_ACE_TYPE || ace.Header.AceFlags&windows.INHERIT_ONLY_ACE != 0 || ace.Mask&rights == 0 {
+			continue
+		}
+		if sid := (*windows.SID)(unsafe.Pointer(&ace.SidStart)); !trusted(sid) {
+			return fmt.Errorf("%s can be changed by %s", path, account(sid))
+		}
+	}
+	return nil
+}
+
+func trusted(sid *windows.SID) bool {
+	if sid.IsWellKnown(windows.WinLocalSystemSid) || sid.IsWellKnown(windows.WinBuiltinAdministratorsSid) || sid.String() == trustedInstaller {
+		return true
+	}
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	return err == nil && windows.EqualSid(sid, user.User.Sid)
+}
+
+// account names sid for messages, falling back to its string form.
+func account(sid *windows.SID) string {
+	name, domain, _, err := sid.LookupAccount("")
+	if err != nil {
+		return sid.String()
+	}
+	if domain != "" {
+		return domain + `\` + name
+	}
+	return name
+}
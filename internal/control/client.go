@@ -0,0 +1,119 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/supervisor"
+)
+
+// Client talks to a running service over its control endpoint.
+type Client struct {
+	addr string
+}
+
+// Dial returns a Client if a service is answering on addr.
+func Dial(addr string) (*Client, error) {
+	c := &Client{addr: addr}
+	if err := c.call(context.Background(), Request{Op: OpPing}, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Submit queues a run of the named workflow and returns its run ID.
+func (c *Client) Submit(workflowName string, params map[string]string) (string, error) {
+	var runID string
+	err := c.call(context.Background(), Request{Op: OpSubmit, Workflow: workflowName, Params: params}, func(r Response) error {
+		runID = r.RunID
+		return nil
+	})
+	return runID, err
+}
+
+// List returns stored runs and the service's queued/running jobs.
+func (c *Client) List() (map[string]*state.RunRecord, []supervisor.JobInfo, error) {
+	var resp Response
+	err := c.call(context.Background(), Request{Op: OpList}, func(r Response) error {
+		resp = r
+		return nil
+	})
+	return resp.Runs, resp.Jobs, err
+}
+
+// Status returns the stored record of a run.
+func (c *Client) Status(runID string) (*state.RunRecord, error) {
+	var rec *state.RunRecord
+	err := c.call(context.Background(), Request{Op: OpStatus, RunID: runID}, func(r Response) error {
+		rec = r.Run
+		return nil
+	})
+	return rec, err
+}
+
+// Watch calls fn with the run record each time it changes until the run stops running.
+func (c *Client) Watch(ctx context.Context, runID string, fn func(*state.RunRecord)) error {
+	return c.call(ctx, Request{Op: OpWatch, RunID: runID}, func(r Response) error {
+		if r.Run != nil {
+			fn(r.Run)
+		}
+		return nil
+	})
+}
+
+// Cancel cancels a queued, running or resumable run.
+func (c *Client) Cancel(runID string) error {
+	return c.call(context.Background(), Request{Op: OpCancel, RunID: runID}, nil)
+}
+
+// Logs calls fn with the last lines of the service log and, if follow is set, with
+// every line appended afterwards until ctx is cancelled.
+func (c *Client) Logs(ctx context.Context, lines int, follow bool, fn func(string)) error {
+	return c.call(ctx, Request{Op: OpLogs, Lines: lines, Follow: follow}, func(r Response) error {
+		fn(r.Line)
+		return nil
+	})
+}
+
+// call sends req on a new connection and passes each response line to fn.
+func (c *Client) call(ctx context.Context, req Request, fn func(Response) error) error {
+	conn, err := dial(c.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var resp Response
+		if err := json.Unmarshal(sc.Bytes(), &resp); err != nil {
+			return fmt.Errorf("parse response: %w", err)
+		}
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		if fn != nil {
+			if err := fn(resp); err != nil {
+				return err
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return sc.Err()
+}
@@ -0,0 +1,41 @@
+// Package control implements the local control endpoint of the service: a unix socket
+// on Linux and a named pipe on Windows carrying newline-delimited JSON. Each connection
+// carries exactly one Request; the server answers with one or more Response lines.
+package control
+
+import (
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/supervisor"
+)
+
+// Control operations.
+const (
+	OpPing   = "ping"
+	OpSubmit = "submit"
+	OpList   = "list"
+	OpStatus = "status"
+	OpWatch  = "watch"
+	OpCancel = "cancel"
+	OpLogs   = "logs"
+)
+
+// Request is a single command sent by a client.
+type Request struct {
+	Op       string            `json:"op"`
+	Workflow string            `json:"workflow,omitempty"` // submit
+	Params   map[string]string `json:"params,omitempty"`   // submit
+	RunID    string            `json:"run_id,omitempty"`   // status|watch|cancel
+	Lines    int               `json:"lines,omitempty"`    // logs: trailing lines to send first
+	Follow   bool              `json:"follow,omitempty"`   // logs: keep streaming appended lines
+}
+
+// Response is one line written by the server. Streaming operations (watch, logs)
+// write one Response per update; an Error ends the stream.
+type Response struct {
+	Error string                      `json:"error,omitempty"`
+	RunID string                      `json:"run_id,omitempty"`
+	Run   *state.RunRecord            `json:"run,omitempty"`
+	Runs  map[string]*state.RunRecord `json:"runs,omitempty"`
+	Jobs  []supervisor.JobInfo        `json:"jobs,omitempty"`
+	Line  string                      `json:"line,omitempty"`
+}
@@ -0,0 +1,63 @@
+//go:build !windows
+
+package control
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Listen opens the control endpoint: a unix socket readable only by the service's user.
+// A stale socket left behind by a crashed service is replaced.
+func Listen(addr string) (net.Listener, error) {
+	if _, err := os.Stat(addr); err == nil {
+		if c, err := net.DialTimeout("unix", addr, time.Second); err == nil {
+			c.Close()
+			return nil, errors.New("control endpoint already in use: " + addr)
+		}
+		if err := os.Remove(addr); err != nil {
+			return nil, err
+		}
+	}
+	// The socket is bound inside a directory only the service's user can enter and
+	// moved into place once restricted, so no other user can connect in between.
+	dir, err := os.MkdirTemp(filepath.Dir(addr), ".control-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, addr); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: ln, path: addr}, nil
+}
+
+// unixListener removes the socket file, now at path, when it is closed.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+func dial(addr string) (net.Conn, error) {
+	return net.DialTimeout("unix", addr, 2*time.Second)
+}
@@ -0,0 +1,49 @@
+//go:build !windows
+
+package control
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	dir := t.TempDir()
+	addr := filepath.Join(dir, "autostep.sock")
+	ln, err := Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("socket mode %s, want a socket with 0600", fi.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("data root holds %d entries, want only the socket", len(entries))
+	}
+
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("unix", addr)
+	if err != nil {
+		t.Fatalf("dial the moved socket: %v", err)
+	}
+	c.Close()
+
+	if _, err := Listen(addr); err == nil {
+		t.Fatal("second Listen() succeeded while the endpoint is in use")
+	}
+	ln.Close()
+	if _, err := os.Stat(addr); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("socket left behind after Close: %v", err)
+	}
+}
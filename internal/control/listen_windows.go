@@ -0,0 +1,142 @@
+//go:build windows
+
+package control
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const pipeBufferSize = 64 * 1024
+
+// Listen opens the control endpoint as a named pipe (e.g. \\.\pipe\autostep). The default
+// pipe security grants write access only to SYSTEM, Administrators and the creator.
+func Listen(addr string) (net.Listener, error) {
+	name, err := windows.UTF16PtrFromString(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeListener{addr: addr, name: name, closed: make(chan struct{})}, nil
+}
+
+func dial(addr string) (net.Conn, error) {
+	name, err := windows.UTF16PtrFromString(addr)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, 0, 0)
+		if err == nil {
+			return &pipeConn{h: h, addr: pipeAddr(addr)}, nil
+		}
+		if !errors.Is(err, windows.ERROR_PIPE_BUSY) || time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// pipeListener accepts connections on a named pipe using synchronous handles; each
+// accepted connection gets its own pipe instance.
+type pipeListener struct {
+	addr   string
+	name   *uint16
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+	h, err := windows.CreateNamedPipe(l.name,
+		windows.PIPE_ACCESS_DUPLEX,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES, pipeBufferSize, pipeBufferSize, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := windows.ConnectNamedPipe(h, nil); err != nil && !errors.Is(err, windows.ERROR_PIPE_CONNECTED) {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+	select {
+	case <-l.closed:
+		windows.DisconnectNamedPipe(h)
+		windows.CloseHandle(h)
+		return nil, net.ErrClosed
+	default:
+	}
+	return &pipeConn{h: h, addr: pipeAddr(l.addr), server: true}, nil
+}
+
+// Close stops accepting. A blocked Accept is released by connecting to the pipe once.
+func (l *pipeListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		if c, err := dial(l.addr); err == nil {
+			c.Close()
+		}
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr(l.addr) }
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is a byte-mode pipe handle. The control protocol never reads and writes
+// concurrently on one connection, so synchronous I/O is sufficient; deadlines are not supported.
+type pipeConn struct {
+	h      windows.Handle
+	addr   pipeAddr
+	server bool
+	once   sync.Once
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	var n uint32
+	err := windows.ReadFile(c.h, b, &n, nil)
+	if errors.Is(err, windows.ERROR_BROKEN_PIPE) || errors.Is(err, windows.ERROR_PIPE_NOT_CONNECTED) {
+		return int(n), io.EOF
+	}
+	if err == nil && n == 0 && len(b) > 0 {
+		return 0, io.EOF
+	}
+	return int(n), err
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	var n uint32
+	err := windows.WriteFile(c.h, b, &n, nil)
+	return int(n), err
+}
+
+func (c *pipeConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		if c.server {
+			windows.FlushFileBuffers(c.h)
+			windows.DisconnectNamedPipe(c.h)
+		}
+		err = windows.CloseHandle(c.h)
+	})
+	return err
+}
+
+func (c *pipeConn) LocalAddr() net.Addr                { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *pipeConn) SetDeadline(t time.Time) error      { return nil }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,179 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/autostep/autostep/internal/audit"
+	"github.com/autostep/autostep/internal/logging"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/supervisor"
+)
+
+// pollInterval is how often streaming operations check for new state or log lines.
+const pollInterval = 500 * time.Millisecond
+
+// Logger is a minimal logging interface.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Server answers control requests on behalf of the running service.
+type Server struct {
+	paths  paths.Paths
+	sup    *supervisor.Supervisor
+	logger Logger
+	audit  *audit.Log
+}
+
+// NewServer constructs a Server that submits work to sup.
+func NewServer(p paths.Paths, sup *supervisor.Supervisor, logger Logger) *Server {
+	return &Server{paths: p, sup: sup, logger: logger}
+}
+
+// SetAudit records every request except pings in l.
+func (s *Server) SetAudit(l *audit.Log) {
+	s.audit = l
+}
+
+// Serve accepts connections until ctx is cancelled, then closes ln and waits for handlers.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	enc := json.NewEncoder(conn)
+	send := func(resp Response) error { return enc.Encode(resp) }
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		send(Response{Error: fmt.Sprintf("parse request: %v", err)})
+		return
+	}
+	if req.Op != OpPing {
+		s.record(req)
+	}
+	if err := s.dispatch(ctx, req, send); err != nil {
+		send(Response{Error: err.Error()})
+	}
+}
+
+func (s *Server) dispatch(ctx context.Context, req Request, send func(Response) error) error {
+	store := s.sup.Store()
+	switch req.Op {
+	case OpPing:
+		return send(Response{})
+	case OpSubmit:
+		if req.Workflow == "" {
+			return errors.New("submit requires workflow")
+		}
+		h, err := s.sup.SubmitByName(req.Workflow, req.Params)
+		if err != nil {
+			return err
+		}
+		s.logger.Printf("control: submitted workflow %s (run %s)", req.Workflow, h.RunID)
+		return send(Response{RunID: h.RunID})
+	case OpList:
+		return send(Response{Runs: store.Export(), Jobs: s.sup.Jobs()})
+	case OpStatus:
+		rec, ok := store.Export()[req.RunID]
+		if !ok {
+			return fmt.Errorf("run %s not found", req.RunID)
+		}
+		return send(Response{RunID: req.RunID, Run: rec})
+	case OpWatch:
+		return s.watch(ctx, req.RunID, send)
+	case OpCancel:
+		if err := s.sup.Cancel(req.RunID); err != nil {
+			return err
+		}
+		s.logger.Printf("control: cancelled run %s", req.RunID)
+		return send(Response{RunID: req.RunID})
+	case OpLogs:
+		return TailFile(ctx, filepath.Join(s.paths.LogsDir, logging.FileName), req.Lines, req.Follow, func(line string) error {
+			return send(Response{Line: line})
+		})
+	default:
+		return fmt.Errorf("unknown op %q", req.Op)
+	}
+}
+
+func (s *Server) record(req Request) {
+	detail := map[string]string{}
+	if req.Workflow != "" {
+		detail["workflow"] = req.Workflow
+	}
+	for k, v := range req.Params {
+		detail["param."+k] = v
+	}
+	if req.Follow {
+		detail["follow"] = "true"
+	}
+	if err := s.audit.Record(audit.Entry{Source: audit.SourceControl, Action: req.Op, Actor: "local", RunID: req.RunID, Detail: detail}); err != nil {
+		s.logger.Printf("audit: record control %s: %v", req.Op, err)
+	}
+}
+
+// watch streams the run record each time it changes, until the run is no longer queued
+// or running in this service.
+func (s *Server) watch(ctx context.Context, runID string, send func(Response) error) error {
+	var last time.Time
+	for {
+		queued := false
+		for _, j := range s.sup.Jobs() {
+			if j.RunID == runID {
+				queued = true
+			}
+		}
+		rec, ok := s.sup.Store().Export()[runID]
+		if !ok && !queued {
+			return fmt.Errorf("run %s not found", runID)
+		}
+		if ok && !rec.UpdatedAt.Equal(last) {
+			last = rec.UpdatedAt
+			if err := send(Response{RunID: runID, Run: rec}); err != nil {
+				return nil
+			}
+		}
+		if ok && !queued && rec.Status != state.StatusRunning {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.New("service stopping")
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/autostep/autostep/internal/logging"
+)
+
+// tailWindow bounds how much of the end of a log file is read to find the last lines.
+const tailWindow = 1 << 20
+
+// TailFile calls fn with the last n lines of path and, if follow is set, with each line
+// appended afterwards until ctx is cancelled or fn fails. Truncation restarts from the top.
+func TailFile(ctx context.Context, path string, n int, follow bool, fn func(string) error) error {
+	f, err := logging.OpenShared(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	start := info.Size() - tailWindow
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	if start > 0 && len(lines) > 0 {
+		lines = lines[1:] // first line is likely partial
+	}
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for _, l := range lines {
+		if l == "" {
+			continue
+		}
+		if err := fn(l); err != nil {
+			return nil
+		}
+	}
+	if !follow {
+		return nil
+	}
+
+	offset := info.Size()
+	var partial []byte
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(pollInterval):
+		}
+		// Reopen by path each time so a rotated or recreated file is picked up.
+		cur, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if cur.Size() < offset || !os.SameFile(cur, info) {
+			offset, partial, info = 0, nil, cur
+			f.Close()
+			if f, err = logging.OpenShared(path); err != nil {
+				return err
+			}
+		}
+		if cur.Size() == offset {
+			continue
+		}
+		chunk := make([]byte, cur.Size()-offset)
+		nread, err := f.ReadAt(chunk, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		offset += int64(nread)
+		data := append(partial, chunk[:nread]...)
+		lastNL := bytes.LastIndexByte(data, '\n')
+		if lastNL < 0 {
+			partial = data
+			continue
+		}
+		partial = append([]byte(nil), data[lastNL+1:]...)
+		for _, l := range strings.Split(string(data[:lastNL]), "\n") {
+			if err := fn(l); err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Client talks to a coordinator on behalf of one host.
+type Client struct {
+	base  *url.URL
+	token string
+	host  string
+	http  *http.Client
+}
+
+// NewClient returns a client for the coordinator at baseURL. token is sent as a bearer
+// token when set.
+func NewClient(baseURL, token, host string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse coordinator url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("coordinator url %q must be http or https", baseURL)
+	}
+	if !ValidID(host) {
+		return nil, fmt.Errorf("invalid host name %q", host)
+	}
+	return &Client{base: u, token: token, host: host, http: &http.Client{Timeout: 5 * time.Minute}}, nil
+}
+
+// Host returns the host name the client polls for.
+func (c *Client) Host() string { return c.host }
+
+// Jobs returns the jobs currently assigned to the host.
+func (c *Client) Jobs(ctx context.Context) ([]Job, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v1/hosts/"+c.host+"/jobs", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var jobs []Job
+	if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+		return nil, fmt.Errorf("parse jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// Report posts the result of a job.
+func (c *Client) Report(ctx context.Context, res Result) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/v1/hosts/"+c.host+"/jobs/"+res.JobID+"/result", body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Download fetches f to dst, verifying its SHA-256. dst is replaced atomically and is
+// left untouched if the download or verification fails.
+func (c *Client) Download(ctx context.Context, f File, dst string) error {
+	resp, err := c.do(ctx, http.MethodGet, f.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp := dst + ".download"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), resp.Body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("download %s: %w", f.Name, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, f.SHA256) {
+		os.Remove(tmp)
+		return fmt.Errorf("download %s: sha256 mismatch: expected %s got %s", f.Name, f.SHA256, sum)
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (c *Client) do(ctx context.Context, method, ref string, body []byte) (*http.Response, error) {
+	u, err := c.base.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %q: %w", ref, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &StatusError{Code: resp.StatusCode, Msg: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
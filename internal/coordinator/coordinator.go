@@ -0,0 +1,81 @@
+// Package coordinator defines the pull-mode protocol between agents and a fleet
+// coordinator, a client for agents and a minimal reference server.
+//
+//	GET  /v1/hosts/{host}/jobs                 jobs assigned to host without a result yet
+//	GET  /v1/jobs/{id}/workflow                workflow file of a job
+//	GET  /v1/jobs/{id}/workflow.sig            signature of the workflow file, if signed
+//	GET  /v1/jobs/{id}/artifacts/{name}        artifact of a job
+//	POST /v1/hosts/{host}/jobs/{id}/result     result of a job on host
+package coordinator
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/autostep/autostep/internal/state"
+)
+
+// Job is a unit of work assigned to a host.
+type Job struct {
+	ID        string            `json:"id"`
+	Workflow  File              `json:"workflow"`
+	Signature *File             `json:"signature,omitempty"` // workflow signature, if signed
+	Artifacts []File            `json:"artifacts,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+}
+
+// File is a downloadable file with its expected content hash. URL may be relative to
+// the coordinator's base URL.
+type File struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// Result reports the outcome of a job on a host.
+type Result struct {
+	JobID      string             `json:"job_id"`
+	Host       string             `json:"host"`
+	RunID      string             `json:"run_id,omitempty"` // empty if the job could not be started
+	Workflow   string             `json:"workflow,omitempty"`
+	Status     string             `json:"status"` // completed|failed|cancelled
+	Error      string             `json:"error,omitempty"`
+	Steps      []state.StepRecord `json:"steps,omitempty"`
+	StartedAt  time.Time          `json:"started_at,omitempty"`
+	FinishedAt time.Time          `json:"finished_at"`
+}
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidID reports whether s is safe to use as a job ID or host name in URLs and file names.
+func ValidID(s string) bool {
+	return idPattern.MatchString(s) && !strings.Contains(s, "..")
+}
+
+// ValidName reports whether name is a clean relative slash-separated path that stays
+// inside the directory it is resolved against.
+func ValidName(name string) bool {
+	if name == "" || strings.Contains(name, `\`) || path.IsAbs(name) || path.Clean(name) != name {
+		return false
+	}
+	return name != "." && name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// StatusError is returned for non-2xx responses.
+type StatusError struct {
+	Code int
+	Msg  string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("coordinator returned %d: %s", e.Code, e.Msg)
+}
+
+// Permanent reports whether retrying the request cannot succeed.
+func (e *StatusError) Permanent() bool {
+	return e.Code >= 400 && e.Code < 500 && e.Code != 408 && e.Code != 429
+}
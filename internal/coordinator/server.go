@@ -0,0 +1,259 @@
+package coordinator
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/autostep/autostep/internal/signing"
+)
+
+// JobSpec is a job definition read by the reference server from <dir>/jobs/<id>.json.
+// Paths are relative to dir.
+type JobSpec struct {
+	Hosts     []string          `json:"hosts,omitempty"` // empty: every host
+	Workflow  string            `json:"workflow"`
+	Artifacts map[string]string `json:"artifacts,omitempty"` // artifact name -> path
+	Params    map[string]string `json:"params,omitempty"`
+}
+
+// Logger is a minimal logging interface.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Server is a minimal file-backed coordinator. Jobs are JSON files under <dir>/jobs and
+// results are written to <dir>/results/<job-id>/<host>.json; a job is offered to a host
+// until that host has posted a result.
+type Server struct {
+	dir    string
+	token  string
+	logger Logger
+}
+
+// NewServer returns a reference coordinator serving dir. An empty token disables auth.
+func NewServer(dir, token string, logger Logger) *Server {
+	return &Server{dir: dir, token: token, logger: logger}
+}
+
+// Handler returns the HTTP handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/hosts/{host}/jobs", s.listJobs)
+	mux.HandleFunc("GET /v1/jobs/{id}/workflow", s.getWorkflow)
+	mux.HandleFunc("GET /v1/jobs/{id}/workflow.sig", s.getSignature)
+	mux.HandleFunc("GET /v1/jobs/{id}/artifacts/{name...}", s.getArtifact)
+	mux.HandleFunc("POST /v1/hosts/{host}/jobs/{id}/result", s.postResult)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+				http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+	if !ValidID(host) {
+		http.Error(w, "invalid host", http.StatusBadRequest)
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(s.dir, "jobs", "*.json"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(matches)
+	jobs := []Job{}
+	for _, m := range matches {
+		id := strings.TrimSuffix(filepath.Base(m), ".json")
+		if !ValidID(id) {
+			continue
+		}
+		spec, err := s.spec(id)
+		if err != nil {
+			s.logger.Printf("coordinator: skipping job %s: %v", id, err)
+			continue
+		}
+		if !assigned(spec, host) {
+			continue
+		}
+		if _, err := os.Stat(s.resultPath(id, host)); err == nil {
+			continue
+		}
+		job, err := s.job(id, spec)
+		if err != nil {
+			s.logger.Printf("coordinator: skipping job %s: %v", id, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func (s *Server) getWorkflow(w http.ResponseWriter, r *http.Request) {
+	spec, err := s.spec(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.serveFile(w, r, spec.Workflow)
+}
+
+func (s *Server) getSignature(w http.ResponseWriter, r *http.Request) {
+	spec, err := s.spec(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.serveFile(w, r, spec.Workflow+signing.SigExt)
+}
+
+func (s *Server) getArtifact(w http.ResponseWriter, r *http.Request) {
+	spec, err := s.spec(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	rel, ok := spec.Artifacts[r.PathValue("name")]
+	if !ok {
+		http.Error(w, "artifact not found", http.StatusNotFound)
+		return
+	}
+	s.serveFile(w, r, rel)
+}
+
+func (s *Server) postResult(w http.ResponseWriter, r *http.Request) {
+	host, id := r.PathValue("host"), r.PathValue("id")
+	if !ValidID(host) || !ValidID(id) {
+		http.Error(w, "invalid host or job id", http.StatusBadRequest)
+		return
+	}
+	var res Result
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(&res); err != nil {
+		http.Error(w, "parse result: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	res.JobID, res.Host = id, host
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	path := s.resultPath(id, host)
+	if err := writeFileAtomic(path, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.logger.Printf("coordinator: job %s on %s %s (run %s)", id, host, res.Status, res.RunID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) spec(id string) (*JobSpec, error) {
+	if !ValidID(id) {
+		return nil, errors.New("invalid job id")
+	}
+	content, err := os.ReadFile(filepath.Join(s.dir, "jobs", id+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("read job %s: %w", id, err)
+	}
+	var spec JobSpec
+	if err := json.Unmarshal(content, &spec); err != nil {
+		return nil, fmt.Errorf("parse job %s: %w", id, err)
+	}
+	if !ValidName(spec.Workflow) {
+		return nil, fmt.Errorf("job %s: invalid workflow path %q", id, spec.Workflow)
+	}
+	for name, rel := range spec.Artifacts {
+		if !ValidName(name) || !ValidName(rel) {
+			return nil, fmt.Errorf("job %s: invalid artifact %q -> %q", id, name, rel)
+		}
+	}
+	return &spec, nil
+}
+
+// job builds the wire form of a spec, hashing the files it refers to.
+func (s *Server) job(id string, spec *JobSpec) (Job, error) {
+	wf, err := s.describe(spec.Workflow, filepath.Base(spec.Workflow), "/v1/jobs/"+id+"/workflow")
+	if err != nil {
+		return Job{}, err
+	}
+	job := Job{ID: id, Workflow: wf, Params: spec.Params}
+	if sig, err := s.describe(spec.Workflow+signing.SigExt, wf.Name+signing.SigExt, "/v1/jobs/"+id+"/workflow.sig"); err == nil {
+		job.Signature = &sig
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return Job{}, err
+	}
+	names := make([]string, 0, len(spec.Artifacts))
+	for name := range spec.Artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, err := s.describe(spec.Artifacts[name], name, "/v1/jobs/"+id+"/artifacts/"+name)
+		if err != nil {
+			return Job{}, err
+		}
+		job.Artifacts = append(job.Artifacts, f)
+	}
+	return job, nil
+}
+
+func (s *Server) describe(rel, name, url string) (File, error) {
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: name, URL: url, SHA256: hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, rel string) {
+	http.ServeFile(w, r, filepath.Join(s.dir, filepath.FromSlash(rel)))
+}
+
+func (s *Server) resultPath(id, host string) string {
+	return filepath.Join(s.dir, "results", id, host+".json")
+}
+
+func assigned(spec *JobSpec, host string) bool {
+	if len(spec.Hosts) == 0 {
+		return true
+	}
+	for _, h := range spec.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,42 @@
+// Package eventlog forwards key agent events to the platform's system log: the Windows
+// Event Log (source "Autostep") or syslog/journald on Linux.
+//
+// Event ID catalog. IDs are stable; monitoring may match on them.
+//
+//	1000  Info     run started
+//	1001  Info     run resumed (after a reboot or an interrupted shutdown)
+//	1002  Info     run completed
+//	1003  Error    run failed
+//	1004  Warning  run cancelled
+//	1100  Error    step failed
+//	1200  Info     reboot requested by a step
+package eventlog
+
+// Event IDs; see the package documentation.
+const (
+	RunStarted      uint32 = 1000
+	RunResumed      uint32 = 1001
+	RunCompleted    uint32 = 1002
+	RunFailed       uint32 = 1003
+	RunCancelled    uint32 = 1004
+	StepFailed      uint32 = 1100
+	RebootRequested uint32 = 1200
+)
+
+// Sink receives events.
+type Sink interface {
+	Info(eventID uint32, message string) error
+	Warning(eventID uint32, message string) error
+	Error(eventID uint32, message string) error
+	Close() error
+}
+
+// Discard is a Sink that drops every event.
+var Discard Sink = discard{}
+
+type discard struct{}
+
+func (discard) Info(uint32, string) error    { return nil }
+func (discard) Warning(uint32, string) error { return nil }
+func (discard) Error(uint32, string) error   { return nil }
+func (discard) Close() error                 { return nil }
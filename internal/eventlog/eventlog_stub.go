@@ -1,6 +1,22 @@
+//go:build !windows
+
 package eventlog
 
-// Stub for non-Windows builds. In the future, implement structured Event Log writes.
+import "errors"
+
+// syslogSockets are the local syslog datagram sockets tried in order. On systemd
+// systems /dev/log is served by journald.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
 
-func Info(eventID uint32, message string) error  { return nil }
-func Error(eventID uint32, message string) error { return nil }
+// Open returns a Sink writing to the local syslog socket.
+func Open() (Sink, error) {
+	var errs []error
+	for _, addr := range syslogSockets {
+		s, err := NewSyslog(addr, "autostep")
+		if err == nil {
+			return s, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
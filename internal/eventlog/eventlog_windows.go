@@ -8,26 +8,20 @@ import (
 
 const source = "Autostep"
 
-func writer() (*eventlog.Log, error) {
-	return eventlog.Open(source)
-}
-
-// Info writes an informational event.
-func Info(eventID uint32, message string) error {
-	el, err := writer()
+// Open returns a Sink writing to the Windows Event Log under the Autostep source.
+func Open() (Sink, error) {
+	el, err := eventlog.Open(source)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer el.Close()
-	return el.Info(eventID, message)
+	return winSink{el}, nil
 }
 
-// Error writes an error event.
-func Error(eventID uint32, message string) error {
-	el, err := writer()
-	if err != nil {
-		return err
-	}
-	defer el.Close()
-	return el.Error(eventID, message)
+type winSink struct {
+	el *eventlog.Log
 }
+
+func (s winSink) Info(eventID uint32, message string) error    { return s.el.Info(eventID, message) }
+func (s winSink) Warning(eventID uint32, message string) error { return s.el.Warning(eventID, message) }
+func (s winSink) Error(eventID uint32, message string) error   { return s.el.Error(eventID, message) }
+func (s winSink) Close() error                                 { return s.el.Close() }
@@ -0,0 +1,94 @@
+//go:build !windows
+
+package eventlog
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Syslog severities (RFC 5424) and the facility events are logged under.
+const (
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+	facilityDaemon  = 3
+)
+
+// Syslog is a Sink writing RFC 3164 messages to a local unix datagram socket.
+type Syslog struct {
+	addr string
+	tag  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslog connects to the syslog socket at addr; messages are tagged with tag.
+func NewSyslog(addr, tag string) (*Syslog, error) {
+	s := &Syslog{addr: addr, tag: tag}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Syslog) Info(eventID uint32, message string) error {
+	return s.write(severityInfo, eventID, message)
+}
+
+func (s *Syslog) Warning(eventID uint32, message string) error {
+	return s.write(severityWarning, eventID, message)
+}
+
+func (s *Syslog) Error(eventID uint32, message string) error {
+	return s.write(severityError, eventID, message)
+}
+
+// Close closes the socket.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *Syslog) connect() error {
+	conn, err := net.Dial("unixgram", s.addr)
+	if err != nil {
+		return fmt.Errorf("connect to syslog %s: %w", s.addr, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// write sends one message, reconnecting once if the syslog daemon was restarted.
+func (s *Syslog) write(severity int, eventID uint32, message string) error {
+	// Newlines would split the entry; syslog has no escaping for them.
+	message = strings.ReplaceAll(message, "\n", " ")
+	line := fmt.Sprintf("<%d>%s %s[%d]: [%d] %s", facilityDaemon*8+severity,
+		time.Now().Format(time.Stamp), s.tag, os.Getpid(), eventID, message)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if _, err := s.conn.Write([]byte(line)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.connect(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write([]byte(line))
+	return err
+}
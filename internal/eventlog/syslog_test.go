@@ -0,0 +1,113 @@
+//go:build !windows
+
+package eventlog
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listen serves a fake syslog socket at addr.
+func listen(t *testing.T, addr string) *net.UnixConn {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// receive returns the next datagram sent to conn.
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+var messageRE = regexp.MustCompile(`^<(\d+)>([A-Z][a-z]{2} [ 0-9]\d \d{2}:\d{2}:\d{2}) autostep\[(\d+)\]: \[(\d+)\] (.*)$`)
+
+func TestSyslog(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "log")
+	conn := listen(t, addr)
+	s, err := NewSyslog(addr, "autostep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		name     string
+		write    func(uint32, string) error
+		priority int
+		message  string
+		want     string
+	}{
+		{"info", s.Info, 3*8 + 6, "Run r1 of workflow patch started", "Run r1 of workflow patch started"},
+		{"warning", s.Warning, 3*8 + 4, "Run r1 cancelled at step copy", "Run r1 cancelled at step copy"},
+		{"error", s.Error, 3*8 + 3, "step failed:\nexit status 1", "step failed: exit status 1"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := uint32(1000 + i)
+			if err := tt.write(id, tt.message); err != nil {
+				t.Fatal(err)
+			}
+			msg := receive(t, conn)
+			m := messageRE.FindStringSubmatch(msg)
+			if m == nil {
+				t.Fatalf("message %q is not RFC 3164 framed", msg)
+			}
+			if got, _ := strconv.Atoi(m[1]); got != tt.priority {
+				t.Errorf("priority %d (facility %d, severity %d), want %d", got, got/8, got%8, tt.priority)
+			}
+			if _, err := time.Parse(time.Stamp, m[2]); err != nil {
+				t.Errorf("timestamp %q: %v", m[2], err)
+			}
+			if m[3] != strconv.Itoa(os.Getpid()) {
+				t.Errorf("pid %s, want %d", m[3], os.Getpid())
+			}
+			if m[4] != strconv.Itoa(int(id)) {
+				t.Errorf("event id %s, want %d", m[4], id)
+			}
+			if m[5] != tt.want {
+				t.Errorf("message %q, want %q", m[5], tt.want)
+			}
+		})
+	}
+}
+
+// TestSyslogReconnects checks that messages reach a syslog daemon that was restarted.
+func TestSyslogReconnects(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "log")
+	conn := listen(t, addr)
+	s, err := NewSyslog(addr, "autostep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Info(1, "before"); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn)
+
+	conn.Close()
+	os.Remove(addr)
+	conn = listen(t, addr)
+	if err := s.Info(2, "after"); err != nil {
+		t.Fatal(err)
+	}
+	if m := messageRE.FindStringSubmatch(receive(t, conn)); m == nil || m[5] != "after" {
+		t.Fatalf("after restart: %v", m)
+	}
+}
@@ -0,0 +1,78 @@
+// Package events defines the typed run events published by the runner, an in-process
+// bus to observe them, and the journal that persists them so that events from before a
+// reboot can be replayed.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Type identifies an event.
+type Type string
+
+// Event types.
+const (
+	RunStarted      Type = "run.started"
+	RunResumed      Type = "run.resumed"
+	RunCompleted    Type = "run.completed"
+	RunFailed       Type = "run.failed"
+	RunCancelled    Type = "run.cancelled"
+	RunInterrupted  Type = "run.interrupted"
+	StepPending     Type = "step.pending"
+	StepCompleted   Type = "step.completed"
+	StepFailed      Type = "step.failed"
+	RebootRequested Type = "reboot.requested"
+)
+
+// Event is a run transition. Step fields are set for step events, for reboot requests
+// (the rebooting step) and for resumes (the step the run resumes at). BootMode is set
+// for reboot requests and for resumes after a reboot.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      Type      `json:"type"`
+	BootID    string    `json:"boot_id"`
+	RunID     string    `json:"run_id"`
+	Workflow  string    `json:"workflow,omitempty"`
+	StepID    string    `json:"step_id,omitempty"`
+	StepIndex *int      `json:"step_index,omitempty"`
+	Action    string    `json:"action,omitempty"`
+	BootMode  string    `json:"boot_mode,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Bus delivers published events to subscribers. Subscribers are called synchronously,
+// in order, on the publishing goroutine, so they must not block or use the bus.
+type Bus struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]func(Event)
+}
+
+// NewBus returns a Bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: map[int]func(Event){}}
+}
+
+// Subscribe registers fn and returns a function that unregisters it.
+func (b *Bus) Subscribe(fn func(Event)) (cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+// Publish delivers e to every subscriber.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, fn := range b.subs {
+		fn(e)
+	}
+}
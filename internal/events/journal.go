@@ -0,0 +1,197 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/autostep/autostep/internal/logging"
+)
+
+// journalRotation bounds the journal: it is rotated at 5 MB and one rotated file is
+// kept, so replay covers at least the last 5 MB of events.
+var journalRotation = logging.Rotation{MaxSize: 5 << 20, Keep: 1}
+
+// followInterval is how often Follow checks the journal for new events.
+const followInterval = 500 * time.Millisecond
+
+// Journal appends events to a JSON-lines file shared by every agent process.
+type Journal struct {
+	f *logging.RotatingFile
+}
+
+// OpenJournal opens the journal at path for appending.
+func OpenJournal(path string) (*Journal, error) {
+	f, err := logging.OpenRotating(path, journalRotation)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{f: f}, nil
+}
+
+// Append writes e as one line.
+func (j *Journal) Append(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = j.f.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the journal.
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
+
+// Filter selects events to replay.
+type Filter struct {
+	RunID string
+	Since time.Time
+}
+
+func (f Filter) match(e Event) bool {
+	return (f.RunID == "" || e.RunID == f.RunID) && !e.Time.Before(f.Since)
+}
+
+// Replay calls fn with every journaled event matching filter, oldest first, including
+// those in the rotated file. The raw line is passed along with the decoded event.
+func Replay(path string, filter Filter, fn func(Event, []byte) error) error {
+	for _, name := range append(rotatedFiles(path), path) {
+		if err := replayFile(name, filter, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotatedFiles returns the rotated journal files, oldest first.
+func rotatedFiles(path string) []string {
+	ext := filepath.Ext(path)
+	rotated, _ := filepath.Glob(strings.TrimSuffix(path, ext) + "-*" + ext)
+	sort.Strings(rotated)
+	return rotated
+}
+
+func replayFile(name string, filter Filter, fn func(Event, []byte) error) error {
+	f, err := logging.OpenShared(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if err := emit(sc.Bytes(), filter, fn); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func emit(line []byte, filter Filter, fn func(Event, []byte) error) error {
+	var e Event
+	if json.Unmarshal(line, &e) != nil || !filter.match(e) {
+		return nil
+	}
+	return fn(e, line)
+}
+
+// Follow replays the journal like Replay and then passes each matching event appended
+// afterwards to fn until ctx is cancelled or fn fails.
+func Follow(ctx context.Context, path string, filter Filter, fn func(Event, []byte) error) error {
+	for _, name := range rotatedFiles(path) {
+		if err := replayFile(name, filter, fn); err != nil {
+			return err
+		}
+	}
+	t := &tail{filter: filter, fn: fn}
+	defer t.close()
+	for {
+		if err := t.poll(path); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(followInterval):
+		}
+	}
+}
+
+// tail reads the lines appended to the journal, switching to the new file after a
+// rotation once the old one has been read to the end.
+type tail struct {
+	filter  Filter
+	fn      func(Event, []byte) error
+	f       *os.File
+	offset  int64
+	partial []byte
+}
+
+func (t *tail) poll(path string) error {
+	cur, err := os.Stat(path)
+	if err != nil {
+		return nil // not created yet, or being rotated
+	}
+	if t.f != nil {
+		open, err := t.f.Stat()
+		if err == nil && os.SameFile(cur, open) && cur.Size() >= t.offset {
+			return t.read()
+		}
+		if err := t.read(); err != nil {
+			return err
+		}
+		t.close()
+	}
+	if t.f, err = logging.OpenShared(path); err != nil {
+		return err
+	}
+	t.offset, t.partial = 0, nil
+	return t.read()
+}
+
+// read passes the complete lines appended since the last read to fn.
+func (t *tail) read() error {
+	info, err := t.f.Stat()
+	if err != nil || info.Size() <= t.offset {
+		return nil
+	}
+	chunk := make([]byte, info.Size()-t.offset)
+	n, err := t.f.ReadAt(chunk, t.offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	t.offset += int64(n)
+	data := append(t.partial, chunk[:n]...)
+	last := bytes.LastIndexByte(data, '\n')
+	if last < 0 {
+		t.partial = data
+		return nil
+	}
+	t.partial = append([]byte(nil), data[last+1:]...)
+	for _, line := range bytes.Split(data[:last], []byte("\n")) {
+		if err := emit(line, t.filter, t.fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tail) close() {
+	if t.f != nil {
+		t.f.Close()
+		t.f = nil
+	}
+}
@@ -0,0 +1,227 @@
+// Package fetch downloads artifacts from https://, http:// and file:// URLs into the
+// content-addressed store (see package cas). Every fetch names the sha256 of the content
+// it expects; a blob already stored is used without contacting the source, so fetched
+// artifacts stay usable without a network (e.g. in Safe Mode).
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/autostep/autostep/internal/cas"
+	"github.com/autostep/autostep/internal/filelock"
+)
+
+// ErrNoHash is returned for a URL fetched without a sha256 to verify it against.
+var ErrNoHash = errors.New("fetching a URL requires a sha256")
+
+// IsURL reports whether s is a URL this package fetches.
+func IsURL(s string) bool {
+	lower := strings.ToLower(s)
+	for _, scheme := range []string{"https://", "http://", "file://"} {
+		if strings.HasPrefix(lower, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetcher downloads URLs into the store.
+type Fetcher struct {
+	Client   *http.Client // nil uses http.DefaultClient
+	Attempts int          // download attempts, each resuming the last; 0 means 3
+}
+
+// Default is the Fetcher used by runs and the prefetch command.
+var Default = &Fetcher{}
+
+// Fetch makes the content of rawURL, which must have the given sha256, available in the
+// store under cacheDir and returns its path. fetched is false if the blob was already
+// stored. HTTP downloads go to <hash>.partial in the store and resume from there after
+// an interruption, including one by a reboot; the blob appears only once verified.
+func (f *Fetcher) Fetch(ctx context.Context, cacheDir, rawURL, hash string) (path string, fetched bool, err error) {
+	if hash == "" {
+		return "", false, fmt.Errorf("%s: %w", rawURL, ErrNoHash)
+	}
+	if !cas.ValidHash(hash) {
+		return "", false, fmt.Errorf("%s: invalid sha256 %q", rawURL, hash)
+	}
+	if cas.Has(cacheDir, hash) {
+		return cas.Path(cacheDir, hash), false, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false, fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "file":
+		file, err := localPath(u)
+		if err == nil {
+			_, _, err = cas.IngestFile(cacheDir, file, hash)
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("fetch %s: %w", rawURL, err)
+		}
+	case "http", "https":
+		if err := f.download(ctx, cacheDir, u, hash); err != nil {
+			return "", false, fmt.Errorf("fetch %s: %w", redactURL(u), err)
+		}
+	default:
+		return "", false, fmt.Errorf("fetch %s: unsupported scheme %q", rawURL, u.Scheme)
+	}
+	return cas.Path(cacheDir, hash), true, nil
+}
+
+// download fetches u into the store, resuming a partial download left by an earlier
+// attempt. Concurrent downloads of the same blob are serialized with a file lock.
+func (f *Fetcher) download(ctx context.Context, cacheDir string, u *url.URL, hash string) error {
+	dir := filepath.Join(cacheDir, cas.Dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	partial := cas.Path(cacheDir, hash) + ".partial"
+	lock, err := filelock.Open(partial + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	if cas.Has(cacheDir, hash) {
+		return nil // another process finished it while we waited
+	}
+
+	attempts := f.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	for attempt := 1; ; attempt++ {
+		err = f.get(ctx, u, partial)
+		if err == nil {
+			break
+		}
+		var se *statusError
+		if ctx.Err() != nil || attempt >= attempts || (errors.As(err, &se) && !se.retryable()) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+	if err := cas.Adopt(cacheDir, partial, hash); err != nil {
+		if errors.Is(err, cas.ErrHashMismatch) {
+			// Restart from scratch next time rather than resume wrong content.
+			os.Remove(partial)
+		}
+		return err
+	}
+	return nil
+}
+
+// get downloads u to the partial file, asking only for the bytes it lacks.
+func (f *Fetcher) get(ctx context.Context, u *url.URL, partial string) error {
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			out.Truncate(0)
+			return fmt.Errorf("server resumed at the wrong offset (Content-Range %q)", resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// Everything is here already; the hash check decides.
+		return nil
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range (or there was none): start over.
+		if err := out.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	default:
+		return &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return "server returned " + e.status }
+
+// retryable reports whether the request may succeed if repeated.
+func (e *statusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests || e.code == http.StatusRequestTimeout
+}
+
+// localPath converts a file:// URL to a path: file:///C:/dir/f on Windows is C:\dir\f,
+// and file://server/share/f is the UNC path \\server\share\f.
+func localPath(u *url.URL) (string, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		if runtime.GOOS != "windows" {
+			return "", fmt.Errorf("file URL with host %q is only supported on Windows", u.Host)
+		}
+		return `\\` + u.Host + filepath.FromSlash(u.Path), nil
+	}
+	p := u.Path
+	if runtime.GOOS == "windows" && len(p) > 2 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	if p == "" {
+		return "", errors.New("file URL has no path")
+	}
+	return filepath.FromSlash(p), nil
+}
+
+// redactURL drops credentials and the query, which may carry tokens, from errors.
+func redactURL(u *url.URL) string {
+	c := *u
+	c.User = nil
+	c.RawQuery = ""
+	return c.String()
+}
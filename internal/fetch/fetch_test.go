@@ -0,0 +1,249 @@
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/autostep/autostep/internal/cas"
+)
+
+const content = "autostep test artifact: 0123456789abcdefghijklmnopqrstuvwxyz"
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// server answers with handle and records the Range header of every request.
+type server struct {
+	*httptest.Server
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newServer(t *testing.T, handle func(w http.ResponseWriter, r *http.Request)) *server {
+	t.Helper()
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+		handle(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// requested returns the Range headers of the requests so far.
+func (s *server) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+// rangeServer honours "bytes=N-" ranges of body.
+func rangeServer(t *testing.T, body string) *server {
+	return newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var offset int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset); err != nil {
+			w.Write([]byte(body))
+			return
+		}
+		if offset >= len(body) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(body)-1, len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(body[offset:]))
+	})
+}
+
+// withPartial leaves data behind as an interrupted download of hash.
+func withPartial(t *testing.T, cacheDir, hash, data string) string {
+	t.Helper()
+	partial := cas.Path(cacheDir, hash) + ".partial"
+	if err := os.MkdirAll(filepath.Dir(partial), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return partial
+}
+
+func checkBlob(t *testing.T, cacheDir, path, want string) {
+	t.Helper()
+	if path != cas.Path(cacheDir, sum(want)) {
+		t.Fatalf("path %s, want the blob's path", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Fatalf("blob holds %q, want %q", data, want)
+	}
+	if _, err := os.Stat(path + ".partial"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("partial download left behind: %v", err)
+	}
+}
+
+func TestFetchResumesPartialDownload(t *testing.T) {
+	cacheDir := t.TempDir()
+	hash := sum(content)
+	withPartial(t, cacheDir, hash, content[:20])
+	srv := rangeServer(t, content)
+
+	path, fetched, err := Default.Fetch(context.Background(), cacheDir, srv.URL+"/a.bin", hash)
+	if err != nil || !fetched {
+		t.Fatalf("Fetch() = %v, %v", fetched, err)
+	}
+	checkBlob(t, cacheDir, path, content)
+	if got := srv.requested(); len(got) != 1 || got[0] != "bytes=20-" {
+		t.Fatalf("requested ranges %q, want bytes=20-", got)
+	}
+
+	// A stored blob is used without asking the server again.
+	if _, fetched, err := Default.Fetch(context.Background(), cacheDir, srv.URL+"/a.bin", hash); err != nil || fetched {
+		t.Fatalf("second Fetch() = %v, %v; want the stored blob", fetched, err)
+	}
+	if got := srv.requested(); len(got) != 1 {
+		t.Fatalf("%d requests, want 1", len(got))
+	}
+}
+
+func TestFetchCompletePartialDownload(t *testing.T) {
+	cacheDir := t.TempDir()
+	hash := sum(content)
+	withPartial(t, cacheDir, hash, content)
+	srv := rangeServer(t, content)
+
+	path, _, err := Default.Fetch(context.Background(), cacheDir, srv.URL+"/a.bin", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlob(t, cacheDir, path, content)
+}
+
+// TestFetchServerIgnoresRange checks that a 200 answer to a range request replaces the
+// partial download instead of being appended to it.
+func TestFetchServerIgnoresRange(t *testing.T) {
+	cacheDir := t.TempDir()
+	hash := sum(content)
+	withPartial(t, cacheDir, hash, "stale bytes from another server")
+	srv := newServer(t, func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(content)) })
+
+	path, _, err := Default.Fetch(context.Background(), cacheDir, srv.URL+"/a.bin", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlob(t, cacheDir, path, content)
+	if srv.requested()[0] == "" {
+		t.Fatal("the partial download was not resumed with a range request")
+	}
+}
+
+func TestFetchWrongContentRange(t *testing.T) {
+	cacheDir := t.TempDir()
+	hash := sum(content)
+	partial := withPartial(t, cacheDir, hash, content[:20])
+	srv := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 10-%d/%d", len(content)-1, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(content[10:]))
+	})
+
+	f := &Fetcher{Attempts: 1}
+	if _, _, err := f.Fetch(context.Background(), cacheDir, srv.URL+"/a.bin", hash); err == nil || !strings.Contains(err.Error(), "wrong offset") {
+		t.Fatalf("Fetch() = %v, want a wrong offset error", err)
+	}
+	if fi, err := os.Stat(partial); err != nil || fi.Size() != 0 {
+		t.Fatalf("partial download not discarded: %v", err)
+	}
+}
+
+func TestFetchHashMismatch(t *testing.T) {
+	cacheDir := t.TempDir()
+	hash := sum(content)
+	srv := newServer(t, func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("something else")) })
+
+	_, _, err := Default.Fetch(context.Background(), cacheDir, srv.URL+"/a.bin?token=secret", hash)
+	if !errors.Is(err, cas.ErrHashMismatch) {
+		t.Fatalf("Fetch() = %v, want ErrHashMismatch", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q shows the query", err)
+	}
+	if cas.Has(cacheDir, hash) {
+		t.Fatal("mismatching content stored")
+	}
+	if _, err := os.Stat(cas.Path(cacheDir, hash) + ".partial"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("mismatching partial download kept for resuming: %v", err)
+	}
+}
+
+func TestFetchFileURL(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "driver v1.sys")
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	u := (&url.URL{Scheme: "file", Path: filepath.ToSlash(src)}).String()
+	if runtime.GOOS == "windows" {
+		u = (&url.URL{Scheme: "file", Path: "/" + filepath.ToSlash(src)}).String()
+	}
+
+	cacheDir := t.TempDir()
+	if _, _, err := Default.Fetch(context.Background(), cacheDir, u, sum("other")); !errors.Is(err, cas.ErrHashMismatch) {
+		t.Fatalf("Fetch() with the wrong hash = %v, want ErrHashMismatch", err)
+	}
+	path, fetched, err := Default.Fetch(context.Background(), cacheDir, u, sum(content))
+	if err != nil || !fetched {
+		t.Fatalf("Fetch(%s) = %v, %v", u, fetched, err)
+	}
+	checkBlob(t, cacheDir, path, content)
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("source file gone after fetching: %v", err)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		url, unix, windows string
+	}{
+		{"file:///srv/drivers/a.sys", "/srv/drivers/a.sys", `\srv\drivers\a.sys`},
+		{"file://localhost/srv/a.sys", "/srv/a.sys", `\srv\a.sys`},
+		{"file:///C:/drivers/a.sys", "/C:/drivers/a.sys", `C:\drivers\a.sys`},
+		{"file://server/share/a.sys", "", `\\server\share\a.sys`},
+		{"file://", "", ""},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := tt.unix
+		if runtime.GOOS == "windows" {
+			want = tt.windows
+		}
+		got, err := localPath(u)
+		switch {
+		case want == "" && err == nil:
+			t.Errorf("localPath(%s) = %q, want an error", tt.url, got)
+		case want != "" && (err != nil || got != want):
+			t.Errorf("localPath(%s) = %q, %v; want %q", tt.url, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Package filelock provides advisory, cross-process exclusive locks on lock files.
+package filelock
+
+import (
+	"fmt"
+	"os"
+)
+
+// File is an open lock file. Lock blocks until no other process holds the lock; the
+// lock is released by Unlock or when the process exits.
+type File struct {
+	f *os.File
+}
+
+// Open opens (creating if needed) the lock file at path.
+func Open(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock %s: %w", path, err)
+	}
+	return &File{f: f}, nil
+}
+
+// Lock acquires the exclusive lock.
+func (l *File) Lock() error {
+	if err := lock(l.f); err != nil {
+		return fmt.Errorf("lock %s: %w", l.f.Name(), err)
+	}
+	return nil
+}
+
+// Unlock releases the lock.
+func (l *File) Unlock() error {
+	return unlock(l.f)
+}
+
+// Close releases the lock, if held, and closes the file.
+func (l *File) Close() error {
+	return l.f.Close()
+}
@@ -1,22 +1,73 @@
+// Package logging sets up the agent's structured logger: JSON lines in logs/autostep.log
+// and human-readable lines on the console, both built on log/slog.
 package logging
 
 import (
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/autostep/autostep/internal/bootid"
 )
 
-// Setup returns a logger that writes to stdout and a rotating log file path (no rotation implemented yet).
-func Setup(logsDir string) (*log.Logger, error) {
+// FileName is the name of the agent log inside the logs directory.
+const FileName = "autostep.log"
+
+// Logger is a slog.Logger with the Printf-style helpers used for unstructured messages.
+type Logger struct {
+	*slog.Logger
+}
+
+// Printf logs a formatted message at info level.
+func (l *Logger) Printf(format string, v ...any) {
+	l.Info(fmt.Sprintf(format, v...))
+}
+
+// Println logs its operands at info level.
+func (l *Logger) Println(v ...any) {
+	l.Info(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+// Fatalf logs a formatted message at error level and exits with status 1.
+func (l *Logger) Fatalf(format string, v ...any) {
+	l.Error(fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+// With returns a Logger that adds args to every entry.
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{l.Logger.With(args...)}
+}
+
+// ParseLevel parses debug, info, warn or error (case-insensitive).
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// Setup returns a logger writing JSON entries tagged with the boot ID to the agent log,
+// rotated according to rot, and human-readable entries to stdout.
+func Setup(logsDir string, level slog.Level, rot Rotation) (*Logger, error) {
 	if err := os.MkdirAll(logsDir, 0o755); err != nil {
 		return nil, err
 	}
-	f, err := os.OpenFile(filepath.Join(logsDir, "autostep.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	f, err := OpenRotating(filepath.Join(logsDir, FileName), rot)
 	if err != nil {
 		return nil, err
 	}
-	mw := io.MultiWriter(os.Stdout, f)
-	logger := log.New(mw, "", log.LstdFlags|log.Lmicroseconds)
-	return logger, nil
+	return New(f, os.Stdout, level), nil
+}
+
+// New returns a logger writing JSON entries to file and console entries to console.
+// Secret values registered with package redact are masked in both.
+func New(file, console io.Writer, level slog.Level) *Logger {
+	jsonHandler := slog.NewJSONHandler(file, &slog.HandlerOptions{Level: level}).
+		WithAttrs([]slog.Attr{slog.String("boot_id", bootid.Current())})
+	return &Logger{slog.New(redactHandler{fanout{jsonHandler, newConsoleHandler(console, level)}})}
 }
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package logging
+
+import "os"
+
+func openAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+}
+
+// OpenShared opens path for reading without preventing another process from rotating it.
+func OpenShared(path string) (*os.File, error) {
+	return os.Open(path)
+}
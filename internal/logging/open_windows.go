@@ -0,0 +1,34 @@
+//go:build windows
+
+package logging
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// shareAll lets other processes read, write, rename and delete the file while it is
+// open, which rotation by another process requires on Windows.
+const shareAll = windows.FILE_SHARE_READ | windows.FILE_SHARE_WRITE | windows.FILE_SHARE_DELETE
+
+func openAppend(path string) (*os.File, error) {
+	return open(path, windows.FILE_APPEND_DATA|windows.FILE_READ_ATTRIBUTES|windows.SYNCHRONIZE, windows.OPEN_ALWAYS)
+}
+
+// OpenShared opens path for reading without preventing another process from rotating it.
+func OpenShared(path string) (*os.File, error) {
+	return open(path, windows.GENERIC_READ, windows.OPEN_EXISTING)
+}
+
+func open(path string, access, disposition uint32) (*os.File, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(name, access, shareAll, nil, disposition, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
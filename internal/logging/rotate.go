@@ -0,0 +1,246 @@
+package logging
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autostep/autostep/internal/filelock"
+)
+
+// rotatedTimeFormat names rotated files so that they sort chronologically.
+const rotatedTimeFormat = "20060102T150405.000"
+
+// Rotation configures when the agent log is rotated and how many rotated files are kept.
+type Rotation struct {
+	MaxSize  int64         // rotate before the file would exceed this many bytes; 0 disables
+	MaxAge   time.Duration // rotate once the file's first entry is older than this; 0 disables
+	Keep     int           // rotated files to keep; 0 keeps all
+	Compress bool          // gzip rotated files
+}
+
+// RotatingFile is an append-only log file that rotates itself. Writers in several
+// processes may share it: every write holds an exclusive lock on "<path>.lock" and
+// reopens the file if another process rotated it.
+type RotatingFile struct {
+	path string
+	rot  Rotation
+	lock *filelock.File
+
+	mu      sync.Mutex
+	f       *os.File
+	started time.Time // time of the first entry in the current file
+}
+
+// OpenRotating opens path for appending.
+func OpenRotating(path string, rot Rotation) (*RotatingFile, error) {
+	lock, err := filelock.Open(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	r := &RotatingFile{path: path, rot: rot, lock: lock}
+	if err := r.open(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write appends p, rotating first if p would exceed the size limit or the file is too old.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.lock.Lock(); err != nil {
+		return 0, err
+	}
+	defer r.lock.Unlock()
+
+	if moved(r.path, r.f) {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.due(len(p)) {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	if r.started.IsZero() {
+		r.started = time.Now()
+	}
+	return r.f.Write(p)
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lock.Close()
+	return r.f.Close()
+}
+
+func (r *RotatingFile) due(n int) bool {
+	info, err := r.f.Stat()
+	if err != nil || info.Size() == 0 {
+		return false
+	}
+	if r.rot.MaxSize > 0 && info.Size()+int64(n) > r.rot.MaxSize {
+		return true
+	}
+	return r.rot.MaxAge > 0 && !r.started.IsZero() && time.Since(r.started) > r.rot.MaxAge
+}
+
+// rotate renames the current file aside and starts a new one. Compression and pruning
+// of rotated files happen in the background.
+func (r *RotatingFile) rotate() error {
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext)
+	name := fmt.Sprintf("%s-%s%s", base, time.Now().UTC().Format(rotatedTimeFormat), ext)
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%s.%d%s", base, time.Now().UTC().Format(rotatedTimeFormat), i, ext)
+	}
+	r.f.Close()
+	renameErr := os.Rename(r.path, name)
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	go finishRotation(base, ext, r.rot)
+	return nil
+}
+
+// finishRotation compresses rotated files (including any left uncompressed by a process
+// that exited mid-way) and prunes the oldest beyond the limit.
+func finishRotation(base, ext string, rot Rotation) {
+	if rot.Compress {
+		matches, _ := filepath.Glob(base + "-*" + ext)
+		for _, name := range matches {
+			if err := compress(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "compress %s: %v\n", name, err)
+			}
+		}
+	}
+	prune(base, ext, rot.Keep)
+}
+
+func (r *RotatingFile) open() error {
+	if r.f != nil {
+		r.f.Close()
+	}
+	f, err := openAppend(r.path)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.started = firstEntryTime(r.path)
+	return nil
+}
+
+// moved reports whether path no longer names the file f has open.
+func moved(path string, f *os.File) bool {
+	cur, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	open, err := f.Stat()
+	return err != nil || !os.SameFile(cur, open)
+}
+
+// firstEntryTime returns the time of the first JSON entry in path, its modification time
+// for other content, or the zero time if it is empty.
+func firstEntryTime(path string) time.Time {
+	f, err := OpenShared(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(io.LimitReader(f, 64*1024)).ReadBytes('\n')
+	if len(line) == 0 {
+		return time.Time{}
+	}
+	var entry struct {
+		Time time.Time `json:"time"`
+	}
+	if json.Unmarshal(line, &entry) == nil && !entry.Time.IsZero() {
+		return entry.Time
+	}
+	if info, err := f.Stat(); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// compress gzips name to name.gz and removes name. Concurrent compressions of the same
+// file by several processes are harmless: each writes its own temporary file.
+func compress(name string) error {
+	in, err := OpenShared(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".gz.*.tmp")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), name+".gz")
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	in.Close()
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// prune deletes the oldest rotated files beyond keep and temporary files abandoned by
+// interrupted compressions.
+func prune(base, ext string, keep int) {
+	matches, _ := filepath.Glob(base + "-*" + ext + "*")
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && strings.HasSuffix(m, ".tmp") && time.Since(info.ModTime()) > time.Hour {
+			os.Remove(m)
+		}
+	}
+	if keep <= 0 {
+		return
+	}
+	var rotated []string
+	for _, m := range matches {
+		if strings.HasSuffix(m, ext) || strings.HasSuffix(m, ext+".gz") {
+			rotated = append(rotated, m)
+		}
+	}
+	sort.Strings(rotated)
+	for len(rotated) > keep {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,185 @@
+package manifest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/autostep/autostep/internal/cas"
+	"github.com/autostep/autostep/internal/fetch"
+	"github.com/autostep/autostep/internal/paths"
+)
+
+// Errors wrapped by Artifact.Check.
+var (
+	ErrArtifactMissing = errors.New("missing")
+	ErrArtifactCorrupt = errors.New("corrupted")
+)
+
+// Artifact is a file in the artifact cache that a workflow depends on. With SHA256 set,
+// Path is an alias of the stored blob of that content when there is one: cache:// paths
+// of the workflow's runs resolve to the blob rather than to the file of that name.
+type Artifact struct {
+	Path   string `json:"path"`             // relative to the cache or "sha256:<hex>"; a cache:// prefix is allowed
+	SHA256 string `json:"sha256,omitempty"` // hex; checked when set, required with URL
+	Size   int64  `json:"size,omitempty"`   // bytes; checked when set
+	URL    string `json:"url,omitempty"`    // https://, http:// or file:// source fetched into the store
+}
+
+// UnmarshalJSON also accepts a plain path, the form older manifests use.
+func (a *Artifact) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*a = Artifact{Path: path}
+		return nil
+	}
+	type plain Artifact
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+// Name returns the artifact's path relative to the cache, with forward slashes.
+func (a Artifact) Name() string {
+	return strings.ReplaceAll(strings.TrimPrefix(a.Path, paths.CacheScheme), `\`, "/")
+}
+
+// Hash returns the hash the artifact's content must have: its SHA256, or the hash in a
+// blob name.
+func (a Artifact) Hash() string {
+	if hash, ok := cas.ParseName(a.Name()); ok {
+		return hash
+	}
+	return strings.ToLower(a.SHA256)
+}
+
+// File returns the artifact's location in the cache directory: the stored blob if there
+// is one, else the file of its name.
+func (a Artifact) File(cacheDir string) (string, error) {
+	if hash, ok := cas.ParseName(a.Name()); ok {
+		return cas.Path(cacheDir, hash), nil
+	}
+	if cas.ValidHash(a.SHA256) && cas.Has(cacheDir, a.SHA256) {
+		return cas.Path(cacheDir, a.SHA256), nil
+	}
+	file, err := paths.Join(cacheDir, a.Name())
+	if err != nil {
+		return "", fmt.Errorf("artifact %s: %w", a.Name(), err)
+	}
+	return file, nil
+}
+
+// Check verifies the artifact in the cache directory against its size and hash. The
+// error wraps ErrArtifactMissing or ErrArtifactCorrupt.
+func (a Artifact) Check(cacheDir string) error {
+	file, err := a.File(cacheDir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("artifact %s: %w", a.Name(), ErrArtifactMissing)
+	}
+	if err != nil {
+		return fmt.Errorf("artifact %s: %w", a.Name(), err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("artifact %s: %w", a.Name(), err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("artifact %s: %w: is a directory", a.Name(), ErrArtifactCorrupt)
+	}
+	if a.Size > 0 && info.Size() != a.Size {
+		return fmt.Errorf("artifact %s: %w: size %d, expected %d", a.Name(), ErrArtifactCorrupt, info.Size(), a.Size)
+	}
+	want := a.Hash()
+	if a.SHA256 != "" && !strings.EqualFold(a.SHA256, want) {
+		return fmt.Errorf("artifact %s: declared sha256 %s does not match its name", a.Name(), a.SHA256)
+	}
+	if want == "" {
+		return nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("artifact %s: %w", a.Name(), err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != want {
+		return fmt.Errorf("artifact %s: %w: sha256 %s, expected %s", a.Name(), ErrArtifactCorrupt, sum, want)
+	}
+	return nil
+}
+
+// Fetch downloads the artifact from its URL into the store unless the blob is already
+// there; fetched reports whether it was downloaded. Artifacts without a URL are left
+// alone.
+func (a Artifact) Fetch(ctx context.Context, f *fetch.Fetcher, cacheDir string) (fetched bool, err error) {
+	if a.URL == "" {
+		return false, nil
+	}
+	if _, fetched, err = f.Fetch(ctx, cacheDir, a.URL, a.Hash()); err != nil {
+		return false, fmt.Errorf("artifact %s: %w", a.Name(), err)
+	}
+	return fetched, nil
+}
+
+// FetchArtifacts fetches the workflow's artifacts that have a URL and are not stored yet.
+func (w WorkflowRef) FetchArtifacts(ctx context.Context, f *fetch.Fetcher, cacheDir string) error {
+	var errs []error
+	for _, a := range w.Artifacts {
+		if _, err := a.Fetch(ctx, f, cacheDir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("workflow %s: %w", w.Name, errors.Join(errs...))
+	}
+	return nil
+}
+
+// VerifyArtifacts checks every artifact of the workflow in the cache directory, so that
+// a run does not start with missing or corrupted files.
+func (w WorkflowRef) VerifyArtifacts(cacheDir string) error {
+	var errs []error
+	for _, a := range w.Artifacts {
+		if err := a.Check(cacheDir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("workflow %s: %w", w.Name, errors.Join(errs...))
+	}
+	return nil
+}
+
+// Aliases returns the cache:// names of the workflow's artifacts that are stored as
+// blobs, mapped to their hashes, for paths.Resolver.
+func (w WorkflowRef) Aliases(cacheDir string) map[string]string {
+	aliases := map[string]string{}
+	for _, a := range w.Artifacts {
+		if _, blob := cas.ParseName(a.Name()); blob || !cas.ValidHash(a.SHA256) {
+			continue
+		}
+		if cas.Has(cacheDir, a.SHA256) {
+			aliases[path.Clean(a.Name())] = strings.ToLower(a.SHA256)
+		}
+	}
+	return aliases
+}
+
+// Blobs returns the hashes of the blobs the workflow's artifacts name or alias.
+func (w WorkflowRef) Blobs() []string {
+	var hashes []string
+	for _, a := range w.Artifacts {
+		if hash := a.Hash(); cas.ValidHash(hash) {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
@@ -2,45 +2,278 @@ package manifest
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/autostep/autostep/internal/filelock"
+	"github.com/autostep/autostep/internal/signing"
 )
 
 // WorkflowRef describes a workflow entry in manifest.json.
 type WorkflowRef struct {
-	Name      string   `json:"name"`
-	Path      string   `json:"path"`
-	Version   string   `json:"version,omitempty"`
-	Artifacts []string `json:"artifacts,omitempty"`
+	Name      string     `json:"name"`
+	Path      string     `json:"path"`
+	Version   string     `json:"version,omitempty"`
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	Triggers  []Trigger  `json:"triggers,omitempty"`
+
+	// Source is the manifest file the entry was read from.
+	Source string `json:"-"`
 }
 
-// Manifest maps workflow names to definitions/artifacts.
+// Trigger types understood by the service.
+const (
+	TriggerBoot           = "boot"
+	TriggerFileDrop       = "file_drop"
+	TriggerManifestChange = "manifest_change"
+)
+
+// Trigger declares an event that makes the service start the workflow on its own.
+type Trigger struct {
+	Type    string `json:"type"`              // boot|file_drop|manifest_change
+	Every   int    `json:"every,omitempty"`   // boot: fire on every Nth boot (default 1)
+	Pattern string `json:"pattern,omitempty"` // file_drop: glob matched against inbox file names (default "<name>*.job")
+}
+
+// ResolvePath returns the workflow file path, resolving relative paths against the data root.
+func (w WorkflowRef) ResolvePath(root string) string {
+	if filepath.IsAbs(w.Path) {
+		return w.Path
+	}
+	clean := filepath.Clean(w.Path)
+	// If the manifest path already starts with "workflows", treat it as relative to root to avoid double "workflows/workflows".
+	if strings.HasPrefix(clean, "workflows"+string(filepath.Separator)) || strings.HasPrefix(clean, "workflows/") {
+		return filepath.Join(root, clean)
+	}
+	return filepath.Join(root, "workflows", clean)
+}
+
+// Manifest maps workflow names to definitions/artifacts. A name may have several
+// entries with different versions.
 type Manifest struct {
 	Workflows []WorkflowRef `json:"workflows"`
+
+	// Skipped holds why fragments, or entries of fragments, were left out by Load.
+	Skipped []error `json:"-"`
+}
+
+// DropInDir returns the directory of manifest fragments merged with the manifest at path.
+func DropInDir(path string) string {
+	return filepath.Join(filepath.Dir(path), "manifest.d")
 }
 
-// Load reads a manifest from disk.
+// Load reads the manifest at path and merges the fragments ("*.json") in its drop-in
+// directory, in lexical order of file name. Fragments have the manifest's format and are
+// signature-checked like it. A fragment that cannot be read, or fails its signature
+// check, is left out and recorded in Skipped, so that one team's broken file does not
+// take every workflow down. So is an entry for a workflow version that an earlier file
+// already defines: teams editing separate files cannot silently override each other.
+// The manifest itself may be missing if there are fragments.
 func Load(path string) (*Manifest, error) {
+	m, err := LoadFile(path)
+	missing := errors.Is(err, os.ErrNotExist)
+	if err != nil && !missing {
+		return nil, err
+	}
+	fragments, globErr := filepath.Glob(filepath.Join(DropInDir(path), "*.json"))
+	if globErr != nil {
+		return nil, globErr
+	}
+	if missing {
+		if len(fragments) == 0 {
+			return nil, err
+		}
+		m = &Manifest{}
+	}
+	sort.Strings(fragments)
+	seen := map[string]string{}
+	for _, ref := range m.Workflows {
+		seen[ref.Name+"@"+ref.Version] = ref.Source
+	}
+	for _, file := range fragments {
+		frag, err := LoadFile(file)
+		if err != nil {
+			m.Skipped = append(m.Skipped, fmt.Errorf("skipped fragment: %w", err))
+			continue
+		}
+		for _, ref := range frag.Workflows {
+			key := ref.Name + "@" + ref.Version
+			if prev, ok := seen[key]; ok && prev != file {
+				m.Skipped = append(m.Skipped, fmt.Errorf("workflow %q version %q is defined in both %s and %s; ignoring the entry in %[4]s", ref.Name, ref.Version, prev, file))
+				continue
+			}
+			seen[key] = file
+			m.Workflows = append(m.Workflows, ref)
+		}
+	}
+	return m, nil
+}
+
+// LoadFile reads a single manifest file, without drop-ins.
+func LoadFile(path string) (*Manifest, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read manifest %s: %w", path, err)
 	}
+	if err := signing.Check(path, content); err != nil {
+		return nil, err
+	}
 	var m Manifest
 	if err := json.Unmarshal(content, &m); err != nil {
 		return nil, fmt.Errorf("parse manifest %s: %w", path, err)
 	}
+	for i := range m.Workflows {
+		m.Workflows[i].Source = path
+	}
 	return &m, nil
 }
 
-// Find locates a workflow by name.
+// Find returns the default version of the named workflow: the highest version that is
+// not a pre-release, else the highest version. Unversioned entries rank lowest.
 func (m *Manifest) Find(name string) (*WorkflowRef, bool) {
+	var best *WorkflowRef
+	for i := range m.Workflows {
+		wf := &m.Workflows[i]
+		if wf.Name != name {
+			continue
+		}
+		if best == nil || betterDefault(wf.Version, best.Version) {
+			best = wf
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+	ref := *best
+	// Resolve paths to a canonical form (e.g., normalize separators).
+	ref.Path = filepath.Clean(ref.Path)
+	return &ref, true
+}
+
+// betterDefault reports whether version v should be the default over cur.
+func betterDefault(v, cur string) bool {
+	preV, preCur := strings.Contains(v, "-"), strings.Contains(cur, "-")
+	if preV != preCur {
+		return preCur
+	}
+	return CompareVersions(v, cur) > 0
+}
+
+// Select locates a workflow by "name" (its default version) or "name@constraint" (the
+// highest version satisfying the constraint, see Constraint).
+func (m *Manifest) Select(query string) (*WorkflowRef, error) {
+	name, expr, versioned := strings.Cut(query, "@")
+	if !versioned {
+		if ref, ok := m.Find(name); ok {
+			return ref, nil
+		}
+		return nil, m.notFound(name)
+	}
+	c, err := ParseConstraint(expr)
+	if err != nil {
+		return nil, err
+	}
+	var best *WorkflowRef
+	found := false
+	for i := range m.Workflows {
+		wf := &m.Workflows[i]
+		if wf.Name != name {
+			continue
+		}
+		found = true
+		if c.Match(wf.Version) && (best == nil || CompareVersions(wf.Version, best.Version) > 0) {
+			best = wf
+		}
+	}
+	if !found {
+		return nil, m.notFound(name)
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no version of workflow %q matches %s (have %s)", name, expr, strings.Join(m.versions(name), ", "))
+	}
+	ref := *best
+	ref.Path = filepath.Clean(ref.Path)
+	return &ref, nil
+}
+
+// notFound reports a missing workflow, pointing at left-out fragments that may define it.
+func (m *Manifest) notFound(name string) error {
+	err := fmt.Errorf("workflow %q not found in manifest", name)
+	if len(m.Skipped) > 0 {
+		err = fmt.Errorf("%w (%d manifest fragment problems, first: %v)", err, len(m.Skipped), m.Skipped[0])
+	}
+	return err
+}
+
+// Versions returns the entries of the named workflow, highest version first.
+func (m *Manifest) Versions(name string) []WorkflowRef {
+	var refs []WorkflowRef
 	for _, wf := range m.Workflows {
 		if wf.Name == name {
-			// Resolve paths to a canonical form (e.g., normalize separators).
-			wf.Path = filepath.Clean(wf.Path)
-			return &wf, true
+			refs = append(refs, wf)
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return CompareVersions(refs[i].Version, refs[j].Version) > 0 })
+	return refs
+}
+
+// IsDefault reports whether ref is the default version of its workflow.
+func (m *Manifest) IsDefault(ref WorkflowRef) bool {
+	def, ok := m.Find(ref.Name)
+	return ok && def.Version == ref.Version && def.Source == ref.Source
+}
+
+func (m *Manifest) versions(name string) []string {
+	var vs []string
+	for _, ref := range m.Versions(name) {
+		if ref.Version == "" {
+			vs = append(vs, "(unversioned)")
+			continue
 		}
+		vs = append(vs, ref.Version)
+	}
+	return vs
+}
+
+// Update applies fn to the manifest file at path (the manifest or a fragment, without
+// merging) under a cross-process lock and writes the result back atomically. A missing
+// file starts out empty.
+func Update(path string, fn func(*Manifest) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	lock, err := filelock.Open(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	m, err := LoadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		m, err = &Manifest{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := fn(m); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
 	}
-	return nil, false
+	return nil
 }
@@ -0,0 +1,48 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSkipsBadFragments(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"manifest.json":           `{"workflows":[{"name":"base","path":"base.yaml","version":"1.0.0"}]}`,
+		"manifest.d/10-good.json": `{"workflows":[{"name":"net","path":"net.yaml","version":"2.0.0"}]}`,
+		"manifest.d/20-bad.json":  `{"workflows":[`,
+		"manifest.d/30-dup.json":  `{"workflows":[{"name":"base","path":"other.yaml","version":"1.0.0"},{"name":"disk","path":"disk.yaml"}]}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := Load(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("Load() = %v; a bad fragment must not fail the manifest", err)
+	}
+	var names []string
+	for _, ref := range m.Workflows {
+		names = append(names, ref.Name+"@"+ref.Version+":"+ref.Path)
+	}
+	if got, want := strings.Join(names, " "), "base@1.0.0:base.yaml net@2.0.0:net.yaml disk@:disk.yaml"; got != want {
+		t.Errorf("workflows = %s, want %s", got, want)
+	}
+	if len(m.Skipped) != 2 {
+		t.Fatalf("Skipped = %v, want the unparsable fragment and the duplicate entry", m.Skipped)
+	}
+	if !strings.Contains(m.Skipped[0].Error(), "20-bad.json") || !strings.Contains(m.Skipped[1].Error(), "30-dup.json") {
+		t.Errorf("Skipped = %v", m.Skipped)
+	}
+	if _, err := m.Select("missing"); err == nil || !strings.Contains(err.Error(), "fragment") {
+		t.Errorf("Select(missing) = %v, want it to mention the skipped fragments", err)
+	}
+}
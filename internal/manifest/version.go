@@ -0,0 +1,211 @@
+package manifest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CompareVersions orders workflow versions like "1.4.2" or "v2.0.0-rc.1", returning -1,
+// 0 or +1. Dot-separated parts compare numerically when both are numbers and as text
+// otherwise; a missing part counts as 0, and a pre-release ("-rc.1") sorts before its
+// release. The empty version sorts before every other.
+func CompareVersions(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" || b == "" {
+		if a == "" {
+			return -1
+		}
+		return 1
+	}
+	relA, preA, _ := strings.Cut(strings.TrimPrefix(a, "v"), "-")
+	relB, preB, _ := strings.Cut(strings.TrimPrefix(b, "v"), "-")
+	if c := compareParts(relA, relB); c != 0 {
+		return c
+	}
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+	return compareParts(preA, preB)
+}
+
+func compareParts(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		x, y := "0", "0"
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		nx, errX := strconv.ParseUint(x, 10, 64)
+		ny, errY := strconv.ParseUint(y, 10, 64)
+		switch {
+		case errX == nil && errY == nil:
+			if nx != ny {
+				if nx < ny {
+					return -1
+				}
+				return 1
+			}
+		case x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Constraint selects workflow versions. It is a list of alternatives separated by "||",
+// each a space- or comma-separated list of comparisons that must all hold:
+//
+//	1.2.0  =1.2.0      exactly that version
+//	>1.2  >=1.2  <2  <=2.1
+//	^1.2.0             >=1.2.0 <2.0.0 (^0.3.1 means >=0.3.1 <0.4.0)
+//	~1.2.0             >=1.2.0 <1.3.0
+//	1.x  1.2.*  *      any version with those leading parts (also ^1.x, ~1.2.x)
+//
+// Pre-release versions ("2.0.0-rc.1") only match comparisons that name a pre-release.
+type Constraint struct {
+	raw  string
+	alts [][]comparison
+}
+
+type comparison struct {
+	op      string // = > >= < <=
+	version string
+}
+
+// ParseConstraint parses a version constraint.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{raw: s}
+	for _, alt := range strings.Split(s, "||") {
+		var all []comparison
+		for _, term := range strings.FieldsFunc(alt, func(r rune) bool { return r == ' ' || r == ',' }) {
+			cmps, err := parseTerm(term)
+			if err != nil {
+				return Constraint{}, fmt.Errorf("version constraint %q: %w", s, err)
+			}
+			all = append(all, cmps...)
+		}
+		if len(all) == 0 {
+			return Constraint{}, fmt.Errorf("version constraint %q: empty alternative", s)
+		}
+		c.alts = append(c.alts, all)
+	}
+	return c, nil
+}
+
+func parseTerm(term string) ([]comparison, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, prefix) {
+			op, term = prefix, strings.TrimPrefix(term, prefix)
+			break
+		}
+	}
+	v := strings.TrimPrefix(term, "v")
+	if v == "" {
+		return nil, fmt.Errorf("missing version after %q", op)
+	}
+	rel, pre, _ := strings.Cut(v, "-")
+	parts := strings.Split(rel, ".")
+	for i, part := range parts {
+		if part != "x" && part != "X" && part != "*" {
+			if _, err := strconv.ParseUint(part, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid version %q", term)
+			}
+			continue
+		}
+		// A wildcard ends the version: 1.2.x is >=1.2.0 <1.3.0, * matches everything.
+		// ^ and ~ add nothing to it (^0.x is 0.x); other comparisons cannot take one.
+		if (op != "" && op != "=" && op != "^" && op != "~") || pre != "" || i != len(parts)-1 {
+			return nil, fmt.Errorf("invalid wildcard in %q", term)
+		}
+		if i == 0 {
+			return []comparison{{op: ">=", version: "0"}}, nil
+		}
+		return rangeOf(parts[:i], len(parts[:i])-1), nil
+	}
+	switch op {
+	case "", "=":
+		return []comparison{{op: "=", version: v}}, nil
+	case "^":
+		// Bump the first non-zero part, or the last given one if all are zero.
+		i := 0
+		for i < len(parts)-1 && parts[i] == "0" {
+			i++
+		}
+		return append(rangeOf(parts, i)[1:], comparison{op: ">=", version: v}), nil
+	case "~":
+		i := len(parts) - 1
+		if i > 1 {
+			i = 1
+		}
+		return append(rangeOf(parts, i)[1:], comparison{op: ">=", version: v}), nil
+	}
+	return []comparison{{op: op, version: v}}, nil
+}
+
+// rangeOf returns >=parts and < parts with part i incremented and the rest dropped.
+func rangeOf(parts []string, i int) []comparison {
+	n, _ := strconv.ParseUint(parts[i], 10, 64)
+	upper := append(append([]string{}, parts[:i]...), strconv.FormatUint(n+1, 10))
+	return []comparison{
+		{op: ">=", version: strings.Join(parts, ".")},
+		{op: "<", version: strings.Join(upper, ".") + "-0"},
+	}
+}
+
+// Match reports whether version v satisfies the constraint.
+func (c Constraint) Match(v string) bool {
+	if v == "" {
+		return false
+	}
+	for _, alt := range c.alts {
+		if matchAll(alt, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAll(cmps []comparison, v string) bool {
+	prerelease := strings.Contains(v, "-")
+	allowPre := false
+	for _, cmp := range cmps {
+		c := CompareVersions(v, cmp.version)
+		ok := false
+		switch cmp.op {
+		case "=":
+			ok = c == 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		}
+		if !ok {
+			return false
+		}
+		if strings.Contains(cmp.version, "-") && !strings.HasSuffix(cmp.version, "-0") {
+			allowPre = true
+		}
+	}
+	return !prerelease || allowPre
+}
+
+func (c Constraint) String() string { return c.raw }
@@ -0,0 +1,172 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.0", "1.2.0", 0},
+		{"v1.2.0", "1.2.0", 0},
+		{"1.2", "1.2.0", 0},
+		{"1", "1.0.0", 0},
+		{"1.2.0", "1.10.0", -1},
+		{"1.9.2", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"0.9", "1.0.0", -1},
+		{"2.0.0-rc.1", "2.0.0", -1},
+		{"2.0.0-rc.1", "1.9.9", 1},
+		{"2.0.0-rc.1", "2.0.0-rc.2", -1},
+		{"2.0.0-rc.2", "2.0.0-rc.10", -1},
+		{"2.0.0-alpha", "2.0.0-beta", -1},
+		{"2.0.0-0", "2.0.0-alpha", -1},
+		{"", "0.0.1", -1},
+		{"", "0.1.0-rc.1", -1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{"1.2.0", []string{"1.2.0", "v1.2.0", "1.2"}, []string{"1.2.1", "1.1.9", ""}},
+		{"=1.2", []string{"1.2.0"}, []string{"1.2.1"}},
+		{">1.2", []string{"1.2.1", "2.0.0"}, []string{"1.2.0", "1.1.0"}},
+		{">=1.2 <2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0", "1.5.0-rc.1", "2.0.0-rc.1"}},
+		{">=1.2,<=2.1", []string{"1.2.0", "2.1.0"}, []string{"2.1.1"}},
+		{"^1.2.0", []string{"1.2.0", "1.9.0"}, []string{"1.1.9", "2.0.0", "2.0.0-rc.1"}},
+		{"^1", []string{"1.0.0", "1.9.9"}, []string{"0.9.0", "2.0.0"}},
+		{"^0.3.1", []string{"0.3.1", "0.3.9"}, []string{"0.3.0", "0.4.0"}},
+		{"^0.3", []string{"0.3.0", "0.3.9"}, []string{"0.4.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4", "0.1.0"}},
+		{"^0", []string{"0.0.1", "0.9.0"}, []string{"1.0.0"}},
+		{"^0.x", []string{"0.0.1", "0.9.0"}, []string{"1.0.0", "0.5.0-rc.1"}},
+		{"~1.2.0", []string{"1.2.0", "1.2.9"}, []string{"1.3.0", "1.1.9"}},
+		{"~1.2", []string{"1.2.0", "1.2.9"}, []string{"1.3.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"~1.2.x", []string{"1.2.5"}, []string{"1.3.0"}},
+		{"1.x", []string{"1.0.0", "1.9.9", "1"}, []string{"0.9.9", "2.0.0", "2.0.0-rc.1"}},
+		{"1.2.*", []string{"1.2.0", "1.2.7"}, []string{"1.3.0", "1.1.0"}},
+		{"1.X", []string{"1.4.0"}, []string{"2.0.0"}},
+		{"*", []string{"0.0.1", "10.0.0"}, []string{"1.0.0-rc.1", ""}},
+		{"1.x || >=3", []string{"1.5.0", "3.1.0"}, []string{"2.0.0"}},
+		{">=2.0.0-rc.1", []string{"2.0.0-rc.1", "2.0.0-rc.2", "2.0.0"}, []string{"2.0.0-rc.0", "1.9.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			c, err := ParseConstraint(tt.constraint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.String() != tt.constraint {
+				t.Errorf("String() = %q", c.String())
+			}
+			for _, v := range tt.match {
+				if !c.Match(v) {
+					t.Errorf("Match(%q) = false, want true", v)
+				}
+			}
+			for _, v := range tt.noMatch {
+				if c.Match(v) {
+					t.Errorf("Match(%q) = true, want false", v)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConstraintErrors(t *testing.T) {
+	for _, s := range []string{"", " ", "1.2 ||", ">=", "v", "abc", "1.a", "1.x.2", ">1.x", "<=2.*", "1.x-rc.1"} {
+		if _, err := ParseConstraint(s); err == nil {
+			t.Errorf("ParseConstraint(%q): want error", s)
+		}
+	}
+}
+
+func TestSelectConstraint(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"manifest.json": `{"workflows":[
+			{"name":"app","path":"app-1.0.yaml","version":"1.0.0"},
+			{"name":"app","path":"app-1.4.yaml","version":"1.4.0"},
+			{"name":"app","path":"app-1.5rc.yaml","version":"1.5.0-rc.1"},
+			{"name":"app","path":"app-2.0.yaml","version":"2.0.0"},
+			{"name":"app","path":"app-3.0rc.yaml","version":"3.0.0-rc.1"}]}`,
+		"manifest.d/10-more.json": `{"workflows":[{"name":"app","path":"app-0.9.yaml","version":"0.9.0"}]}`,
+		"manifest.d/20-dup.json":  `{"workflows":[{"name":"app","path":"dup.yaml","version":"1.4.0"}]}`,
+		"manifest.d/30-bad.json":  `not json`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := Load(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Skipped) != 2 || !strings.Contains(m.Skipped[0].Error(), "20-dup.json") || !strings.Contains(m.Skipped[1].Error(), "30-bad.json") {
+		t.Errorf("Skipped = %v, want the duplicate 1.4.0 and the unparsable fragment", m.Skipped)
+	}
+
+	tests := []struct {
+		query   string
+		version string // "" when Select must fail
+		path    string
+	}{
+		{"app", "2.0.0", "app-2.0.yaml"},
+		{"app@^1", "1.4.0", "app-1.4.yaml"},
+		{"app@~1.0", "1.0.0", "app-1.0.yaml"},
+		{"app@1.x || 0.x", "1.4.0", "app-1.4.yaml"},
+		{"app@<1", "0.9.0", "app-0.9.yaml"},
+		{"app@^0.x", "0.9.0", "app-0.9.yaml"},
+		{"app@*", "2.0.0", "app-2.0.yaml"},
+		{"app@>=1.5.0-rc.0", "3.0.0-rc.1", "app-3.0rc.yaml"},
+		{"app@>=1.5.0-rc.0 <2", "1.5.0-rc.1", "app-1.5rc.yaml"},
+		{"app@>=4", "", ""},
+		{"app@>1.x", "", ""},
+		{"other@*", "", ""},
+	}
+	for _, tt := range tests {
+		ref, err := m.Select(tt.query)
+		if tt.version == "" {
+			if err == nil {
+				t.Errorf("Select(%q) = %s, want error", tt.query, ref.Version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Select(%q): %v", tt.query, err)
+			continue
+		}
+		if ref.Version != tt.version || ref.Path != tt.path {
+			t.Errorf("Select(%q) = %s (%s), want %s (%s)", tt.query, ref.Version, ref.Path, tt.version, tt.path)
+		}
+	}
+
+	_, err = m.Select("app@>=4")
+	if err == nil || !strings.Contains(err.Error(), "3.0.0-rc.1, 2.0.0, 1.5.0-rc.1, 1.4.0, 1.0.0, 0.9.0") {
+		t.Errorf("Select(app@>=4) = %v, want it to list the installed versions", err)
+	}
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/autostep/autostep/internal/events"
+	"github.com/autostep/autostep/internal/state"
+)
+
+// Collector derives the service's metrics from run events and state store timings.
+type Collector struct {
+	store *state.Store
+	reg   *Registry
+
+	runsStarted   *CounterVec
+	runsFinished  *CounterVec
+	stepDuration  *HistogramVec
+	reboots       *CounterVec
+	resumeLatency *HistogramVec
+	persist       *HistogramVec
+
+	mu    sync.Mutex
+	steps map[string]map[int]time.Time // pending step start times by run
+}
+
+// NewCollector registers the metrics in a new registry. Attach it with
+// bus.Subscribe(c.Observe) and store.OnPersist(c.ObservePersist).
+func NewCollector(store *state.Store) *Collector {
+	reg := NewRegistry()
+	return &Collector{
+		store: store,
+		reg:   reg,
+		runsStarted: reg.NewCounterVec("autostep_runs_started_total",
+			"Runs started, by workflow.", "workflow"),
+		runsFinished: reg.NewCounterVec("autostep_runs_total",
+			"Runs that stopped, by workflow and final status (completed, failed, cancelled or interrupted).", "workflow", "status"),
+		stepDuration: reg.NewHistogramVec("autostep_step_duration_seconds",
+			"Step execution time, by action and outcome (completed or failed).", DurationBuckets, "action", "status"),
+		reboots: reg.NewCounterVec("autostep_reboots_total",
+			"Reboots requested by steps, by workflow and boot mode.", "workflow", "boot_mode"),
+		resumeLatency: reg.NewHistogramVec("autostep_resume_latency_seconds",
+			"Time from a step requesting a reboot to the run resuming after it.", DurationBuckets, "workflow"),
+		persist: reg.NewHistogramVec("autostep_state_persist_duration_seconds",
+			"Time taken to write the state file.", LatencyBuckets),
+		steps: map[string]map[int]time.Time{},
+	}
+}
+
+// Registry returns the registry the collector's metrics are in.
+func (c *Collector) Registry() *Registry { return c.reg }
+
+// Observe updates the metrics for a run event. It has the signature of a bus subscriber.
+func (c *Collector) Observe(e events.Event) {
+	switch e.Type {
+	case events.RunStarted:
+		c.runsStarted.Inc(e.Workflow)
+	case events.StepPending:
+		if e.StepIndex != nil {
+			c.mu.Lock()
+			if c.steps[e.RunID] == nil {
+				c.steps[e.RunID] = map[int]time.Time{}
+			}
+			c.steps[e.RunID][*e.StepIndex] = e.Time
+			c.mu.Unlock()
+		}
+	case events.StepCompleted:
+		c.observeStep(e, "completed")
+	case events.StepFailed:
+		c.observeStep(e, "failed")
+	case events.RebootRequested:
+		c.reboots.Inc(e.Workflow, e.BootMode)
+	case events.RunResumed:
+		if e.BootMode != "" {
+			c.observeResume(e)
+		}
+	case events.RunCompleted, events.RunFailed, events.RunCancelled, events.RunInterrupted:
+		status := string(e.Type[len("run."):])
+		c.runsFinished.Inc(e.Workflow, status)
+		c.mu.Lock()
+		delete(c.steps, e.RunID)
+		c.mu.Unlock()
+	}
+}
+
+// ObservePersist records one write of the state file.
+func (c *Collector) ObservePersist(d time.Duration) {
+	c.persist.Observe(d.Seconds())
+}
+
+func (c *Collector) observeStep(e events.Event, status string) {
+	if e.StepIndex == nil {
+		return
+	}
+	c.mu.Lock()
+	start, ok := c.steps[e.RunID][*e.StepIndex]
+	delete(c.steps[e.RunID], *e.StepIndex)
+	c.mu.Unlock()
+	if ok {
+		c.stepDuration.Observe(e.Time.Sub(start).Seconds(), e.Action, status)
+	}
+}
+
+// observeResume measures the reboot the run resumed from, as recorded in the store.
+func (c *Collector) observeResume(e events.Event) {
+	rec, ok := c.store.Run(e.RunID)
+	if !ok || len(rec.Reboots) == 0 {
+		return
+	}
+	last := rec.Reboots[len(rec.Reboots)-1]
+	if last.ResumedAt != nil {
+		c.resumeLatency.Observe(last.ResumedAt.Sub(last.RequestedAt).Seconds(), e.Workflow)
+	}
+}
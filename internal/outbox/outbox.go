@@ -0,0 +1,143 @@
+// Package outbox queues outgoing messages on disk so they survive network outages and
+// reboots. Each message is one JSON file; a message is removed only once it has been
+// delivered or given up on.
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minBackoff and maxBackoff bound the delay between delivery attempts.
+	minBackoff = 10 * time.Second
+	maxBackoff = 30 * time.Minute
+)
+
+// Message is a queued delivery.
+type Message struct {
+	ID            string          `json:"id"`
+	Target        string          `json:"target,omitempty"` // sink the message is addressed to
+	CreatedAt     time.Time       `json:"created_at"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastError     string          `json:"last_error,omitempty"`
+	Body          json.RawMessage `json:"body"`
+}
+
+// Outbox is a directory of pending messages.
+type Outbox struct {
+	dir string
+
+	mu  sync.Mutex
+	seq int
+}
+
+// Open creates the outbox directory if needed.
+func Open(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create outbox %s: %w", dir, err)
+	}
+	return &Outbox{dir: dir}, nil
+}
+
+// Add queues body for target. It is due immediately.
+func (o *Outbox) Add(target string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.seq++
+	now := time.Now().UTC()
+	id := fmt.Sprintf("%020d-%04d", now.UnixNano(), o.seq%10000)
+	o.mu.Unlock()
+	return o.write(&Message{ID: id, Target: target, CreatedAt: now, NextAttemptAt: now, Body: data})
+}
+
+// Due returns messages whose next attempt is at or before now, oldest first.
+// Unreadable files are skipped so one corrupt message does not block the rest.
+func (o *Outbox) Due(now time.Time) ([]*Message, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Message
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(o.dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal(content, &m); err != nil || m.ID == "" {
+			continue
+		}
+		if !m.NextAttemptAt.After(now) {
+			out = append(out, &m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// Len returns the number of queued messages.
+func (o *Outbox) Len() int {
+	matches, _ := filepath.Glob(filepath.Join(o.dir, "*.json"))
+	return len(matches)
+}
+
+// Remove deletes a message once it has been delivered or given up on.
+func (o *Outbox) Remove(m *Message) error {
+	err := os.Remove(o.file(m.ID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Retry records a failed attempt and schedules the next one with exponential backoff.
+func (o *Outbox) Retry(m *Message, cause error) error {
+	m.Attempts++
+	m.LastError = cause.Error()
+	m.NextAttemptAt = time.Now().UTC().Add(Backoff(m.Attempts))
+	return o.write(m)
+}
+
+// Backoff returns the delay before attempt n+1 after n failed attempts.
+func Backoff(attempts int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+func (o *Outbox) file(id string) string {
+	return filepath.Join(o.dir, id+".json")
+}
+
+func (o *Outbox) write(m *Message) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := o.file(m.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write outbox message: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
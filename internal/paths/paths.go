@@ -14,6 +14,22 @@ type Paths struct {
 	ArtifactsDir string
 	StatePath    string
 	LogsDir      string
+	InboxDir     string
+	TriggersPath string
+	ControlAddr  string // unix socket path, or named pipe on Windows
+	ConfigPath   string
+	APITokenPath string
+	JobsPath     string // coordinator job ledger
+	OutboxDir    string // queued outgoing reports
+	ReportsDir   string // run reports written when runs finish
+	OutputDir    string // captured output of run steps
+	RunsDir      string // scratch directories of runs, for run:// paths
+	AuditPath    string // hash-chained audit log
+	EventsPath   string // journal of run events
+	TrustDir     string // public keys trusted to sign workflows and the manifest
+	PolicyPath   string // guardrails enforced before each step
+	SecretsPath  string // encrypted secrets referenced as ${secret:name}
+	KeyPath      string // data encryption key for secrets and files encrypted at rest
 }
 
 // DefaultRoot returns the base data directory. AUTOSTEP_ROOT overrides the default.
@@ -36,12 +52,35 @@ func FromRoot(root string) Paths {
 		ArtifactsDir: filepath.Join(root, "artifacts"),
 		StatePath:    filepath.Join(root, "state.json"),
 		LogsDir:      filepath.Join(root, "logs"),
+		InboxDir:     filepath.Join(root, "inbox"),
+		TriggersPath: filepath.Join(root, "triggers.json"),
+		ControlAddr:  controlAddr(root),
+		ConfigPath:   filepath.Join(root, "config.json"),
+		APITokenPath: filepath.Join(root, "api.token"),
+		JobsPath:     filepath.Join(root, "jobs.json"),
+		OutboxDir:    filepath.Join(root, "outbox"),
+		ReportsDir:   filepath.Join(root, "logs", "reports"),
+		OutputDir:    filepath.Join(root, "logs", "output"),
+		RunsDir:      filepath.Join(root, "runs"),
+		AuditPath:    filepath.Join(root, "audit.log"),
+		EventsPath:   filepath.Join(root, "events.jsonl"),
+		TrustDir:     filepath.Join(root, "trust"),
+		PolicyPath:   filepath.Join(root, "policy.json"),
+		SecretsPath:  filepath.Join(root, "secrets.json"),
+		KeyPath:      filepath.Join(root, "autostep.key"),
 	}
 }
 
+func controlAddr(root string) string {
+	if runtime.GOOS == "windows" {
+		return `\\.\pipe\autostep`
+	}
+	return filepath.Join(root, "autostep.sock")
+}
+
 // Ensure creates required directories if missing.
 func Ensure(p Paths) error {
-	dirs := []string{p.Root, p.WorkflowsDir, p.ArtifactsDir, p.LogsDir}
+	dirs := []string{p.Root, p.WorkflowsDir, p.ArtifactsDir, p.LogsDir, p.InboxDir}
 	for _, d := range dirs {
 		if err := os.MkdirAll(d, 0o755); err != nil {
 			return err
@@ -0,0 +1,215 @@
+package paths
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/autostep/autostep/internal/cas"
+	"github.com/autostep/autostep/internal/fetch"
+)
+
+// Schemes of step paths that name locations under the data root.
+const (
+	CacheScheme = "cache://" // the artifacts cache
+	RunScheme   = "run://"   // the run's scratch directory
+)
+
+// ErrOutsideRoot indicates a cache:// or run:// path that leads out of its directory.
+var ErrOutsideRoot = errors.New("path escapes its root")
+
+// RunDir returns the scratch directory of a run. It lives under the data root, so it
+// survives reboots, and is removed when the run finishes.
+func (p Paths) RunDir(runID string) string {
+	return filepath.Join(p.RunsDir, runID)
+}
+
+// Resolver turns the paths in workflow steps into filesystem paths.
+type Resolver struct {
+	Cache  string                           // directory of cache:// paths
+	Run    string                           // directory of run:// paths; empty outside a run
+	Getenv func(name string) (string, bool) // looks up %NAME% references; nil uses os.LookupEnv
+
+	// Aliases maps cache:// names to the hashes of stored blobs (see package cas).
+	Aliases map[string]string
+}
+
+// Resolver returns the resolver for steps of the given run; an empty runID leaves
+// run:// unavailable.
+func (p Paths) Resolver(runID string) Resolver {
+	r := Resolver{Cache: p.ArtifactsDir}
+	if runID != "" {
+		r.Run = p.RunDir(runID)
+	}
+	return r
+}
+
+// envPattern matches %NAME% references; %% stands for a literal percent sign.
+var envPattern = regexp.MustCompile(`%%|%([A-Za-z_][A-Za-z0-9_()]*)%`)
+
+// Path resolves a path: %NAME% references are replaced by environment variables, and
+// a cache:// or run:// prefix by its directory. The rest of a prefixed path is relative
+// to that directory and may not lead out of it. A cache:// path naming a blob
+// ("sha256:<hex>") or an alias resolves to the stored blob. URLs that package fetch
+// downloads are returned as they are, since % is their escape character.
+func (r Resolver) Path(s string) (string, error) {
+	if fetch.IsURL(s) {
+		return s, nil
+	}
+	scheme, root, rest, err := r.split(s)
+	if err != nil {
+		return "", err
+	}
+	rest, err = r.expandEnv(rest, func(v string) string { return v })
+	if err != nil || scheme == "" {
+		return rest, err
+	}
+	if scheme == CacheScheme {
+		name := path.Clean(strings.ReplaceAll(rest, `\`, "/"))
+		if hash, ok := cas.ParseName(name); ok {
+			return cas.Path(root, hash), nil
+		}
+		if hash, ok := r.Aliases[name]; ok && cas.ValidHash(hash) {
+			return cas.Path(root, hash), nil
+		}
+	}
+	return within(scheme, root, rest, rest)
+}
+
+// Pattern resolves a path_regex pattern like Path. Environment values and the scheme's
+// directory are escaped so they match literally. After a scheme the pattern is anchored
+// below the scheme's directory, as ^<dir><sep>(?:<rest>)$, and may not contain ..
+// segments, so neither alternation nor the search root can lead out of the directory.
+func (r Resolver) Pattern(s string) (string, error) {
+	scheme, root, rest, err := r.split(s)
+	if err != nil {
+		return "", err
+	}
+	rest, err = r.expandEnv(rest, quotePath)
+	if err != nil || scheme == "" {
+		return rest, err
+	}
+	unescaped := strings.ReplaceAll(rest, `\.`, ".")
+	for _, seg := range strings.FieldsFunc(unescaped, func(c rune) bool { return c == '/' || c == '\\' }) {
+		if seg == ".." {
+			return "", fmt.Errorf("%s%s: %w", scheme, rest, ErrOutsideRoot)
+		}
+	}
+	literal, _ := literalPrefix(rest)
+	if _, err := within(scheme, root, literal, rest); err != nil {
+		return "", err
+	}
+	return "^" + quotePath(root) + quotePath(string(filepath.Separator)) + "(?:" + rest + ")$", nil
+}
+
+// RegexMeta holds the characters that end the literal prefix of a path_regex pattern.
+const RegexMeta = "*+?[](){}|^$"
+
+// RegexRoot returns the directory a path_regex pattern is searched from: the directory
+// of its longest literal prefix, after a leading ^ and with escapes undone.
+func RegexRoot(pattern string) string {
+	literal, whole := literalPrefix(strings.TrimPrefix(pattern, "^"))
+	if literal == "" && !whole {
+		return string(filepath.Separator)
+	}
+	return filepath.Dir(literal)
+}
+
+// literalPrefix returns the part of pattern before its first metacharacter, with escaped
+// punctuation (\. or \\) taken literally; an escape like \d ends it. whole reports that
+// the pattern has no metacharacter. An unescaped dot counts as literal: it matches itself.
+func literalPrefix(pattern string) (literal string, whole bool) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(pattern) || isAlnum(pattern[i+1]) {
+				return b.String(), false
+			}
+			i++
+			b.WriteByte(pattern[i])
+		case strings.IndexByte(RegexMeta, c) >= 0:
+			return b.String(), false
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), true
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// split separates a scheme prefix and its directory from the rest of s.
+func (r Resolver) split(s string) (scheme, root, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, CacheScheme):
+		scheme, root = CacheScheme, r.Cache
+	case strings.HasPrefix(s, RunScheme):
+		scheme, root = RunScheme, r.Run
+		if root == "" {
+			return "", "", "", fmt.Errorf("%s: run:// paths are only available during a run", s)
+		}
+	default:
+		return "", "", s, nil
+	}
+	return scheme, root, strings.TrimPrefix(s, scheme), nil
+}
+
+// within joins rest to root like Join, naming the path as written in errors.
+func within(scheme, root, rest, orig string) (string, error) {
+	joined, err := Join(root, rest)
+	if err != nil {
+		return "", fmt.Errorf("%s%s: %w", scheme, orig, ErrOutsideRoot)
+	}
+	return joined, nil
+}
+
+// Join joins the relative path rel to root and returns ErrOutsideRoot if the result is
+// not root or below it. Absolute and volume-qualified paths are rejected, not joined.
+func Join(root, rel string) (string, error) {
+	rel = filepath.FromSlash(rel)
+	if filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" || strings.HasPrefix(rel, string(filepath.Separator)) {
+		return "", ErrOutsideRoot
+	}
+	root = filepath.Clean(root)
+	joined := filepath.Join(root, rel)
+	if up, err := filepath.Rel(root, joined); err != nil || up == ".." || strings.HasPrefix(up, ".."+string(filepath.Separator)) {
+		return "", ErrOutsideRoot
+	}
+	return joined, nil
+}
+
+// expandEnv replaces %NAME% references, passing values through quote. An unset variable
+// is an error, so that a typo cannot turn a path into a different one.
+func (r Resolver) expandEnv(s string, quote func(string) string) (string, error) {
+	getenv := r.Getenv
+	if getenv == nil {
+		getenv = os.LookupEnv
+	}
+	var firstErr error
+	out := envPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "%%" {
+			return quote("%")
+		}
+		name := ref[1 : len(ref)-1]
+		v, ok := getenv(name)
+		if !ok && firstErr == nil {
+			firstErr = fmt.Errorf("%s: environment variable %s is not set", s, name)
+		}
+		return quote(v)
+	})
+	return out, firstErr
+}
+
+// quotePath escapes a literal path for use in a path_regex pattern; RegexRoot undoes
+// the escapes to find the search root.
+func quotePath(p string) string {
+	return regexp.QuoteMeta(p)
+}
@@ -0,0 +1,172 @@
+package paths
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	testRoot = filepath.FromSlash("/data/autostep")
+	testHash = strings.Repeat("ab", 32)
+)
+
+func testResolver(run string) Resolver {
+	env := map[string]string{"DIR": filepath.FromSlash("/opt/app"), "SUB": "..", "ODD": "x+y"}
+	return Resolver{
+		Cache:   filepath.Join(testRoot, "artifacts"),
+		Run:     run,
+		Getenv:  func(name string) (string, bool) { v, ok := env[name]; return v, ok },
+		Aliases: map[string]string{"driver.sys": testHash},
+	}
+}
+
+// resolveCase is a resolver input with the expected result or error. A want of
+// ErrOutsideRoot.Error() is matched with errors.Is.
+type resolveCase struct {
+	name, in, want, wantErr string
+}
+
+func checkResolve(t *testing.T, tests []resolveCase, resolve func(string) (string, error)) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolve(tt.in)
+			switch {
+			case tt.wantErr == ErrOutsideRoot.Error():
+				if !errors.Is(err, ErrOutsideRoot) {
+					t.Fatalf("(%q) = %q, %v; want ErrOutsideRoot", tt.in, got, err)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("(%q) = %q, %v; want error containing %q", tt.in, got, err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("(%q): %v", tt.in, err)
+			case got != tt.want:
+				t.Fatalf("(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolverPath(t *testing.T) {
+	cache := filepath.Join(testRoot, "artifacts")
+	run := filepath.Join(testRoot, "runs", "r1")
+	outside := ErrOutsideRoot.Error()
+	checkResolve(t, []resolveCase{
+		{name: "plain", in: "/etc/hosts", want: "/etc/hosts"},
+		{name: "cache", in: "cache://drivers/a.sys", want: filepath.Join(cache, "drivers", "a.sys")},
+		{name: "cache alias with backslashes", in: `cache://.\driver.sys`, want: filepath.Join(cache, "sha256", testHash)},
+		{name: "cache dot-dot inside", in: "cache://a/../b.sys", want: filepath.Join(cache, "b.sys")},
+		{name: "cache root", in: "cache://", want: cache},
+		{name: "cache dot-dot out", in: "cache://../state.json", wantErr: outside},
+		{name: "cache nested dot-dot out", in: "cache://a/../../state.json", wantErr: outside},
+		{name: "cache absolute", in: "cache:///etc/passwd", wantErr: outside},
+		{name: "cache blob", in: "cache://sha256:" + testHash, want: filepath.Join(cache, "sha256", testHash)},
+		{name: "cache alias", in: "cache://driver.sys", want: filepath.Join(cache, "sha256", testHash)},
+		{name: "cache alias cleaned", in: "cache://./driver.sys", want: filepath.Join(cache, "sha256", testHash)},
+		{name: "run", in: "run://out/log.txt", want: filepath.Join(run, "out", "log.txt")},
+		{name: "run dot-dot out", in: "run://../r2/secret", wantErr: outside},
+		{name: "env", in: "%DIR%/bin", want: filepath.FromSlash("/opt/app") + "/bin"},
+		{name: "env in cache path", in: "cache://%DIR%", wantErr: outside},
+		{name: "env dot-dot out", in: "cache://%SUB%/state.json", wantErr: outside},
+		{name: "env unset", in: "%NOPE%/bin", wantErr: "NOPE is not set"},
+		{name: "escaped percent", in: "/tmp/100%%", want: "/tmp/100%"},
+		{name: "url", in: "https://example.com/a%20b.sys", want: "https://example.com/a%20b.sys"},
+	}, testResolver(run).Path)
+
+	t.Run("run outside a run", func(t *testing.T) {
+		if _, err := testResolver("").Path("run://out.txt"); err == nil || !strings.Contains(err.Error(), "only available during a run") {
+			t.Fatalf("Path() = %v", err)
+		}
+	})
+}
+
+func TestResolverPattern(t *testing.T) {
+	cache := filepath.Join(testRoot, "artifacts")
+	run := filepath.Join(testRoot, "runs", "r1")
+	sep := quotePath(string(filepath.Separator))
+	anchored := func(root, rest string) string { return "^" + quotePath(root) + sep + "(?:" + rest + ")$" }
+	outside := ErrOutsideRoot.Error()
+	checkResolve(t, []resolveCase{
+		{name: "plain", in: `/var/log/.*\.log`, want: `/var/log/.*\.log`},
+		{name: "cache", in: `cache://logs/.*\.log`, want: anchored(cache, `logs/.*\.log`)},
+		{name: "run", in: `run://[0-9]+\.txt`, want: anchored(run, `[0-9]+\.txt`)},
+		{name: "alternation stays anchored", in: `cache://a\.log|b\.log`, want: anchored(cache, `a\.log|b\.log`)},
+		{name: "dot-dot in literal prefix", in: `cache://../.*`, wantErr: outside},
+		{name: "dot-dot in literal dir", in: `cache://a/../../x.*`, wantErr: outside},
+		{name: "dot-dot after group", in: `cache://(z)/../../..|state.json`, wantErr: outside},
+		{name: "dot-dot after class", in: `cache://[a]/../../../../etc/passwd`, wantErr: outside},
+		{name: "escaped dot-dot", in: `run://x/\.\./\.\./state\.json`, wantErr: outside},
+		{name: "dot-dot from env", in: `cache://%SUB%/.*`, wantErr: outside},
+		{name: "absolute after scheme", in: `cache:///etc/.*`, wantErr: outside},
+		{name: "dot-dot prefixed name", in: `cache://..a/.*`, want: anchored(cache, `..a/.*`)},
+		{name: "env quoted", in: `/srv/%ODD%/.*`, want: `/srv/x\+y/.*`},
+		{name: "env unset", in: `%NOPE%/.*`, wantErr: "NOPE is not set"},
+	}, testResolver(run).Pattern)
+}
+
+// TestResolverPatternMatches checks what a resolved cache:// pattern is searched from
+// and matches: only paths below the cache, even with alternation and a dotted root.
+func TestResolverPatternMatches(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "auto.step")
+	cache := filepath.Join(root, "artifacts")
+	r := Resolver{Cache: cache}
+	pattern, err := r.Pattern(`cache://logs/.*\.log|state\.json`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := RegexRoot(pattern); got != cache {
+		t.Fatalf("RegexRoot(%q) = %q, want the cache %q", pattern, got, cache)
+	}
+	re := regexp.MustCompile(pattern)
+	for path, want := range map[string]bool{
+		filepath.Join(cache, "logs", "a.log"):                                            true,
+		filepath.Join(cache, "state.json"):                                               true,
+		filepath.Join(root, "state.json"):                                                false,
+		filepath.Join(root+"x", "artifacts", "state.json"):                               false,
+		strings.Replace(filepath.Join(cache, "state.json"), "auto.step", "autoXstep", 1): false,
+	} {
+		if got := re.MatchString(path); got != want {
+			t.Errorf("%q matches %s: %v, want %v", pattern, path, got, want)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	root := filepath.FromSlash("/data/root")
+	outside := ErrOutsideRoot.Error()
+	checkResolve(t, []resolveCase{
+		{name: "nested", in: "a/b", want: filepath.Join(root, "a", "b")},
+		{name: "empty", in: "", want: root},
+		{name: "dot-dot prefixed name", in: "..a/b", want: filepath.Join(root, "..a", "b")},
+		{name: "dot-dot back inside", in: "a/../b", want: filepath.Join(root, "b")},
+		{name: "parent", in: "..", wantErr: outside},
+		{name: "sibling", in: "../root2/x", wantErr: outside},
+		{name: "deep escape", in: "a/b/../../../x", wantErr: outside},
+		{name: "absolute", in: "/etc/passwd", wantErr: outside},
+	}, func(rel string) (string, error) { return Join(root, rel) })
+}
+
+func TestRegexRoot(t *testing.T) {
+	tests := []struct{ pattern, want string }{
+		{`^/data/auto\.step/artifacts/(?:.*|x)$`, "/data/auto.step/artifacts"},
+		{`/var/log/app-[0-9]+\.log`, "/var/log"},
+		{`/var/log\d/x`, "/var"},
+		{`/var/log/.*`, "/var/log"},
+		{`/var/log/app.log`, "/var/log"},
+		{`/.*\.tmp`, "/"},
+		{`.*\.tmp`, "."},
+	}
+	for _, tt := range tests {
+		// Separators in patterns are written escaped, which matters on Windows.
+		pattern := strings.ReplaceAll(tt.pattern, "/", quotePath(string(filepath.Separator)))
+		want := filepath.FromSlash(tt.want)
+		if got := RegexRoot(pattern); got != want {
+			t.Errorf("RegexRoot(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
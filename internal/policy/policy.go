@@ -0,0 +1,289 @@
+// Package policy enforces the guardrails in policy.json under the data root: per-action
+// rules on what any workflow step may touch, checked before each step is dispatched and
+// by `autostep validate`.
+//
+//	{
+//	  "actions": {
+//	    "file_delete":  { "allow": ["C:\\Temp"] },
+//	    "registry_set": { "forbid": ["HKLM\\SAM", "HKLM\\SECURITY"] },
+//	    "run":          { "allow": ["powershell.exe", "C:\\Tools\\fix.exe"] },
+//	    "driver_load":  { "sha256": ["9f86d0..."], "reason": "drivers need secops approval" },
+//	    "safeboot":     { "deny": true }
+//	  }
+//	}
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/workflow"
+)
+
+// ErrDenied is wrapped by every Violation.
+var ErrDenied = errors.New("denied by policy")
+
+// ErrHashUnavailable may be returned by the hash function passed to Check to skip a
+// sha256 rule, e.g. when validating a workflow whose files are not on this machine.
+var ErrHashUnavailable = errors.New("hash unavailable")
+
+// Policy holds the rules by action name.
+type Policy struct {
+	Actions map[string]Rule `json:"actions"`
+}
+
+// Rule restricts one action. Allow and Forbid are matched against the step's targets:
+// path prefixes for file and registry actions, executables for run, and names for
+// service and driver actions. A bare executable name in Allow admits the command only
+// as found on PATH; in Forbid it matches a command of that name wherever it lives.
+type Rule struct {
+	Deny   bool     `json:"deny,omitempty"`   // forbid the action entirely
+	Allow  []string `json:"allow,omitempty"`  // if set, every target must match one
+	Forbid []string `json:"forbid,omitempty"` // no target may match any
+	SHA256 []string `json:"sha256,omitempty"` // if set, the step's file must have one of these hashes
+	Reason string   `json:"reason,omitempty"` // appended to denials
+}
+
+// Violation is a step denied by the policy.
+type Violation struct {
+	StepID string
+	Action string
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("step %s: %s %s: %s", v.StepID, v.Action, ErrDenied, v.Reason)
+}
+
+func (v *Violation) Unwrap() error { return ErrDenied }
+
+// target kinds decide how rule entries are matched.
+type kind int
+
+const (
+	kindFile kind = iota
+	kindRegistry
+	kindCommand
+	kindName
+)
+
+// hashed names the actions whose sha256 rule applies and the step field it hashes.
+var hashed = map[string]func(workflow.Step) string{
+	"driver_load": func(s workflow.Step) string { return s.DriverPath },
+	"file_copy":   func(s workflow.Step) string { return s.SrcPath },
+	"run":         func(s workflow.Step) string { return lookPath(s.Command) },
+}
+
+// Load reads the policy at path. A missing file yields nil, which allows everything.
+func Load(path string) (*Policy, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read policy %s: %w", path, err)
+	}
+	// Unknown fields are errors: a misspelled guardrail must not silently allow.
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+	actions := make(map[string]Rule, len(p.Actions))
+	for name, rule := range p.Actions {
+		name = strings.ToLower(name)
+		if len(rule.SHA256) > 0 && hashed[name] == nil {
+			return nil, fmt.Errorf("policy %s: sha256 rules apply to %s only, not %s", path, hashedActions(), name)
+		}
+		actions[name] = rule
+	}
+	p.Actions = actions
+	return &p, nil
+}
+
+func hashedActions() string {
+	names := make([]string, 0, len(hashed))
+	for name := range hashed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Check returns a *Violation if the policy denies step, which must have its parameters
+// expanded. hash returns the hex SHA-256 of a file for sha256 rules. A nil Policy
+// allows everything.
+func (p *Policy) Check(step workflow.Step, hash func(path string) (string, error)) error {
+	if p == nil {
+		return nil
+	}
+	action := strings.ToLower(step.Action)
+	rule, ok := p.Actions[action]
+	if !ok {
+		return nil
+	}
+	deny := func(format string, v ...any) error {
+		reason := fmt.Sprintf(format, v...)
+		if rule.Reason != "" {
+			reason += " (" + rule.Reason + ")"
+		}
+		return &Violation{StepID: step.ID, Action: action, Reason: reason}
+	}
+	if rule.Deny {
+		return deny("action is not allowed")
+	}
+	k, values := targets(action, step)
+	for _, v := range values {
+		if len(rule.Allow) > 0 && !matchAny(k, v, rule.Allow, false) {
+			return deny("%s is not in the allowed list", v)
+		}
+		if matchAny(k, v, rule.Forbid, true) {
+			return deny("%s is forbidden", v)
+		}
+	}
+	if len(rule.SHA256) > 0 {
+		file := hashed[action](step)
+		sum, err := hash(file)
+		switch {
+		case errors.Is(err, ErrHashUnavailable):
+		case err != nil:
+			return deny("cannot hash %s: %v", file, err)
+		case !containsFold(rule.SHA256, sum):
+			return deny("%s has unapproved sha256 %s", file, sum)
+		}
+	}
+	return nil
+}
+
+// targets returns what allow and forbid entries are matched against for a step.
+func targets(action string, s workflow.Step) (kind, []string) {
+	switch action {
+	case "file_copy":
+		return kindFile, []string{s.DstPath}
+	case "file_rename":
+		return kindFile, []string{s.SrcPath, filepath.Join(filepath.Dir(s.SrcPath), s.NewName)}
+	case "file_delete", "file_exists":
+		return kindFile, []string{paths.RegexRoot(s.PathRegex)}
+	case "registry_set", "registry_delete", "registry_save", "registry_restore",
+		"registry_load", "registry_unload", "registry_append", "registry_equals":
+		return kindRegistry, []string{s.Path}
+	case "run":
+		return kindCommand, []string{s.Command}
+	case "service_start", "service_stop", "service_running":
+		return kindName, []string{s.Service}
+	case "driver_load", "driver_unload", "driver_loaded":
+		return kindName, []string{s.DriverName}
+	}
+	return kindName, nil
+}
+
+// matchAny reports whether value matches one of patterns, which are forbid entries if
+// forbid is set and allow entries otherwise.
+func matchAny(k kind, value string, patterns []string, forbid bool) bool {
+	for _, pat := range patterns {
+		if match(k, value, pat, forbid) {
+			return true
+		}
+	}
+	return false
+}
+
+func match(k kind, value, pattern string, forbid bool) bool {
+	switch k {
+	case kindFile:
+		abs, err := filepath.Abs(value)
+		if err != nil {
+			return false
+		}
+		return hasPathPrefix(abs, filepath.Clean(pattern), string(filepath.Separator), runtime.GOOS == "windows")
+	case kindRegistry:
+		return hasPathPrefix(canonicalKey(value), canonicalKey(pattern), `\`, true)
+	case kindCommand:
+		fold := runtime.GOOS == "windows"
+		if strings.ContainsAny(pattern, `/\`) {
+			return equal(filepath.Clean(lookPath(value)), filepath.Clean(pattern), fold)
+		}
+		if forbid {
+			return equal(filepath.Base(value), pattern, fold)
+		}
+		// An allowed bare name does not admit any file of that name, only the command
+		// PATH resolves it to.
+		if !strings.ContainsAny(value, `/\`) {
+			return equal(value, pattern, fold)
+		}
+		resolved := lookPath(pattern)
+		return resolved != pattern && equal(filepath.Clean(lookPath(value)), filepath.Clean(resolved), fold)
+	}
+	return strings.EqualFold(value, pattern)
+}
+
+// hasPathPrefix reports whether path is prefix or lies below it.
+func hasPathPrefix(path, prefix, sep string, fold bool) bool {
+	if fold {
+		path, prefix = strings.ToLower(path), strings.ToLower(prefix)
+	}
+	if path == prefix {
+		return true
+	}
+	if !strings.HasSuffix(prefix, sep) {
+		prefix += sep
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+// canonicalKey abbreviates the hive of a registry path the way the registry actions
+// accept it, so HKEY_LOCAL_MACHINE\X and HKLM\X match.
+func canonicalKey(path string) string {
+	hive, rest, _ := strings.Cut(strings.Trim(path, `\`), `\`)
+	switch strings.ToUpper(hive) {
+	case "HKEY_LOCAL_MACHINE":
+		hive = "HKLM"
+	case "HKEY_CURRENT_USER":
+		hive = "HKCU"
+	case "HKEY_CLASSES_ROOT":
+		hive = "HKCR"
+	case "HKEY_USERS":
+		hive = "HKU"
+	}
+	if rest == "" {
+		return hive
+	}
+	return hive + `\` + rest
+}
+
+// lookPath resolves a bare command name the way exec.Command does.
+func lookPath(command string) string {
+	if command == "" || strings.ContainsAny(command, `/\`) {
+		return command
+	}
+	if p, err := exec.LookPath(command); err == nil {
+		return p
+	}
+	return command
+}
+
+func equal(a, b string, fold bool) bool {
+	if fold {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/autostep/autostep/internal/workflow"
+)
+
+// installTool puts an executable named name in a directory that becomes the only PATH
+// entry, and returns its path.
+func installTool(t *testing.T, name string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	return path
+}
+
+func TestCheck(t *testing.T) {
+	tool := installTool(t, "fixer")
+	toolName := filepath.Base(tool)
+	elsewhere := filepath.Join(t.TempDir(), toolName)
+	root := filepath.Join(t.TempDir(), "Temp")
+	windows := runtime.GOOS == "windows"
+
+	run := func(command string) workflow.Step { return workflow.Step{ID: "s", Action: "run", Command: command} }
+	tests := []struct {
+		name   string
+		rule   Rule
+		step   workflow.Step
+		denied bool
+	}{
+		{"bare name allows bare command", Rule{Allow: []string{toolName}}, run(toolName), false},
+		{"bare name allows the file on PATH", Rule{Allow: []string{toolName}}, run(tool), false},
+		{"bare name does not allow a file elsewhere", Rule{Allow: []string{toolName}}, run(elsewhere), true},
+		{"bare name does not allow another name", Rule{Allow: []string{toolName}}, run("other"), true},
+		{"full path allows itself", Rule{Allow: []string{tool}}, run(tool), false},
+		{"full path allows an unclean spelling", Rule{Allow: []string{tool}}, run(filepath.Join(filepath.Dir(tool), ".", toolName)), false},
+		{"full path allows the bare name on PATH", Rule{Allow: []string{tool}}, run(toolName), false},
+		{"full path does not allow another file", Rule{Allow: []string{tool}}, run(elsewhere), true},
+		{"case folds on Windows only", Rule{Allow: []string{strings.ToUpper(toolName)}}, run(toolName), !windows},
+		{"forbidden bare name matches anywhere", Rule{Forbid: []string{toolName}}, run(elsewhere), true},
+		{"forbid overrides allow", Rule{Allow: []string{tool}, Forbid: []string{toolName}}, run(tool), true},
+		{"deny overrides allow", Rule{Deny: true, Allow: []string{tool}}, run(tool), true},
+		{"file below allowed dir", Rule{Allow: []string{root}},
+			workflow.Step{ID: "s", Action: "file_copy", DstPath: filepath.Join(root, "a.txt")}, false},
+		{"file in sibling with common prefix", Rule{Allow: []string{root}},
+			workflow.Step{ID: "s", Action: "file_copy", DstPath: root + "2" + string(filepath.Separator) + "a.txt"}, true},
+		{"forbidden subdir of allowed dir", Rule{Allow: []string{root}, Forbid: []string{filepath.Join(root, "keep")}},
+			workflow.Step{ID: "s", Action: "file_copy", DstPath: filepath.Join(root, "keep", "a.txt")}, true},
+		{"file case folds on Windows only", Rule{Allow: []string{strings.ToUpper(root)}},
+			workflow.Step{ID: "s", Action: "file_copy", DstPath: filepath.Join(root, "a.txt")}, !windows},
+		{"registry hive spellings", Rule{Forbid: []string{`HKLM\SAM`}},
+			workflow.Step{ID: "s", Action: "registry_set", Path: `HKEY_LOCAL_MACHINE\SAM\Domains`}, true},
+		{"registry sibling key", Rule{Forbid: []string{`HKLM\SAM`}},
+			workflow.Step{ID: "s", Action: "registry_set", Path: `HKLM\SAMPLE`}, false},
+		{"service name folds", Rule{Allow: []string{"Spooler"}},
+			workflow.Step{ID: "s", Action: "service_stop", Service: "spooler"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := strings.ToLower(tt.step.Action)
+			p := &Policy{Actions: map[string]Rule{action: tt.rule}}
+			err := p.Check(tt.step, nil)
+			if tt.denied != (err != nil) {
+				t.Fatalf("Check() = %v, denied want %v", err, tt.denied)
+			}
+			if err != nil && !errors.Is(err, ErrDenied) {
+				t.Fatalf("Check() = %v, want ErrDenied", err)
+			}
+		})
+	}
+}
+
+func TestCheckSHA256(t *testing.T) {
+	p := &Policy{Actions: map[string]Rule{"driver_load": {SHA256: []string{"ABCD"}}}}
+	step := workflow.Step{ID: "s", Action: "driver_load", DriverPath: "drv.sys"}
+	hashes := map[string]error{"abcd": nil, "ffff": ErrDenied}
+	for sum, want := range hashes {
+		err := p.Check(step, func(string) (string, error) { return sum, nil })
+		if !errors.Is(err, want) {
+			t.Errorf("hash %s: Check() = %v, want %v", sum, err, want)
+		}
+	}
+	if err := p.Check(step, func(string) (string, error) { return "", ErrHashUnavailable }); err != nil {
+		t.Errorf("unavailable hash: Check() = %v, want the rule skipped", err)
+	}
+	if err := (*Policy)(nil).Check(step, nil); err != nil {
+		t.Errorf("nil policy: Check() = %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(`{"actions":{"run":{"allwo":["sh"]}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("Load() accepted a misspelled rule")
+	}
+	if p, err := Load(filepath.Join(t.TempDir(), "missing.json")); p != nil || err != nil {
+		t.Fatalf("Load(missing) = %v, %v; want nil, nil", p, err)
+	}
+}
@@ -0,0 +1,315 @@
+// Package pull implements pull mode: the service polls a coordinator for jobs assigned
+// to this host, downloads their workflow and artifacts into the data root, runs them on
+// the supervisor and reports the results through an on-disk outbox.
+package pull
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autostep/autostep/internal/cas"
+	"github.com/autostep/autostep/internal/coordinator"
+	"github.com/autostep/autostep/internal/events"
+	"github.com/autostep/autostep/internal/outbox"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/signing"
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/supervisor"
+	"github.com/autostep/autostep/internal/workflow"
+)
+
+// outboxTarget addresses queued results to the coordinator.
+const outboxTarget = "coordinator"
+
+// Logger is a minimal logging interface.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Agent polls a coordinator and runs the jobs it assigns.
+type Agent struct {
+	paths  paths.Paths
+	client *coordinator.Client
+	sup    *supervisor.Supervisor
+	logger Logger
+	outbox *outbox.Outbox
+
+	mu     sync.Mutex // guards ledger, which run hooks update from the supervisor
+	ledger *ledger
+}
+
+// New opens the job ledger and result outbox under the data root and hooks into sup so
+// that the result of a job's run is queued as soon as the run finishes.
+func New(p paths.Paths, client *coordinator.Client, sup *supervisor.Supervisor, logger Logger) (*Agent, error) {
+	l, err := openLedger(p.JobsPath)
+	if err != nil {
+		return nil, err
+	}
+	ob, err := outbox.Open(filepath.Join(p.OutboxDir, outboxTarget))
+	if err != nil {
+		return nil, err
+	}
+	a := &Agent{paths: p, client: client, sup: sup, logger: logger, ledger: l, outbox: ob}
+	sup.OnRunStatus(a.finished)
+	sup.Events().Subscribe(a.observe)
+	return a, nil
+}
+
+// observe records in the ledger that a job's run has started, so that a run whose
+// record is gone is never mistaken for one that did not start.
+func (a *Agent) observe(e events.Event) {
+	if e.Type != events.RunStarted {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if e := a.ledger.byRun(e.RunID); e != nil && !e.Started {
+		e.Started = true
+		if err := a.ledger.persist(); err != nil {
+			a.logger.Printf("pull: record start of job %s: %v", e.JobID, err)
+		}
+	}
+}
+
+// finished queues the result of a job's run when it finishes. Finished runs are pruned
+// from the state store when later runs finish, so the result must be taken now rather
+// than at the next poll.
+func (a *Agent) finished(rec *state.RunRecord) {
+	if !rec.Finished() {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e := a.ledger.byRun(rec.RunID)
+	if e == nil || e.Reported {
+		return
+	}
+	if err := a.report(e, rec); err != nil {
+		a.logger.Printf("pull: %v", err)
+		return
+	}
+	if err := a.ledger.persist(); err != nil {
+		a.logger.Printf("pull: %v", err)
+	}
+}
+
+// report queues the result of the job's finished run and marks the job reported.
+func (a *Agent) report(e *Entry, rec *state.RunRecord) error {
+	res := coordinator.Result{
+		JobID:      e.JobID,
+		Host:       a.client.Host(),
+		RunID:      rec.RunID,
+		Workflow:   rec.WorkflowName,
+		Status:     rec.Status,
+		Error:      rec.LastError,
+		Steps:      rec.Steps,
+		StartedAt:  rec.StartedAt,
+		FinishedAt: rec.UpdatedAt,
+	}
+	if err := a.outbox.Add(outboxTarget, res); err != nil {
+		return fmt.Errorf("queue result of job %s: %w", e.JobID, err)
+	}
+	e.Reported = true
+	return nil
+}
+
+// Run polls every interval until ctx is cancelled. New jobs are not accepted while a
+// run is waiting for a reboot, but results are still reported.
+func (a *Agent) Run(ctx context.Context, interval time.Duration) error {
+	for {
+		if err := a.collect(); err != nil {
+			a.logger.Printf("pull: %v", err)
+		}
+		a.flush(ctx)
+		if !a.sup.RebootPending() {
+			if err := a.fetch(ctx); err != nil && ctx.Err() == nil {
+				a.logger.Printf("pull: poll coordinator: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// collect queues results of finished runs that the run hook missed, e.g. because the
+// outbox could not be written. A job whose run never started (e.g. abandoned by a
+// shutdown while queued) is forgotten so that the coordinator, which still offers it,
+// hands it out again.
+func (a *Agent) collect() error {
+	queued := map[string]bool{}
+	for _, j := range a.sup.Jobs() {
+		queued[j.RunID] = true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	changed := false
+	for id, e := range a.ledger.Jobs {
+		if e.Reported {
+			continue
+		}
+		rec, ok := a.sup.Store().Run(e.RunID)
+		switch {
+		case !ok && queued[e.RunID]:
+		case !ok && !e.Started:
+			a.logger.Printf("pull: run %s for job %s never started; will accept the job again", e.RunID, id)
+			delete(a.ledger.Jobs, id)
+			changed = true
+		case !ok:
+			// Started, but its record was pruned before the result was queued.
+			rec = &state.RunRecord{RunID: e.RunID, WorkflowName: e.Workflow, Status: state.StatusFailed,
+				LastError: "run record no longer available; result unknown", UpdatedAt: time.Now().UTC()}
+			fallthrough
+		case rec.Finished():
+			if err := a.report(e, rec); err != nil {
+				return err
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return a.ledger.persist()
+}
+
+// flush delivers queued results in order, stopping at the first transient failure.
+func (a *Agent) flush(ctx context.Context) {
+	due, err := a.outbox.Due(time.Now())
+	if err != nil {
+		a.logger.Printf("pull: read outbox: %v", err)
+		return
+	}
+	for _, m := range due {
+		var res coordinator.Result
+		if err := json.Unmarshal(m.Body, &res); err != nil {
+			a.logger.Printf("pull: dropping unreadable result %s: %v", m.ID, err)
+			a.outbox.Remove(m)
+			continue
+		}
+		err := a.client.Report(ctx, res)
+		var se *coordinator.StatusError
+		switch {
+		case err == nil:
+			a.outbox.Remove(m)
+		case errors.As(err, &se) && se.Permanent():
+			a.logger.Printf("pull: coordinator rejected result of job %s; dropping it: %v", res.JobID, err)
+			a.outbox.Remove(m)
+		default:
+			if ctx.Err() == nil {
+				a.logger.Printf("pull: report job %s (attempt %d, %d queued): %v", res.JobID, m.Attempts+1, a.outbox.Len(), err)
+				a.outbox.Retry(m, err)
+			}
+			return
+		}
+	}
+}
+
+// fetch accepts jobs the coordinator assigns that have not been accepted before.
+func (a *Agent) fetch(ctx context.Context) error {
+	jobs, err := a.client.Jobs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !coordinator.ValidID(job.ID) {
+			a.logger.Printf("pull: ignoring job with invalid id %q", job.ID)
+			continue
+		}
+		a.mu.Lock()
+		_, seen := a.ledger.Jobs[job.ID]
+		a.mu.Unlock()
+		if seen {
+			continue
+		}
+		if err := a.accept(ctx, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accept downloads a job's files and queues its run. Download failures are retried on
+// the next poll; a job whose workflow cannot be run is reported as failed.
+func (a *Agent) accept(ctx context.Context, job coordinator.Job) error {
+	wfPath, aliases, err := a.download(ctx, job)
+	if err != nil {
+		a.logger.Printf("pull: job %s: %v; will retry", job.ID, err)
+		return nil
+	}
+	entry := &Entry{JobID: job.ID, AcceptedAt: time.Now().UTC()}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	wf, err := workflow.Load(wfPath)
+	if err == nil {
+		wf.Artifacts = aliases
+		_, err = wf.ResolveParams(job.Params)
+	}
+	if err != nil {
+		a.logger.Printf("pull: job %s rejected: %v", job.ID, err)
+		res := coordinator.Result{JobID: job.ID, Host: a.client.Host(), Status: state.StatusFailed, Error: err.Error(), FinishedAt: time.Now().UTC()}
+		if err := a.outbox.Add(outboxTarget, res); err != nil {
+			return fmt.Errorf("queue result of job %s: %w", job.ID, err)
+		}
+		entry.Reported = true
+		a.ledger.Jobs[job.ID] = entry
+		return a.ledger.persist()
+	}
+
+	entry.RunID = supervisor.NewRunID(wf.Name)
+	entry.Workflow = wf.Name
+	a.ledger.Jobs[job.ID] = entry
+	if err := a.ledger.persist(); err != nil {
+		return err
+	}
+	a.sup.Submit(wf, entry.RunID, job.Params)
+	a.logger.Printf("pull: accepted job %s: workflow %s (run %s)", job.ID, wf.Name, entry.RunID)
+	return nil
+}
+
+// download stores the job's artifacts as blobs in the artifact cache and its workflow,
+// with its signature if it has one, under workflows/jobs/<job-id>/. It returns the
+// workflow path and the artifacts' names as cache:// aliases of their blobs.
+func (a *Agent) download(ctx context.Context, job coordinator.Job) (string, map[string]string, error) {
+	aliases := map[string]string{}
+	for _, f := range job.Artifacts {
+		if !coordinator.ValidName(f.Name) {
+			return "", nil, fmt.Errorf("invalid artifact name %q", f.Name)
+		}
+		if !cas.ValidHash(f.SHA256) {
+			return "", nil, fmt.Errorf("artifact %s: invalid sha256 %q", f.Name, f.SHA256)
+		}
+		if !cas.Has(a.paths.ArtifactsDir, f.SHA256) {
+			if err := a.client.Download(ctx, f, cas.Path(a.paths.ArtifactsDir, f.SHA256)); err != nil {
+				return "", nil, err
+			}
+		}
+		aliases[path.Clean(f.Name)] = strings.ToLower(f.SHA256)
+	}
+	name := job.Workflow.Name
+	if !coordinator.ValidName(name) || strings.Contains(name, "/") {
+		return "", nil, fmt.Errorf("invalid workflow file name %q", name)
+	}
+	dst := filepath.Join(a.paths.WorkflowsDir, "jobs", job.ID, name)
+	if err := a.client.Download(ctx, job.Workflow, dst); err != nil {
+		return "", nil, err
+	}
+	if job.Signature != nil {
+		if err := a.client.Download(ctx, *job.Signature, dst+signing.SigExt); err != nil {
+			return "", nil, err
+		}
+	}
+	return dst, aliases, nil
+}
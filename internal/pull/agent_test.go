@@ -0,0 +1,142 @@
+package pull
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/autostep/autostep/internal/coordinator"
+	"github.com/autostep/autostep/internal/logging"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/supervisor"
+)
+
+const testHost = "host-1"
+
+// newLoop starts a reference coordinator serving jobs and an agent polling it.
+func newLoop(t *testing.T, jobs map[string]coordinator.JobSpec) (*Agent, *supervisor.Supervisor, string) {
+	t.Helper()
+	coordDir := t.TempDir()
+	writeFile(t, filepath.Join(coordDir, "wf.json"), `{"name":"quick","steps":[{"id":"s1","action":"sleep","sleep_seconds":0}]}`)
+	for id, spec := range jobs {
+		data, err := json.Marshal(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(coordDir, "jobs", id+".json"), string(data))
+	}
+	logger := logging.New(io.Discard, io.Discard, slog.LevelError)
+	srv := httptest.NewServer(coordinator.NewServer(coordDir, "token", logger).Handler())
+	t.Cleanup(srv.Close)
+
+	p := paths.FromRoot(t.TempDir())
+	store, err := state.Open(p.StatePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sup := supervisor.New(ctx, p, store, logger)
+	t.Cleanup(func() {
+		cancel()
+		sup.Shutdown(5 * time.Second)
+	})
+	client, err := coordinator.NewClient(srv.URL, "token", testHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent, err := New(p, client, sup, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent, sup, coordDir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitIdle(t *testing.T, sup *supervisor.Supervisor) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for len(sup.Jobs()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("runs still queued: %v", sup.Jobs())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestJobsFinishingWithinOneInterval runs two jobs back to back before the agent polls
+// again. Only the latest finished run survives in the state store, so the result of the
+// first must have been queued when it finished, and neither job may be run again.
+func TestJobsFinishingWithinOneInterval(t *testing.T) {
+	agent, sup, coordDir := newLoop(t, map[string]coordinator.JobSpec{
+		"job-a": {Workflow: "wf.json"},
+		"job-b": {Workflow: "wf.json"},
+	})
+	ctx := context.Background()
+
+	if err := agent.fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, sup)
+	if n := len(sup.Store().Export()); n != 1 {
+		t.Fatalf("state store holds %d runs, want only the latest finished one", n)
+	}
+
+	if err := agent.collect(); err != nil {
+		t.Fatal(err)
+	}
+	agent.flush(ctx)
+	for _, id := range []string{"job-a", "job-b"} {
+		data, err := os.ReadFile(filepath.Join(coordDir, "results", id, testHost+".json"))
+		if err != nil {
+			t.Fatalf("no result for %s: %v", id, err)
+		}
+		var res coordinator.Result
+		if err := json.Unmarshal(data, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != state.StatusCompleted || res.RunID == "" {
+			t.Errorf("%s: got status %q run %q, want a completed run", id, res.Status, res.RunID)
+		}
+		if e := agent.ledger.Jobs[id]; e == nil || !e.Started || !e.Reported {
+			t.Errorf("%s: ledger entry %+v, want started and reported", id, e)
+		}
+	}
+
+	// Nothing is offered or run again.
+	if err := agent.fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := sup.Jobs(); len(jobs) != 0 {
+		t.Fatalf("jobs re-run: %v", jobs)
+	}
+}
+
+// TestUnstartedJobIsAcceptedAgain checks that a job whose run was never started is
+// forgotten so that the coordinator hands it out again.
+func TestUnstartedJobIsAcceptedAgain(t *testing.T) {
+	agent, _, _ := newLoop(t, nil)
+	agent.ledger.Jobs["job-c"] = &Entry{JobID: "job-c", RunID: "quick-1", Workflow: "quick", AcceptedAt: time.Now().UTC()}
+
+	if err := agent.collect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := agent.ledger.Jobs["job-c"]; ok {
+		t.Fatal("unstarted job still in the ledger")
+	}
+}
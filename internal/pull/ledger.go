@@ -0,0 +1,74 @@
+package pull
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// entryRetention bounds how long reported jobs are remembered to avoid re-running them.
+const entryRetention = 30 * 24 * time.Hour
+
+// Entry records a job the agent has accepted.
+type Entry struct {
+	JobID      string    `json:"job_id"`
+	RunID      string    `json:"run_id,omitempty"`
+	Workflow   string    `json:"workflow,omitempty"`
+	AcceptedAt time.Time `json:"accepted_at"`
+	Started    bool      `json:"started,omitempty"` // the run has started
+	Reported   bool      `json:"reported"`          // result queued in the outbox
+}
+
+// ledger is the durable list of accepted jobs stored in jobs.json.
+type ledger struct {
+	path string
+
+	Jobs map[string]*Entry `json:"jobs"`
+}
+
+func openLedger(path string) (*ledger, error) {
+	l := &ledger{path: path}
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read jobs: %w", err)
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, l); err != nil {
+			return nil, fmt.Errorf("parse jobs: %w", err)
+		}
+	}
+	if l.Jobs == nil {
+		l.Jobs = map[string]*Entry{}
+	}
+	return l, nil
+}
+
+// byRun returns the entry of the job run as runID, or nil.
+func (l *ledger) byRun(runID string) *Entry {
+	for _, e := range l.Jobs {
+		if e.RunID == runID {
+			return e
+		}
+	}
+	return nil
+}
+
+func (l *ledger) persist() error {
+	cutoff := time.Now().UTC().Add(-entryRetention)
+	for id, e := range l.Jobs {
+		if e.Reported && e.AcceptedAt.Before(cutoff) {
+			delete(l.Jobs, id)
+		}
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
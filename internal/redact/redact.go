@@ -0,0 +1,134 @@
+// Package redact removes secret values from text before it is logged, stored or shown.
+// Values are registered process-wide as secrets are resolved, so every logger, error
+// and output stream in the process redacts them from then on.
+package redact
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces each occurrence of a secret value.
+const Mask = "[redacted]"
+
+// MinLen is the shortest value redacted; shorter values would mask ordinary text.
+const MinLen = 4
+
+var (
+	mu       sync.RWMutex
+	values   []string // longest first, so a value containing another is masked whole
+	replacer *strings.Replacer
+)
+
+// Add registers secret values to redact.
+func Add(vs ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	added := false
+	for _, v := range vs {
+		if len(v) < MinLen || contains(values, v) {
+			continue
+		}
+		values = append(values, v)
+		added = true
+	}
+	if !added {
+		return
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, Mask)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns s with every registered value masked.
+func String(s string) string {
+	mu.RLock()
+	r := replacer
+	mu.RUnlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// Error returns err with its message redacted. The result still unwraps to err, so
+// errors.Is and errors.As see through it. A nil err yields nil.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := String(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redacted{msg: msg, err: err}
+}
+
+type redacted struct {
+	msg string
+	err error
+}
+
+func (e *redacted) Error() string { return e.msg }
+func (e *redacted) Unwrap() error { return e.err }
+
+// maxLine bounds the partial line a Writer holds back; longer lines are redacted in pieces.
+const maxLine = 64 << 10
+
+// Writer redacts what is written through it line by line, so a value split across
+// writes is still masked unless it spans lines. Close flushes an unterminated last line.
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter returns a Writer that writes redacted lines to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	last := bytes.LastIndexByte(w.buf, '\n')
+	if last < 0 && len(w.buf) > maxLine {
+		last = len(w.buf) - 1
+	}
+	if last < 0 {
+		return len(p), nil
+	}
+	if _, err := io.WriteString(w.w, String(string(w.buf[:last+1]))); err != nil {
+		return 0, err
+	}
+	w.buf = append(w.buf[:0], w.buf[last+1:]...)
+	return len(p), nil
+}
+
+// Close writes any buffered partial line.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.w, String(string(w.buf)))
+	w.buf = nil
+	return err
+}
@@ -0,0 +1,110 @@
+package report
+
+import (
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time":     htmlTime,
+	"duration": formatDuration,
+	"join":     strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Run {{.RunID}}: {{.Workflow}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+td.error { white-space: pre-wrap; }
+.completed, .pass { color: #1a7f37; }
+.failed, .fail { color: #cf222e; font-weight: bold; }
+.cancelled, .interrupted, .pending_reboot, .not_run { color: #9a6700; }
+</style>
+</head>
+<body>
+<h1>Run {{.RunID}}: {{.Workflow}}</h1>
+<table>
+<tr><th>Status</th><td class="{{.Status}}">{{.Status}}</td></tr>
+{{- with .Version}}
+<tr><th>Version</th><td>{{.}}</td></tr>
+{{- end}}
+<tr><th>Host</th><td>{{.Host}}</td></tr>
+<tr><th>Started</th><td>{{time .StartedAt}}</td></tr>
+{{- if .FinishedAt}}
+<tr><th>Finished</th><td>{{time .FinishedAt}}</td></tr>
+{{- end}}
+<tr><th>Duration</th><td>{{duration .Duration}}</td></tr>
+{{- with .ParamList}}
+<tr><th>Parameters</th><td>{{join . ", "}}</td></tr>
+{{- end}}
+{{- if .Error}}
+<tr><th>Error</th><td class="error">{{.Error}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Steps</h2>
+<table>
+<tr><th>#</th><th>Step</th><th>Action</th><th>Status</th><th>Started</th><th>Duration</th><th>Error</th></tr>
+{{- range .Steps}}
+<tr><td>{{.Number}}</td><td>{{.ID}}</td><td>{{.Action}}</td><td class="{{.Status}}">{{.Status}}</td><td>{{time .StartedAt}}</td><td>{{if .FinishedAt}}{{duration .Duration}}{{end}}</td><td class="error">{{.Error}}</td></tr>
+{{- end}}
+</table>
+{{- if .Reboots}}
+
+<h2>Reboots</h2>
+<table>
+<tr><th>Step</th><th>Boot mode</th><th>Requested</th><th>Resumed</th></tr>
+{{- range .Reboots}}
+<tr><td>{{.StepID}}</td><td>{{.BootMode}}</td><td>{{time .RequestedAt}}</td><td>{{time .ResumedAt}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- range .Steps}}{{if .Assertions}}
+
+<h2>Verify: {{.ID}}</h2>
+<table>
+<tr><th>Kind</th><th>Path</th><th>Expected</th><th>Result</th><th>Error</th></tr>
+{{- range .Assertions}}
+<tr><td>{{.Kind}}</td><td>{{.Path}}</td><td>{{.Expected}}</td>{{if .Passed}}<td class="pass">pass</td>{{else}}<td class="fail">fail</td>{{end}}<td class="error">{{.Error}}</td></tr>
+{{- end}}
+</table>
+{{- end}}{{end}}
+</body>
+</html>
+`))
+
+// htmlReport adds the helpers the template needs.
+type htmlReport struct {
+	*Report
+	ParamList []string
+	Steps     []htmlStep
+}
+
+type htmlStep struct {
+	Step
+	Number int
+}
+
+func renderHTML(w io.Writer, r *Report) error {
+	data := htmlReport{Report: r, ParamList: r.paramList()}
+	for _, s := range r.Steps {
+		data.Steps = append(data.Steps, htmlStep{Step: s, Number: s.Index + 1})
+	}
+	return htmlTemplate.Execute(w, data)
+}
+
+// htmlTime formats a time.Time or *time.Time.
+func htmlTime(v any) string {
+	switch t := v.(type) {
+	case time.Time:
+		return formatTime(&t)
+	case *time.Time:
+		return formatTime(t)
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// JUnit XML as understood by common CI dashboards: one test case per step, plus one per
+// recorded assertion of a verify step.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	ID         string          `xml:"id,attr"`
+	Hostname   string          `xml:"hostname,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func renderJUnit(w io.Writer, r *Report) error {
+	suite := junitSuite{
+		Name:      r.Workflow,
+		ID:        r.RunID,
+		Hostname:  r.Host,
+		Timestamp: r.StartedAt.UTC().Format("2006-01-02T15:04:05"),
+		Time:      seconds(r.Duration),
+		Properties: []junitProperty{
+			{Name: "run_id", Value: r.RunID},
+			{Name: "status", Value: r.Status},
+			{Name: "reboots", Value: fmt.Sprint(len(r.Reboots))},
+		},
+	}
+	for _, p := range r.paramList() {
+		name, value, _ := strings.Cut(p, "=")
+		suite.Properties = append(suite.Properties, junitProperty{Name: "param." + name, Value: value})
+	}
+	for _, s := range r.Steps {
+		c := junitCase{Name: fmt.Sprintf("%02d %s", s.Index+1, s.ID), Classname: r.Workflow, Time: seconds(s.Duration)}
+		switch s.Status {
+		case "completed":
+		case "failed":
+			c.Failure = &junitMessage{Message: firstLine(s.Error), Type: s.Action, Text: s.Error}
+		default:
+			c.Skipped = &junitMessage{Message: s.Status}
+		}
+		suite.Cases = append(suite.Cases, c)
+		for _, a := range s.Assertions {
+			ac := junitCase{Name: strings.TrimSpace(a.Kind + " " + a.Path), Classname: r.Workflow + "." + s.ID, Time: seconds(0)}
+			if !a.Passed {
+				ac.Failure = &junitMessage{Message: firstLine(a.Error), Type: a.Kind, Text: a.Error}
+			}
+			suite.Cases = append(suite.Cases, ac)
+		}
+	}
+	for _, c := range suite.Cases {
+		suite.Tests++
+		if c.Failure != nil {
+			suite.Failures++
+		}
+		if c.Skipped != nil {
+			suite.Skipped++
+		}
+	}
+	doc := junitSuites{
+		Name:     "autostep",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitSuite{suite},
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,72 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func renderMarkdown(w io.Writer, r *Report) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "# Run %s: %s\n\n", mdEscape(r.RunID), mdEscape(r.Workflow))
+	fmt.Fprintf(b, "| | |\n|---|---|\n")
+	fmt.Fprintf(b, "| Status | **%s** |\n", r.Status)
+	if r.Version != "" {
+		fmt.Fprintf(b, "| Version | %s |\n", mdEscape(r.Version))
+	}
+	fmt.Fprintf(b, "| Host | %s |\n", mdEscape(r.Host))
+	fmt.Fprintf(b, "| Started | %s |\n", formatTime(&r.StartedAt))
+	if r.FinishedAt != nil {
+		fmt.Fprintf(b, "| Finished | %s |\n", formatTime(r.FinishedAt))
+	}
+	fmt.Fprintf(b, "| Duration | %s |\n", formatDuration(r.Duration))
+	if params := r.paramList(); len(params) > 0 {
+		fmt.Fprintf(b, "| Parameters | %s |\n", mdEscape(strings.Join(params, ", ")))
+	}
+	if r.Error != "" {
+		fmt.Fprintf(b, "| Error | %s |\n", mdEscape(r.Error))
+	}
+
+	fmt.Fprintf(b, "\n## Steps\n\n")
+	fmt.Fprintf(b, "| # | Step | Action | Status | Started | Duration | Error |\n|---|---|---|---|---|---|---|\n")
+	for _, s := range r.Steps {
+		duration := ""
+		if s.FinishedAt != nil {
+			duration = formatDuration(s.Duration)
+		}
+		fmt.Fprintf(b, "| %d | %s | %s | %s | %s | %s | %s |\n", s.Index+1, mdEscape(s.ID), mdEscape(s.Action),
+			s.Status, formatTime(s.StartedAt), duration, mdEscape(s.Error))
+	}
+
+	if len(r.Reboots) > 0 {
+		fmt.Fprintf(b, "\n## Reboots\n\n")
+		fmt.Fprintf(b, "| Step | Boot mode | Requested | Resumed |\n|---|---|---|---|\n")
+		for _, rb := range r.Reboots {
+			fmt.Fprintf(b, "| %s | %s | %s | %s |\n", mdEscape(rb.StepID), rb.BootMode, formatTime(&rb.RequestedAt), formatTime(rb.ResumedAt))
+		}
+	}
+
+	for _, s := range r.Steps {
+		if len(s.Assertions) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "\n## Verify: %s\n\n", mdEscape(s.ID))
+		fmt.Fprintf(b, "| Kind | Path | Expected | Result | Error |\n|---|---|---|---|---|\n")
+		for _, a := range s.Assertions {
+			result := "pass"
+			if !a.Passed {
+				result = "**fail**"
+			}
+			fmt.Fprintf(b, "| %s | %s | %s | %s | %s |\n", mdEscape(a.Kind), mdEscape(a.Path), mdEscape(a.Expected), result, mdEscape(a.Error))
+		}
+	}
+	return b.Flush()
+}
+
+// mdEscape keeps s inside a single table cell.
+func mdEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r", ""), "\n", "<br>")
+}
@@ -0,0 +1,214 @@
+// Package report renders a run record as a human- or machine-readable report:
+// Markdown for change management, HTML, JUnit XML for CI dashboards, or JSON.
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/autostep/autostep/internal/seal"
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/workflow"
+)
+
+// Formats lists the supported output formats.
+var Formats = []string{"md", "html", "junit", "json"}
+
+// StatusNotRun marks steps the run never reached.
+const StatusNotRun = "not_run"
+
+// Report is the format-independent view of a run.
+type Report struct {
+	RunID      string               `json:"run_id"`
+	Workflow   string               `json:"workflow"`
+	Version    string               `json:"workflow_version,omitempty"`
+	Host       string               `json:"host"`
+	Status     string               `json:"status"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+	Duration   float64              `json:"duration_seconds"`
+	Error      string               `json:"error,omitempty"`
+	Params     map[string]string    `json:"params,omitempty"`
+	Steps      []Step               `json:"steps"`
+	Reboots    []state.RebootRecord `json:"reboots,omitempty"`
+}
+
+// Step is one workflow step of the report.
+type Step struct {
+	Index      int                     `json:"index"`
+	ID         string                  `json:"id"`
+	Action     string                  `json:"action,omitempty"`
+	Status     string                  `json:"status"`
+	Error      string                  `json:"error,omitempty"`
+	StartedAt  *time.Time              `json:"started_at,omitempty"`
+	FinishedAt *time.Time              `json:"finished_at,omitempty"`
+	Duration   float64                 `json:"duration_seconds,omitempty"`
+	Assertions []state.AssertionRecord `json:"assertions,omitempty"`
+}
+
+// Build assembles the report for rec. wf, if not nil, supplies the IDs and actions of
+// steps the run has not reached.
+func Build(rec *state.RunRecord, wf *workflow.Workflow) *Report {
+	r := &Report{
+		RunID:     rec.RunID,
+		Workflow:  rec.WorkflowName,
+		Version:   rec.WorkflowVersion,
+		Status:    rec.Status,
+		StartedAt: rec.StartedAt,
+		Error:     rec.LastError,
+		Params:    rec.Params,
+		Reboots:   rec.Reboots,
+	}
+	r.Host, _ = os.Hostname()
+	end := rec.UpdatedAt
+	if rec.Finished() {
+		r.FinishedAt = &end
+	}
+	r.Duration = end.Sub(rec.StartedAt).Seconds()
+	for i, st := range rec.Steps {
+		s := Step{
+			Index:      i,
+			ID:         st.StepID,
+			Action:     st.Action,
+			Status:     st.Status,
+			Error:      st.Error,
+			StartedAt:  st.StartedAt,
+			FinishedAt: st.FinishedAt,
+			Assertions: st.Assertions,
+		}
+		if wf != nil && i < len(wf.Steps) {
+			if s.ID == "" {
+				s.ID = wf.Steps[i].ID
+			}
+			if s.Action == "" {
+				s.Action = wf.Steps[i].Action
+			}
+		}
+		if s.Status == "" {
+			s.Status = StatusNotRun
+		}
+		if st.StartedAt != nil && st.FinishedAt != nil {
+			s.Duration = st.FinishedAt.Sub(*st.StartedAt).Seconds()
+		}
+		r.Steps = append(r.Steps, s)
+	}
+	return r
+}
+
+// ForRun builds the report for rec, loading its workflow from rec.WorkflowPath if it
+// is still there.
+func ForRun(rec *state.RunRecord) *Report {
+	var wf *workflow.Workflow
+	if rec.WorkflowPath != "" {
+		wf, _ = workflow.Load(rec.WorkflowPath)
+	}
+	return Build(rec, wf)
+}
+
+// Render writes r to w in format.
+func Render(w io.Writer, r *Report, format string) error {
+	switch format {
+	case "md":
+		return renderMarkdown(w, r)
+	case "html":
+		return renderHTML(w, r)
+	case "junit":
+		return renderJUnit(w, r)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	default:
+		return fmt.Errorf("unknown report format %q (want %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// Ext returns the file extension used for format.
+func Ext(format string) string {
+	if format == "junit" {
+		return ".xml"
+	}
+	return "." + format
+}
+
+// Save writes r to dir as "<run-id><ext>" in each format, through codec (nil for
+// plaintext).
+func Save(dir string, r *Report, formats []string, codec *seal.Codec) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, format := range formats {
+		name := filepath.Join(dir, r.RunID+Ext(format))
+		tmp := name + ".tmp"
+		var buf bytes.Buffer
+		err := Render(&buf, r, format)
+		var data []byte
+		if err == nil {
+			data, err = codec.Encode(buf.Bytes())
+		}
+		if err == nil {
+			err = os.WriteFile(tmp, data, codec.FileMode())
+		}
+		if err == nil {
+			err = os.Rename(tmp, name)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("write %s report: %w", format, err)
+		}
+	}
+	return nil
+}
+
+// Load reads a JSON report written by Save.
+func Load(path string, codec *seal.Codec) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = codec.Decode(data); err != nil {
+		return nil, fmt.Errorf("read report %s: %w", path, err)
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parse report %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// counts returns the number of steps per status.
+func (r *Report) counts() map[string]int {
+	c := map[string]int{}
+	for _, s := range r.Steps {
+		c[s.Status]++
+	}
+	return c
+}
+
+// paramList returns the parameters as sorted "name=value" strings.
+func (r *Report) paramList() []string {
+	var out []string
+	for k, v := range r.Params {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func formatDuration(seconds float64) string {
+	return (time.Duration(seconds * float64(time.Second))).Round(time.Millisecond).String()
+}
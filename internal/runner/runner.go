@@ -11,78 +11,306 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/autostep/autostep/internal/actions"
+	"github.com/autostep/autostep/internal/bootid"
+	"github.com/autostep/autostep/internal/eventlog"
+	"github.com/autostep/autostep/internal/events"
+	"github.com/autostep/autostep/internal/fetch"
 	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/policy"
+	"github.com/autostep/autostep/internal/redact"
+	"github.com/autostep/autostep/internal/secrets"
 	"github.com/autostep/autostep/internal/state"
 	"github.com/autostep/autostep/internal/workflow"
 )
 
+// ErrInterrupted indicates the run was stopped by context cancellation (e.g., service
+// shutdown). The run is recorded as interrupted and can be resumed from the same step.
+var ErrInterrupted = errors.New("run interrupted")
+
+// ErrCancelled is used as the context cancellation cause to cancel a run on request.
+// Unlike an interrupted run, a cancelled run is final and never resumed.
+var ErrCancelled = errors.New("run cancelled")
+
 // Runner executes workflows step-by-step with durable checkpoints.
 type Runner struct {
 	paths  paths.Paths
 	store  *state.Store
 	logger Logger
+	hooks  []RunHook
+	events eventlog.Sink
+	bus    *events.Bus
 }
 
-// Logger is a minimal logging interface.
+// RunHook observes a run record when the run completes, fails, is cancelled, or is
+// about to reboot. Hooks run synchronously, before a reboot is requested.
+type RunHook func(rec *state.RunRecord)
+
+// Logger is the structured logger runs report to. Level methods take slog-style
+// key/value pairs; entries about a run carry run_id, and entries about a step also
+// carry step_id and action.
 type Logger interface {
 	Printf(format string, v ...any)
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
 }
 
 // New constructs a Runner.
 func New(p paths.Paths, store *state.Store, logger Logger) *Runner {
-	return &Runner{paths: p, store: store, logger: logger}
+	return &Runner{paths: p, store: store, logger: logger, events: eventlog.Discard, bus: events.NewBus()}
+}
+
+// Events returns the bus the runner publishes run events on.
+func (r *Runner) Events() *events.Bus {
+	return r.bus
+}
+
+// SetEventBus makes the runner publish on bus, e.g. one shared by several runners.
+func (r *Runner) SetEventBus(bus *events.Bus) {
+	r.bus = bus
+}
+
+// publish stamps e and publishes it.
+func (r *Runner) publish(e events.Event) {
+	e.Time = time.Now().UTC()
+	e.BootID = bootid.Current()
+	r.bus.Publish(e)
+}
+
+// stepEvent returns an event of type t about step idx of wf.
+func stepEvent(t events.Type, runID string, wf *workflow.Workflow, idx int) events.Event {
+	e := events.Event{Type: t, RunID: runID, Workflow: wf.Name}
+	if idx >= 0 && idx < len(wf.Steps) {
+		e.StepID, e.Action = wf.Steps[idx].ID, wf.Steps[idx].Action
+		e.StepIndex = &idx
+	}
+	return e
+}
+
+// SetEventSink sets where run events from the eventlog catalog are written.
+func (r *Runner) SetEventSink(sink eventlog.Sink) {
+	r.events = sink
+}
+
+// emit writes an event to the event sink. Failures are only logged; the system log
+// being unavailable must not affect the run.
+func (r *Runner) emit(write func(uint32, string) error, eventID uint32, format string, v ...any) {
+	if err := write(eventID, fmt.Sprintf(format, v...)); err != nil {
+		r.logger.Debug("event sink write failed", "event_id", eventID, "error", err)
+	}
+}
+
+// OnRunStatus registers hooks called on final and pending-reboot transitions.
+func (r *Runner) OnRunStatus(hooks ...RunHook) {
+	r.hooks = append(r.hooks, hooks...)
+}
+
+func (r *Runner) notify(runID string) {
+	rec, ok := r.store.Run(runID)
+	if !ok {
+		return
+	}
+	for _, h := range r.hooks {
+		h(rec)
+	}
+	if rec.Status != state.StatusPendingReboot {
+		// The run is final; its scratch directory is no longer needed.
+		if err := os.RemoveAll(r.paths.RunDir(runID)); err != nil {
+			r.logger.Warn("remove run directory", "run_id", runID, "error", err)
+		}
+	}
 }
 
-// RunWorkflow executes the workflow sequentially.
-func (r *Runner) RunWorkflow(ctx context.Context, runID string, wf *workflow.Workflow) error {
-	if err := r.store.StartRun(runID, wf.Name, len(wf.Steps)); err != nil {
+// RunWorkflow executes the workflow sequentially. Params are validated against the
+// workflow's declarations and stored with the run so they survive reboots.
+func (r *Runner) RunWorkflow(ctx context.Context, runID string, wf *workflow.Workflow, params map[string]string) error {
+	resolved, err := wf.ResolveParams(params)
+	if err != nil {
+		return err
+	}
+	if err := r.store.StartRun(runID, wf.Name, wf.ManifestVersion, wf.Path, len(wf.Steps), resolved, wf.Artifacts); err != nil {
 		return fmt.Errorf("start run: %w", err)
 	}
+	r.logger.Info("run started", "run_id", runID, "workflow", wf.Name, "version", wf.ManifestVersion)
+	r.emit(r.events.Info, eventlog.RunStarted, "Run %s of workflow %s started", runID, wf.Name)
+	r.publish(events.Event{Type: events.RunStarted, RunID: runID, Workflow: wf.Name})
 
 	return r.runFromIndex(ctx, runID, wf, 0)
 }
 
-// ContinueWorkflow resumes an existing run from the given step index.
+// ContinueWorkflow resumes an existing run (pending reboot or interrupted) from the given step index.
 func (r *Runner) ContinueWorkflow(ctx context.Context, runID string, wf *workflow.Workflow, startIndex int) error {
 	if startIndex < 0 || startIndex >= len(wf.Steps) {
 		return fmt.Errorf("start index out of range")
 	}
+	resumed := stepEvent(events.RunResumed, runID, wf, startIndex)
+	if rec, ok := r.store.Run(runID); ok && rec.Status == state.StatusPendingReboot {
+		resumed.BootMode = rec.PendingBootMode
+	}
 	if err := r.store.ClearPendingReboot(runID); err != nil {
 		return fmt.Errorf("clear pending reboot: %w", err)
 	}
+	r.emit(r.events.Info, eventlog.RunResumed, "Run %s of workflow %s resumed at step %s", runID, wf.Name, wf.Steps[startIndex].ID)
+	r.publish(resumed)
 	return r.runFromIndex(ctx, runID, wf, startIndex)
 }
 
 func (r *Runner) runFromIndex(ctx context.Context, runID string, wf *workflow.Workflow, start int) error {
+	rec, ok := r.store.Run(runID)
+	if !ok {
+		return fmt.Errorf("run %s not found", runID)
+	}
+	params := rec.Params
+	if err := os.MkdirAll(r.paths.RunDir(runID), 0o755); err != nil {
+		return fmt.Errorf("create run directory: %w", err)
+	}
 	for idx := start; idx < len(wf.Steps); idx++ {
 		step := wf.Steps[idx]
-		if err := r.store.MarkStepPending(runID, idx, step.ID); err != nil {
+		if ctx.Err() != nil {
+			return r.stopped(ctx, runID, wf, idx, false, ctx.Err())
+		}
+		if err := r.store.MarkStepPending(runID, idx, step.ID, step.Action); err != nil {
 			return fmt.Errorf("mark step pending: %w", err)
 		}
-		if err := r.execStep(ctx, runID, idx, step); err != nil {
+		r.publish(stepEvent(events.StepPending, runID, wf, idx))
+		if err := r.expandAndExec(ctx, runID, idx, step, params); err != nil {
 			if errors.Is(err, actions.ErrRebooting) {
 				// Consider the step committed and stop further processing; run remains pending_reboot.
 				if err2 := r.store.MarkStepComplete(runID, idx); err2 != nil {
 					return fmt.Errorf("mark step complete after reboot: %w", err2)
 				}
+				r.publish(stepEvent(events.StepCompleted, runID, wf, idx))
 				return nil
 			}
+			if ctx.Err() != nil {
+				return r.stopped(ctx, runID, wf, idx, true, err)
+			}
 			_ = r.store.MarkStepFailed(runID, idx, err.Error())
+			failed := stepEvent(events.StepFailed, runID, wf, idx)
+			failed.Error = err.Error()
+			r.publish(failed)
+			failed.Type = events.RunFailed
+			r.publish(failed)
+			r.emit(r.events.Error, eventlog.RunFailed, "Run %s of workflow %s failed at step %s: %v", runID, wf.Name, step.ID, err)
+			r.notify(runID)
 			return err
 		}
 		if err := r.store.MarkStepComplete(runID, idx); err != nil {
 			return fmt.Errorf("mark step complete: %w", err)
 		}
+		r.publish(stepEvent(events.StepCompleted, runID, wf, idx))
+	}
+	if err := r.store.MarkRunCompleted(runID); err != nil {
+		return err
+	}
+	r.emit(r.events.Info, eventlog.RunCompleted, "Run %s of workflow %s completed", runID, wf.Name)
+	r.publish(events.Event{Type: events.RunCompleted, RunID: runID, Workflow: wf.Name})
+	r.notify(runID)
+	return nil
+}
+
+// stopped records a run halted by context cancellation at step idx, either before the step
+// started or while it was in flight, as cancelled or interrupted depending on the cause.
+func (r *Runner) stopped(ctx context.Context, runID string, wf *workflow.Workflow, idx int, inFlight bool, cause error) error {
+	step := wf.Steps[idx]
+	if errors.Is(context.Cause(ctx), ErrCancelled) {
+		if err := r.store.MarkRunCancelled(runID, idx, ErrCancelled.Error()); err != nil {
+			return fmt.Errorf("mark run cancelled: %w", err)
+		}
+		r.emit(r.events.Warning, eventlog.RunCancelled, "Run %s cancelled at step %s", runID, step.ID)
+		r.publish(stepEvent(events.RunCancelled, runID, wf, idx))
+		r.notify(runID)
+		return fmt.Errorf("%w at step %s", ErrCancelled, step.ID)
+	}
+	if inFlight {
+		if err := r.store.MarkStepInterrupted(runID, idx, cause.Error()); err != nil {
+			return fmt.Errorf("mark step interrupted: %w", err)
+		}
+		interrupted := stepEvent(events.RunInterrupted, runID, wf, idx)
+		interrupted.Error = cause.Error()
+		r.publish(interrupted)
+		return fmt.Errorf("%w during step %s: %v", ErrInterrupted, step.ID, cause)
+	}
+	if err := r.store.MarkRunInterrupted(runID, idx, cause.Error()); err != nil {
+		return fmt.Errorf("mark run interrupted: %w", err)
+	}
+	interrupted := stepEvent(events.RunInterrupted, runID, wf, idx)
+	interrupted.Error = cause.Error()
+	r.publish(interrupted)
+	return fmt.Errorf("%w before step %s: %v", ErrInterrupted, step.ID, cause)
+}
+
+// expandAndExec resolves ${param:name} and ${secret:name} references in the step and
+// executes it. Secret values are registered for redaction before the step runs, and
+// the step's error is redacted before it is logged or recorded.
+func (r *Runner) expandAndExec(ctx context.Context, runID string, idx int, step workflow.Step, params map[string]string) error {
+	var secretErr error
+	expanded, err := step.Expand(func(kind, name string) (string, bool) {
+		switch kind {
+		case "param":
+			v, ok := params[name]
+			return v, ok
+		case "secret":
+			v, err := secrets.Open(r.paths.SecretsPath, r.paths.KeyPath).Get(name)
+			if err != nil {
+				if secretErr == nil {
+					secretErr = fmt.Errorf("step %s: %w", step.ID, err)
+				}
+				return "", false
+			}
+			redact.Add(v)
+			return v, true
+		}
+		return "", false
+	})
+	if secretErr != nil {
+		return secretErr
+	}
+	if err != nil {
+		return err
 	}
-	return r.store.MarkRunCompleted(runID)
+	attrs := []any{"run_id", runID, "step_id", step.ID, "action", step.Action, "step_index", idx}
+	r.logger.Info("step started", attrs...)
+	err = redact.Error(r.execStep(ctx, runID, idx, expanded))
+	switch {
+	case err == nil:
+		r.logger.Debug("step completed", attrs...)
+	case errors.Is(err, actions.ErrRebooting):
+		r.logger.Info("step requested reboot", attrs...)
+	case ctx.Err() != nil:
+		r.logger.Warn("step stopped", append(attrs, "error", err)...)
+	default:
+		r.logger.Error("step failed", append(attrs, "error", err)...)
+		r.emit(r.events.Error, eventlog.StepFailed, "Run %s: step %s (%s) failed: %v", runID, step.ID, step.Action, err)
+	}
+	return err
 }
 
 func (r *Runner) execStep(ctx context.Context, runID string, idx int, step workflow.Step) error {
-	r.logger.Printf("run %s step %s action=%s", runID, step.ID, step.Action)
+	res := r.paths.Resolver(runID)
+	if rec, ok := r.store.Run(runID); ok {
+		res.Aliases = rec.Artifacts
+	}
+	step, err := step.ResolvePaths(res.Path, res.Pattern)
+	if err != nil {
+		return err
+	}
+	// A URL source is fetched into the store first, so policy sees the content.
+	if strings.EqualFold(step.Action, "file_copy") && fetch.IsURL(step.SrcPath) {
+		if step.SrcPath, _, err = fetch.Default.Fetch(ctx, r.paths.ArtifactsDir, step.SrcPath, step.VerifySHA256); err != nil {
+			return err
+		}
+	}
+	if err := r.checkPolicy(step); err != nil {
+		return err
+	}
 	switch strings.ToLower(step.Action) {
 	case "file_copy":
 		return r.handleFileCopy(step)
@@ -123,11 +351,11 @@ func (r *Runner) execStep(ctx context.Context, runID string, idx int, step workf
 	case "reboot":
 		return r.handleReboot(runID, idx, step)
 	case "verify":
-		return r.handleVerify(step)
+		return r.handleVerify(runID, idx, step)
 	case "run":
-		return r.handleRun(ctx, step)
+		return r.handleRun(ctx, runID, idx, step)
 	case "sleep":
-		return r.handleSleep(step)
+		return r.handleSleep(ctx, step)
 	case "safeboot":
 		return r.handleSafeBoot(step)
 	default:
@@ -135,18 +363,24 @@ func (r *Runner) execStep(ctx context.Context, runID string, idx int, step workf
 	}
 }
 
+// checkPolicy enforces policy.json, read for every step so that edits apply to runs in
+// progress. A policy that cannot be read denies every step.
+func (r *Runner) checkPolicy(step workflow.Step) error {
+	p, err := policy.Load(r.paths.PolicyPath)
+	if err != nil {
+		return err
+	}
+	return p.Check(step, fileSHA256)
+}
+
 func (r *Runner) handleFileCopy(step workflow.Step) error {
 	if step.SrcPath == "" || step.DstPath == "" {
 		return errors.New("file_copy requires src_path and dst_path")
 	}
-	src := step.SrcPath
-	if strings.HasPrefix(src, "cache://") {
-		src = filepath.Join(r.paths.ArtifactsDir, strings.TrimPrefix(src, "cache://"))
-	}
 	if err := os.MkdirAll(filepath.Dir(step.DstPath), 0o755); err != nil {
 		return fmt.Errorf("make dest dir: %w", err)
 	}
-	if err := copyFile(src, step.DstPath); err != nil {
+	if err := copyFile(step.SrcPath, step.DstPath); err != nil {
 		return err
 	}
 	if step.VerifySHA256 != "" {
@@ -392,55 +626,89 @@ func (r *Runner) handleReboot(runID string, idx int, step workflow.Step) error {
 	if err := r.store.MarkPendingReboot(runID, next, bootMode, step.ResumeDelaySeconds); err != nil {
 		return err
 	}
+	r.emit(r.events.Info, eventlog.RebootRequested, "Run %s: step %s requested a %s reboot; resuming at step %d", runID, step.ID, bootMode, next)
+	reboot := events.Event{Type: events.RebootRequested, RunID: runID, StepID: step.ID, StepIndex: &idx, Action: step.Action, BootMode: bootMode}
+	if rec, ok := r.store.Run(runID); ok {
+		reboot.Workflow = rec.WorkflowName
+	}
+	r.publish(reboot)
+	r.notify(runID)
 	if err := actions.RequestReboot(step.SafeMode); err != nil {
 		return err
 	}
 	return actions.ErrRebooting
 }
 
-func (r *Runner) handleVerify(step workflow.Step) error {
+// handleVerify checks the step's assertions in order, stopping at the first failure,
+// and records each outcome with the run.
+func (r *Runner) handleVerify(runID string, idx int, step workflow.Step) error {
+	var results []state.AssertionRecord
+	var failed error
 	for _, assertion := range step.Assertions {
-		switch strings.ToLower(assertion.Kind) {
-		case "file_exists":
-			if assertion.Path == "" {
-				return errors.New("file_exists requires path")
-			}
-			expect, err := expectedBool(assertion.Expected)
-			if err != nil {
-				return err
-			}
-			_, statErr := os.Stat(assertion.Path)
-			if expect {
-				if statErr != nil {
-					return fmt.Errorf("verify file_exists failed: %w", statErr)
-				}
-			} else {
-				if statErr == nil {
-					return fmt.Errorf("verify file_exists expected absence but found: %s", assertion.Path)
-				}
-				if !errors.Is(statErr, os.ErrNotExist) {
-					return fmt.Errorf("verify file_exists unexpected error: %w", statErr)
-				}
-			}
-		case "registry_equals":
-			if assertion.Path == "" {
-				return errors.New("registry_equals requires path")
+		err := checkAssertion(assertion)
+		res := state.AssertionRecord{Kind: assertion.Kind, Path: assertion.Path, Passed: err == nil}
+		if assertion.Expected != nil {
+			res.Expected = fmt.Sprint(assertion.Expected)
+		}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+		if err != nil {
+			failed = err
+			break
+		}
+	}
+	if err := r.store.RecordAssertions(runID, idx, results); err != nil {
+		r.logger.Warn("record assertions failed", "run_id", runID, "step_id", step.ID, "error", err)
+	}
+	return failed
+}
+
+func checkAssertion(assertion workflow.Assertion) error {
+	switch strings.ToLower(assertion.Kind) {
+	case "file_exists":
+		if assertion.Path == "" {
+			return errors.New("file_exists requires path")
+		}
+		expect, err := expectedBool(assertion.Expected)
+		if err != nil {
+			return err
+		}
+		_, statErr := os.Stat(assertion.Path)
+		if expect {
+			if statErr != nil {
+				return fmt.Errorf("verify file_exists failed: %w", statErr)
 			}
-			got, err := actions.RegistryGetString(assertion.Path)
-			if err != nil {
-				return fmt.Errorf("registry read: %w", err)
+		} else {
+			if statErr == nil {
+				return fmt.Errorf("verify file_exists expected absence but found: %s", assertion.Path)
 			}
-			if fmt.Sprint(assertion.Expected) != got {
-				return fmt.Errorf("registry_equals mismatch: expected %v got %s", assertion.Expected, got)
+			if !errors.Is(statErr, os.ErrNotExist) {
+				return fmt.Errorf("verify file_exists unexpected error: %w", statErr)
 			}
-		default:
-			return fmt.Errorf("unknown assertion kind %q", assertion.Kind)
 		}
+	case "registry_equals":
+		if assertion.Path == "" {
+			return errors.New("registry_equals requires path")
+		}
+		got, err := actions.RegistryGetString(assertion.Path)
+		if err != nil {
+			return fmt.Errorf("registry read: %w", err)
+		}
+		if fmt.Sprint(assertion.Expected) != got {
+			return fmt.Errorf("registry_equals mismatch: expected %v got %s", assertion.Expected, got)
+		}
+	default:
+		return fmt.Errorf("unknown assertion kind %q", assertion.Kind)
 	}
 	return nil
 }
 
-func (r *Runner) handleRun(ctx context.Context, step workflow.Step) error {
+// maxCapturedOutput bounds the output kept per run step; older output is dropped first.
+const maxCapturedOutput = 1 << 20
+
+func (r *Runner) handleRun(ctx context.Context, runID string, idx int, step workflow.Step) error {
 	if step.Command == "" {
 		return errors.New("run requires command")
 	}
@@ -448,23 +716,104 @@ func (r *Runner) handleRun(ctx context.Context, step workflow.Step) error {
 	if step.WorkingDir != "" {
 		cmd.Dir = step.WorkingDir
 	}
-	if len(step.Env) > 0 {
-		cmd.Env = os.Environ()
-		for _, kv := range step.Env {
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", kv.Key, kv.Value))
+	opts := actions.ProcessOptions{RunAs: step.RunAs, RunAsPassword: step.RunAsPassword}
+	if l := step.Limits; l != nil {
+		opts.CPUSeconds, opts.MemoryMB, opts.OpenFiles, opts.Processes = l.CPUSeconds, l.MemoryMB, l.OpenFiles, l.Processes
+	}
+	for _, kv := range step.Env {
+		opts.Env = append(opts.Env, fmt.Sprintf("%s=%s", kv.Key, kv.Value))
+	}
+	if step.Clean() {
+		cmd.Env = allowedEnv(step.EnvAllow)
+	}
+	captured := &tailBuffer{max: maxCapturedOutput}
+	stdout := redact.NewWriter(io.MultiWriter(os.Stdout, captured))
+	stderr := redact.NewWriter(io.MultiWriter(os.Stderr, captured))
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := actions.RunProcess(cmd, opts)
+	stdout.Close()
+	stderr.Close()
+	if werr := r.saveOutput(runID, idx, step.ID, captured.Bytes()); werr != nil {
+		r.logger.Warn("save step output failed", "run_id", runID, "step_id", step.ID, "error", werr)
+	}
+	return err
+}
+
+// allowedEnv returns the variables of the agent's environment named in allow, matched
+// case-insensitively on Windows. The result is never nil, so it replaces the inherited
+// environment even when empty.
+func allowedEnv(allow []string) []string {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, a := range allow {
+			if name == a || (runtime.GOOS == "windows" && strings.EqualFold(name, a)) {
+				env = append(env, kv)
+				break
+			}
 		}
 	}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return env
+}
+
+// saveOutput writes the output of a run step to logs/output/<run-id>/, through the
+// state store's codec so it is encrypted when the state is.
+func (r *Runner) saveOutput(runID string, idx int, stepID string, data []byte) error {
+	dir := filepath.Join(r.paths.OutputDir, runID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	codec := r.store.Codec()
+	data, err := codec.Encode(data)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%03d-%s.log", idx, unsafeChars.ReplaceAllString(stepID, "_")))
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, codec.FileMode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// tailBuffer keeps the last max bytes written to it. It is safe for concurrent writes.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
 }
 
-func (r *Runner) handleSleep(step workflow.Step) error {
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf...)
+}
+
+func (r *Runner) handleSleep(ctx context.Context, step workflow.Step) error {
 	if step.SleepSeconds < 0 {
 		return errors.New("sleep_seconds must be >= 0")
 	}
-	time.Sleep(time.Duration(step.SleepSeconds) * time.Second)
-	return nil
+	t := time.NewTimer(time.Duration(step.SleepSeconds) * time.Second)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (r *Runner) handleSafeBoot(step workflow.Step) error {
@@ -483,7 +832,7 @@ func (r *Runner) matchPaths(pattern string) ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("compile regex: %w", err)
 	}
-	root := searchRoot(pattern)
+	root := paths.RegexRoot(pattern)
 	if root == "" {
 		root = "."
 	}
@@ -509,29 +858,6 @@ func (r *Runner) matchPaths(pattern string) ([]string, error) {
 	return matches, nil
 }
 
-func searchRoot(pattern string) string {
-	cleaned := filepath.Clean(pattern)
-	meta := "*+?[](){}|^$"
-	firstMeta := -1
-	for i, r := range cleaned {
-		if r == filepath.Separator {
-			continue
-		}
-		if strings.ContainsRune(meta, r) {
-			firstMeta = i
-			break
-		}
-	}
-	if firstMeta == -1 {
-		return filepath.Dir(cleaned)
-	}
-	prefix := cleaned[:firstMeta]
-	if prefix == "" {
-		return string(filepath.Separator)
-	}
-	return filepath.Dir(prefix)
-}
-
 func copyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
@@ -0,0 +1,104 @@
+// Package seal encrypts data at rest with AES-256-GCM under a key kept in a file under
+// the data root. The key file is readable only by the service account; on Windows its
+// content is additionally protected with DPAPI for the local machine.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// KeySize is the length of a key in bytes (AES-256).
+const KeySize = 32
+
+// Key is a data encryption key.
+type Key []byte
+
+// LoadKey reads the key at path.
+func LoadKey(path string) (Key, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	key, err := unprotect(content)
+	if err != nil {
+		return nil, fmt.Errorf("unprotect key %s: %w", path, err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key %s: want %d bytes, got %d", path, KeySize, len(key))
+	}
+	return key, nil
+}
+
+// LoadOrCreateKey returns the key at path, generating one if the file does not exist.
+func LoadOrCreateKey(path string) (Key, error) {
+	key, err := LoadKey(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return key, err
+	}
+	key = make(Key, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	content, err := protect(key)
+	if err != nil {
+		return nil, fmt.Errorf("protect key: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return nil, fmt.Errorf("write key: %w", err)
+	}
+	// Another process may have created the key meanwhile; keep the first one.
+	if err := os.Link(tmp, path); err != nil {
+		os.Remove(tmp)
+		if errors.Is(err, os.ErrExist) {
+			return LoadKey(path)
+		}
+		return nil, fmt.Errorf("write key: %w", err)
+	}
+	os.Remove(tmp)
+	return key, nil
+}
+
+// Seal encrypts plaintext, binding it to aad, which must be passed to Open unchanged.
+// The result is the random nonce followed by the ciphertext.
+func (k Key) Seal(plaintext, aad []byte) ([]byte, error) {
+	gcm, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// Open decrypts data produced by Seal with the same aad.
+func (k Key) Open(sealed, aad []byte) ([]byte, error) {
+	gcm, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	n := gcm.NonceSize()
+	plaintext, err := gcm.Open(nil, sealed[:n], sealed[n:], aad)
+	if err != nil {
+		return nil, errors.New("decrypt: wrong key or corrupted data")
+	}
+	return plaintext, nil
+}
+
+func (k Key) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,161 @@
+// Package secrets keeps named secret values, encrypted with the data key, in
+// secrets.json under the data root. Workflows reference them as ${secret:name}.
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/autostep/autostep/internal/filelock"
+	"github.com/autostep/autostep/internal/seal"
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidName reports whether name can be stored and referenced.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Entry is a stored secret. Value is sealed with the secret's name as associated data,
+// so values cannot be swapped between names.
+type Entry struct {
+	Value     []byte    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type file struct {
+	Secrets map[string]Entry `json:"secrets"`
+}
+
+// Store reads and updates the secrets file.
+type Store struct {
+	path    string
+	keyPath string
+}
+
+// Open returns the store for the secrets file at path, encrypted with the key at keyPath.
+func Open(path, keyPath string) *Store {
+	return &Store{path: path, keyPath: keyPath}
+}
+
+// Get returns the value of the named secret.
+func (s *Store) Get(name string) (string, error) {
+	f, err := s.read()
+	if err != nil {
+		return "", err
+	}
+	e, ok := f.Secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %q is not set", name)
+	}
+	key, err := seal.LoadKey(s.keyPath)
+	if err != nil {
+		return "", err
+	}
+	value, err := key.Open(e.Value, []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("secret %q: %w", name, err)
+	}
+	return string(value), nil
+}
+
+// List returns the stored secrets' names and update times, by name.
+func (s *Store) List() ([]string, map[string]time.Time, error) {
+	f, err := s.read()
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(f.Secrets))
+	updated := make(map[string]time.Time, len(f.Secrets))
+	for name, e := range f.Secrets {
+		names = append(names, name)
+		updated[name] = e.UpdatedAt
+	}
+	sort.Strings(names)
+	return names, updated, nil
+}
+
+// Set stores value under name, creating the key on first use.
+func (s *Store) Set(name, value string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("invalid secret name %q (letters, digits, '.', '_' and '-')", name)
+	}
+	key, err := seal.LoadOrCreateKey(s.keyPath)
+	if err != nil {
+		return err
+	}
+	sealed, err := key.Seal([]byte(value), []byte(name))
+	if err != nil {
+		return err
+	}
+	return s.update(func(f *file) error {
+		f.Secrets[name] = Entry{Value: sealed, UpdatedAt: time.Now().UTC()}
+		return nil
+	})
+}
+
+// Remove deletes the named secret.
+func (s *Store) Remove(name string) error {
+	return s.update(func(f *file) error {
+		if _, ok := f.Secrets[name]; !ok {
+			return fmt.Errorf("secret %q is not set", name)
+		}
+		delete(f.Secrets, name)
+		return nil
+	})
+}
+
+func (s *Store) read() (*file, error) {
+	f := &file{Secrets: map[string]Entry{}}
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read secrets: %w", err)
+	}
+	if err := json.Unmarshal(content, f); err != nil {
+		return nil, fmt.Errorf("parse secrets %s: %w", s.path, err)
+	}
+	if f.Secrets == nil {
+		f.Secrets = map[string]Entry{}
+	}
+	return f, nil
+}
+
+// update applies fn to the file under a cross-process lock and writes it back atomically.
+func (s *Store) update(fn func(*file) error) error {
+	lock, err := filelock.Open(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	f, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := fn(f); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write secrets: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("write secrets: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+// Package signing signs files with ed25519 keys and verifies them against a local trust
+// store. A signature for file F lives next to it in "F.sig"; trusted public keys are the
+// PEM files ("*.pub") in the trust directory under the data root.
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// SigExt is appended to a file's path to name its signature file.
+const SigExt = ".sig"
+
+// Policy decides what happens to content without a valid signature.
+type Policy string
+
+// Policies.
+const (
+	PolicyOff    Policy = "off"    // do not verify
+	PolicyWarn   Policy = "warn"   // verify, log failures and load anyway
+	PolicyRefuse Policy = "refuse" // verify and refuse to load on failure
+)
+
+// ErrUnsigned indicates a file has no signature file.
+var ErrUnsigned = errors.New("not signed")
+
+// Signature is the content of a signature file. It covers the base name of the signed
+// file as well as its bytes, so a signed file copied over a file of another name (e.g.
+// a different workflow) fails verification.
+type Signature struct {
+	KeyID     string `json:"key_id"`
+	Name      string `json:"name"`      // base name of the signed file
+	Signature []byte `json:"signature"` // ed25519 signature of payload(Name, bytes), base64 in JSON
+}
+
+// payload returns the message signed for a file with the given base name and content.
+func payload(name string, data []byte) []byte {
+	msg := make([]byte, 0, len(name)+len(data)+24)
+	msg = append(msg, "autostep-signature-v2\x00"...)
+	msg = append(msg, name...)
+	msg = append(msg, 0)
+	return append(msg, data...)
+}
+
+// sameName reports whether base names a and b name the same file.
+func sameName(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// KeyID identifies a public key: the hex of the first 8 bytes of its SHA-256.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// GenerateKey writes a new key pair to "<base>.key" (PKCS #8 PEM, readable only by the
+// owner) and "<base>.pub" (PKIX PEM), the same formats openssl uses for ed25519.
+func GenerateKey(base string) (ed25519.PublicKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(base+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(base+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// LoadPrivateKey reads a PKCS #8 PEM ed25519 private key.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse key %s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not an ed25519 key", path)
+	}
+	return priv, nil
+}
+
+// LoadPublicKey reads a PKIX PEM ed25519 public key.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse key %s: %w", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not an ed25519 key", path)
+	}
+	return pub, nil
+}
+
+func readPEM(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s: expected a PEM %q block", path, blockType)
+	}
+	return block, nil
+}
+
+// SignFile writes the signature of path to path+SigExt.
+func SignFile(path string, priv ed25519.PrivateKey) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	sig := Signature{
+		KeyID:     KeyID(priv.Public().(ed25519.PublicKey)),
+		Name:      name,
+		Signature: ed25519.Sign(priv, payload(name, data)),
+	}
+	out, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+SigExt, append(out, '\n'), 0o644)
+}
+
+// TrustStore holds the trusted public keys by key ID.
+type TrustStore struct {
+	keys map[string]ed25519.PublicKey
+}
+
+// LoadTrustStore reads every "*.pub" key in dir. A missing directory yields an empty store.
+func LoadTrustStore(dir string) (*TrustStore, error) {
+	ts := &TrustStore{keys: map[string]ed25519.PublicKey{}}
+	names, err := filepath.Glob(filepath.Join(dir, "*.pub"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pub, err := LoadPublicKey(name)
+		if err != nil {
+			return nil, fmt.Errorf("trust store: %w", err)
+		}
+		ts.keys[KeyID(pub)] = pub
+	}
+	return ts, nil
+}
+
+// Len returns the number of trusted keys.
+func (ts *TrustStore) Len() int { return len(ts.keys) }
+
+// Verify checks data, read from path, against the signature file of path.
+func (ts *TrustStore) Verify(path string, data []byte) error {
+	raw, err := os.ReadFile(path + SigExt)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrUnsigned
+	}
+	if err != nil {
+		return err
+	}
+	var sig Signature
+	if err := json.Unmarshal(bytes.TrimSpace(raw), &sig); err != nil {
+		return fmt.Errorf("parse signature: %w", err)
+	}
+	if sig.Name == "" {
+		return errors.New("signature does not name the signed file; sign it again")
+	}
+	if base := filepath.Base(path); !sameName(sig.Name, base) {
+		return fmt.Errorf("signature is for %s, not %s", sig.Name, base)
+	}
+	pub, ok := ts.keys[sig.KeyID]
+	if !ok {
+		return fmt.Errorf("signed with untrusted key %s", sig.KeyID)
+	}
+	if !ed25519.Verify(pub, payload(sig.Name, data), sig.Signature) {
+		return errors.New("signature does not match content")
+	}
+	return nil
+}
+
+// Verifier applies a policy to the verification of loaded content.
+type Verifier struct {
+	Trust  *TrustStore
+	Policy Policy
+	Warn   func(path string, err error) // called for failures under PolicyWarn
+}
+
+var active atomic.Pointer[Verifier]
+
+// SetVerifier installs v as the verifier used by Check; nil disables verification.
+func SetVerifier(v *Verifier) {
+	active.Store(v)
+}
+
+// Refusing reports whether the installed verifier refuses content without a valid
+// signature, so that a file written without one would not load.
+func Refusing() bool {
+	v := active.Load()
+	return v != nil && v.Policy == PolicyRefuse
+}
+
+// Check verifies data read from path with the installed verifier. It returns an error
+// only if verification fails under PolicyRefuse.
+func Check(path string, data []byte) error {
+	v := active.Load()
+	if v == nil || v.Policy == PolicyOff || v.Policy == "" {
+		return nil
+	}
+	err := v.Trust.Verify(path, data)
+	if err == nil {
+		return nil
+	}
+	if v.Policy == PolicyRefuse {
+		return fmt.Errorf("signature check for %s failed: %w", path, err)
+	}
+	if v.Warn != nil {
+		v.Warn(path, err)
+	}
+	return nil
+}
+
+// ParsePolicy validates a policy name; empty means PolicyOff.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case "":
+		return PolicyOff, nil
+	case PolicyOff, PolicyWarn, PolicyRefuse:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid signing policy %q (want off, warn or refuse)", s)
+}
@@ -0,0 +1,89 @@
+package signing
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signed writes content to dir/name and signs it with a key trusted by the returned store.
+func signed(t *testing.T, dir, name, content string) (*TrustStore, string) {
+	t.Helper()
+	trustDir := filepath.Join(dir, "trust")
+	if err := os.MkdirAll(trustDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateKey(filepath.Join(trustDir, "ops")); err != nil {
+		t.Fatal(err)
+	}
+	priv, err := LoadPrivateKey(filepath.Join(trustDir, "ops.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SignFile(path, priv); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := LoadTrustStore(trustDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, path
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	content := "name: patch\nsteps: []\n"
+	ts, path := signed(t, dir, "patch.yaml", content)
+
+	// A signed file copied, with its signature, over another workflow.
+	other := filepath.Join(dir, "cleanup.yaml")
+	copyFile(t, path, other)
+	copyFile(t, path+SigExt, other+SigExt)
+
+	// A signature from before signatures named their file.
+	legacy := filepath.Join(dir, "legacy.yaml")
+	copyFile(t, path, legacy)
+	if err := os.WriteFile(legacy+SigExt, []byte(`{"key_id":"x","signature":"AA=="}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		wantErr string
+	}{
+		{"valid", path, content, ""},
+		{"modified", path, content + "# edit\n", "does not match"},
+		{"copied to another name", other, content, "is for patch.yaml, not cleanup.yaml"},
+		{"unnamed", legacy, content, "does not name"},
+		{"unsigned", filepath.Join(dir, "none.yaml"), content, ErrUnsigned.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ts.Verify(tt.path, []byte(tt.content))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Verify() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Verify() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
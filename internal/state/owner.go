@@ -0,0 +1,27 @@
+package state
+
+import (
+	"os"
+
+	"github.com/autostep/autostep/internal/bootid"
+)
+
+// Owner identifies the process executing a run.
+type Owner struct {
+	BootID string `json:"boot_id"`
+	PID    int    `json:"pid"`
+}
+
+// self returns the owner of runs executed by this process.
+func self() *Owner {
+	return &Owner{BootID: bootid.Current(), PID: os.Getpid()}
+}
+
+// alive reports whether the owning process may still be executing the run. Runs
+// recorded without an owner, or by an earlier boot, have none.
+func (o *Owner) alive() bool {
+	if o == nil || o.BootID != bootid.Current() {
+		return false
+	}
+	return o.PID == os.Getpid() || processAlive(o.PID)
+}
@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/autostep/autostep/internal/seal"
 )
 
 // RunStatus values.
@@ -17,45 +19,90 @@ const (
 	StatusCompleted     = "completed"
 	StatusFailed        = "failed"
 	StatusPendingReboot = "pending_reboot"
+	StatusInterrupted   = "interrupted"
+	StatusCancelled     = "cancelled"
 )
 
 // Store keeps durable run state on disk.
 type Store struct {
-	path string
+	path  string
+	codec *seal.Codec
 
-	mu   sync.Mutex
-	runs map[string]*RunRecord
+	mu      sync.Mutex
+	runs    map[string]*RunRecord
+	hooks   []TransitionHook
+	persist func(time.Duration)
 }
 
+// TransitionHook observes a run after a transition has been persisted. Action names the
+// transition, e.g. "step_completed". Hooks run with the store locked and must not call
+// back into it.
+type TransitionHook func(action string, rec *RunRecord)
+
 // RunRecord tracks a single workflow run.
 type RunRecord struct {
-	RunID               string       `json:"run_id"`
-	WorkflowName        string       `json:"workflow_name"`
-	Status              string       `json:"status"`
-	StartedAt           time.Time    `json:"started_at"`
-	UpdatedAt           time.Time    `json:"updated_at"`
-	CurrentStepIndex    int          `json:"current_step_index"`
-	PendingRebootNext   *int         `json:"pending_reboot_next,omitempty"`
-	PendingBootMode     string       `json:"pending_boot_mode,omitempty"` // normal|safe
-	Steps               []StepRecord `json:"steps"`
-	LastError           string       `json:"last_error,omitempty"`
-	ResumeDelaySeconds  int          `json:"resume_delay_seconds,omitempty"`
-	TotalSteps          int          `json:"total_steps"`
-	WorkflowDisplayName string       `json:"workflow_display_name,omitempty"`
+	RunID               string            `json:"run_id"`
+	WorkflowName        string            `json:"workflow_name"`
+	WorkflowVersion     string            `json:"workflow_version,omitempty"` // manifest version the run was started from
+	WorkflowPath        string            `json:"workflow_path,omitempty"`
+	Status              string            `json:"status"`
+	StartedAt           time.Time         `json:"started_at"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+	CurrentStepIndex    int               `json:"current_step_index"`
+	PendingRebootNext   *int              `json:"pending_reboot_next,omitempty"`
+	PendingBootMode     string            `json:"pending_boot_mode,omitempty"` // normal|safe
+	Steps               []StepRecord      `json:"steps"`
+	LastError           string            `json:"last_error,omitempty"`
+	ResumeDelaySeconds  int               `json:"resume_delay_seconds,omitempty"`
+	TotalSteps          int               `json:"total_steps"`
+	WorkflowDisplayName string            `json:"workflow_display_name,omitempty"`
+	Params              map[string]string `json:"params,omitempty"`
+	Reboots             []RebootRecord    `json:"reboots,omitempty"`
+	Artifacts           map[string]string `json:"artifacts,omitempty"` // cache:// aliases: name -> blob sha256
+	Owner               *Owner            `json:"owner,omitempty"`     // process executing the run
+}
+
+// Finished reports whether the run reached a terminal status and will not be resumed.
+func (r *RunRecord) Finished() bool {
+	return r.Status == StatusCompleted || r.Status == StatusFailed || r.Status == StatusCancelled
 }
 
 // StepRecord stores per-step status.
 type StepRecord struct {
-	StepID string `json:"step_id"`
-	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
+	StepID     string            `json:"step_id"`
+	Action     string            `json:"action,omitempty"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Assertions []AssertionRecord `json:"assertions,omitempty"`
+}
+
+// AssertionRecord is the outcome of one assertion of a verify step.
+type AssertionRecord struct {
+	Kind     string `json:"kind"`
+	Path     string `json:"path,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Passed   bool   `json:"passed"`
+	Error    string `json:"error,omitempty"`
 }
 
-// Open loads an existing store or creates a new one.
-func Open(path string) (*Store, error) {
+// RebootRecord is a reboot requested by a step. ResumedAt is set when the run continues
+// after the reboot.
+type RebootRecord struct {
+	StepID      string     `json:"step_id"`
+	BootMode    string     `json:"boot_mode"`
+	RequestedAt time.Time  `json:"requested_at"`
+	ResumedAt   *time.Time `json:"resumed_at,omitempty"`
+}
+
+// Open loads an existing store or creates a new one. The state file is read and written
+// through codec, which may be nil for plaintext.
+func Open(path string, codec *seal.Codec) (*Store, error) {
 	s := &Store{
-		path: path,
-		runs: map[string]*RunRecord{},
+		path:  path,
+		codec: codec,
+		runs:  map[string]*RunRecord{},
 	}
 	if err := s.load(); err != nil {
 		return nil, err
@@ -77,6 +124,9 @@ func (s *Store) load() error {
 	if len(content) == 0 {
 		return nil
 	}
+	if content, err = s.codec.Decode(content); err != nil {
+		return fmt.Errorf("read state %s: %w", s.path, err)
+	}
 	var runs map[string]*RunRecord
 	if err := json.Unmarshal(content, &runs); err != nil {
 		return fmt.Errorf("parse state: %w", err)
@@ -85,6 +135,11 @@ func (s *Store) load() error {
 	return nil
 }
 
+// Codec returns the codec the store writes with, for files kept alongside the state.
+func (s *Store) Codec() *seal.Codec {
+	return s.codec
+}
+
 // Export returns a copy suitable for printing/status.
 func (s *Store) Export() map[string]*RunRecord {
 	s.mu.Lock()
@@ -92,15 +147,48 @@ func (s *Store) Export() map[string]*RunRecord {
 
 	out := make(map[string]*RunRecord, len(s.runs))
 	for k, v := range s.runs {
-		clone := *v
-		clone.Steps = append([]StepRecord(nil), v.Steps...)
-		out[k] = &clone
+		out[k] = v.clone()
 	}
 	return out
 }
 
-// StartRun initializes a run record.
-func (s *Store) StartRun(runID, workflowName string, totalSteps int) error {
+// Run returns a copy of a single run record.
+func (s *Store) Run(runID string) (*RunRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.runs[runID]
+	if !ok {
+		return nil, false
+	}
+	return v.clone(), true
+}
+
+// clone copies r deeply enough that later store updates do not show through.
+func (r *RunRecord) clone() *RunRecord {
+	c := *r
+	c.Steps = append([]StepRecord(nil), r.Steps...)
+	c.Reboots = append([]RebootRecord(nil), r.Reboots...)
+	return &c
+}
+
+// OnTransition registers hooks called after every persisted transition.
+func (s *Store) OnTransition(hooks ...TransitionHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hooks...)
+}
+
+// OnPersist registers fn to observe how long each write of the state file takes.
+func (s *Store) OnPersist(fn func(time.Duration)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.persist = fn
+}
+
+// StartRun initializes a run record with the run's resolved parameters and cache://
+// aliases. workflowPath lets the run be resumed even if the workflow is not (or no
+// longer) in the manifest.
+func (s *Store) StartRun(runID, workflowName, workflowVersion, workflowPath string, totalSteps int, params, artifacts map[string]string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -108,21 +196,27 @@ func (s *Store) StartRun(runID, workflowName string, totalSteps int) error {
 		return fmt.Errorf("run %s already exists", runID)
 	}
 
-	s.runs[runID] = &RunRecord{
+	rec := &RunRecord{
 		RunID:            runID,
 		WorkflowName:     workflowName,
+		WorkflowVersion:  workflowVersion,
+		WorkflowPath:     workflowPath,
 		Status:           StatusRunning,
 		StartedAt:        time.Now().UTC(),
 		UpdatedAt:        time.Now().UTC(),
 		CurrentStepIndex: 0,
 		Steps:            make([]StepRecord, totalSteps),
 		TotalSteps:       totalSteps,
+		Params:           params,
+		Artifacts:        artifacts,
+		Owner:            self(),
 	}
-	return s.persistLocked()
+	s.runs[runID] = rec
+	return s.commitLocked("run_started", rec)
 }
 
-// MarkStepPending records a step as pending.
-func (s *Store) MarkStepPending(runID string, stepIndex int, stepID string) error {
+// MarkStepPending records a step as pending and starts its timing.
+func (s *Store) MarkStepPending(runID string, stepIndex int, stepID, action string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	rec, ok := s.runs[runID]
@@ -132,8 +226,9 @@ func (s *Store) MarkStepPending(runID string, stepIndex int, stepID string) erro
 	rec.Status = StatusRunning
 	rec.CurrentStepIndex = stepIndex
 	rec.UpdatedAt = time.Now().UTC()
-	rec.Steps[stepIndex] = StepRecord{StepID: stepID, Status: StatusPending}
-	return s.persistLocked()
+	now := time.Now().UTC()
+	rec.Steps[stepIndex] = StepRecord{StepID: stepID, Action: action, Status: StatusPending, StartedAt: &now}
+	return s.commitLocked("step_started", rec)
 }
 
 // MarkStepComplete records completion for a step.
@@ -144,10 +239,10 @@ func (s *Store) MarkStepComplete(runID string, stepIndex int) error {
 	if !ok {
 		return fmt.Errorf("run %s not found", runID)
 	}
-	rec.Steps[stepIndex] = StepRecord{StepID: rec.Steps[stepIndex].StepID, Status: StatusCompleted}
+	finishStep(&rec.Steps[stepIndex], StatusCompleted, "")
 	rec.CurrentStepIndex = stepIndex
 	rec.UpdatedAt = time.Now().UTC()
-	return s.persistLocked()
+	return s.commitLocked("step_completed", rec)
 }
 
 // MarkStepFailed stores failure state for a step and marks run failed.
@@ -158,12 +253,12 @@ func (s *Store) MarkStepFailed(runID string, stepIndex int, errMsg string) error
 	if !ok {
 		return fmt.Errorf("run %s not found", runID)
 	}
-	rec.Steps[stepIndex] = StepRecord{StepID: rec.Steps[stepIndex].StepID, Status: StatusFailed, Error: errMsg}
+	finishStep(&rec.Steps[stepIndex], StatusFailed, errMsg)
 	rec.Status = StatusFailed
 	rec.LastError = errMsg
 	rec.UpdatedAt = time.Now().UTC()
 	s.pruneHistoryLocked()
-	return s.persistLocked()
+	return s.commitLocked("step_failed", rec)
 }
 
 // MarkPendingReboot records a reboot request and the next step.
@@ -179,10 +274,70 @@ func (s *Store) MarkPendingReboot(runID string, nextStep int, bootMode string, d
 	rec.PendingBootMode = bootMode
 	rec.ResumeDelaySeconds = delaySeconds
 	rec.UpdatedAt = time.Now().UTC()
-	return s.persistLocked()
+	reboot := RebootRecord{BootMode: bootMode, RequestedAt: rec.UpdatedAt}
+	if i := nextStep - 1; i >= 0 && i < len(rec.Steps) {
+		reboot.StepID = rec.Steps[i].StepID
+	}
+	rec.Reboots = append(rec.Reboots, reboot)
+	return s.commitLocked("reboot_pending", rec)
+}
+
+// MarkStepInterrupted records that a step was aborted by shutdown and marks the run interrupted.
+// The step is re-executed when the run is resumed.
+func (s *Store) MarkStepInterrupted(runID string, stepIndex int, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.runs[runID]
+	if !ok {
+		return fmt.Errorf("run %s not found", runID)
+	}
+	finishStep(&rec.Steps[stepIndex], StatusInterrupted, reason)
+	rec.Status = StatusInterrupted
+	rec.CurrentStepIndex = stepIndex
+	rec.LastError = reason
+	rec.UpdatedAt = time.Now().UTC()
+	return s.commitLocked("step_interrupted", rec)
+}
+
+// MarkRunInterrupted marks a run interrupted between steps; nextStep has not started yet.
+func (s *Store) MarkRunInterrupted(runID string, nextStep int, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.runs[runID]
+	if !ok {
+		return fmt.Errorf("run %s not found", runID)
+	}
+	rec.Status = StatusInterrupted
+	rec.CurrentStepIndex = nextStep
+	rec.LastError = reason
+	rec.UpdatedAt = time.Now().UTC()
+	return s.commitLocked("run_interrupted", rec)
 }
 
-// ClearPendingReboot transitions a run from pending_reboot back to running.
+// MarkRunCancelled marks a run cancelled by request; a step left pending or interrupted at
+// stepIndex is marked cancelled too. Cancelled runs are never resumed.
+func (s *Store) MarkRunCancelled(runID string, stepIndex int, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.runs[runID]
+	if !ok {
+		return fmt.Errorf("run %s not found", runID)
+	}
+	if stepIndex >= 0 && stepIndex < len(rec.Steps) {
+		if st := rec.Steps[stepIndex].Status; st == StatusPending || st == StatusInterrupted {
+			finishStep(&rec.Steps[stepIndex], StatusCancelled, reason)
+		}
+	}
+	rec.Status = StatusCancelled
+	rec.PendingRebootNext = nil
+	rec.PendingBootMode = ""
+	rec.LastError = reason
+	rec.UpdatedAt = time.Now().UTC()
+	s.pruneHistoryLocked()
+	return s.commitLocked("run_cancelled", rec)
+}
+
+// ClearPendingReboot transitions a pending_reboot or interrupted run back to running.
 func (s *Store) ClearPendingReboot(runID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -190,12 +345,77 @@ func (s *Store) ClearPendingReboot(runID string) error {
 	if !ok {
 		return fmt.Errorf("run %s not found", runID)
 	}
+	now := time.Now().UTC()
+	if n := len(rec.Reboots); n > 0 && rec.Reboots[n-1].ResumedAt == nil {
+		rec.Reboots[n-1].ResumedAt = &now
+	}
 	rec.Status = StatusRunning
 	rec.PendingRebootNext = nil
 	rec.PendingBootMode = ""
 	rec.ResumeDelaySeconds = 0
-	rec.UpdatedAt = time.Now().UTC()
-	return s.persistLocked()
+	rec.Owner = self()
+	rec.UpdatedAt = now
+	return s.commitLocked("run_resumed", rec)
+}
+
+// InterruptStale marks runs left running by a process that no longer exists, e.g. one
+// that crashed or was killed when a shutdown timed out, as interrupted so that they are
+// resumed like runs stopped by a shutdown. A step left pending is re-run. It returns
+// the IDs of the runs it marked.
+func (s *Store) InterruptStale(reason string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var marked []string
+	for id, rec := range s.runs {
+		if rec.Status != StatusRunning || rec.Owner.alive() {
+			continue
+		}
+		next := rec.CurrentStepIndex
+		if next >= 0 && next < len(rec.Steps) {
+			switch rec.Steps[next].Status {
+			case StatusPending:
+				finishStep(&rec.Steps[next], StatusInterrupted, reason)
+			case StatusCompleted:
+				next++ // stopped between steps
+			}
+		}
+		rec.UpdatedAt = time.Now().UTC()
+		if next >= len(rec.Steps) {
+			// Every step completed; only the final transition was lost.
+			rec.Status = StatusCompleted
+			if err := s.commitLocked("run_completed", rec); err != nil {
+				return marked, err
+			}
+			continue
+		}
+		rec.Status = StatusInterrupted
+		rec.CurrentStepIndex = next
+		rec.LastError = reason
+		if err := s.commitLocked("run_interrupted", rec); err != nil {
+			return marked, err
+		}
+		marked = append(marked, id)
+	}
+	return marked, nil
+}
+
+// RecordAssertions stores the outcomes of a verify step's assertions.
+func (s *Store) RecordAssertions(runID string, stepIndex int, results []AssertionRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.runs[runID]
+	if !ok {
+		return fmt.Errorf("run %s not found", runID)
+	}
+	rec.Steps[stepIndex].Assertions = results
+	return s.commitLocked("assertions_recorded", rec)
+}
+
+func finishStep(st *StepRecord, status, errMsg string) {
+	now := time.Now().UTC()
+	st.Status = status
+	st.Error = errMsg
+	st.FinishedAt = &now
 }
 
 // MarkRunCompleted marks a run as completed.
@@ -209,15 +429,15 @@ func (s *Store) MarkRunCompleted(runID string) error {
 	rec.Status = StatusCompleted
 	rec.UpdatedAt = time.Now().UTC()
 	s.pruneHistoryLocked()
-	return s.persistLocked()
+	return s.commitLocked("run_completed", rec)
 }
 
-// pruneHistoryLocked keeps pending/incomplete runs and retains only the most recent completed/failed run.
+// pruneHistoryLocked keeps pending/incomplete runs and retains only the most recent finished run.
 func (s *Store) pruneHistoryLocked() {
 	var latestKey string
 	var latestTime time.Time
 	for k, v := range s.runs {
-		if v.Status == StatusCompleted || v.Status == StatusFailed {
+		if v.Finished() {
 			if v.UpdatedAt.After(latestTime) || latestKey == "" {
 				latestKey = k
 				latestTime = v.UpdatedAt
@@ -225,7 +445,7 @@ func (s *Store) pruneHistoryLocked() {
 		}
 	}
 	for k, v := range s.runs {
-		if v.Status == StatusCompleted || v.Status == StatusFailed {
+		if v.Finished() {
 			if k != latestKey {
 				delete(s.runs, k)
 			}
@@ -233,6 +453,21 @@ func (s *Store) pruneHistoryLocked() {
 	}
 }
 
+// commitLocked persists the store and then reports the transition to the hooks.
+func (s *Store) commitLocked(action string, rec *RunRecord) error {
+	start := time.Now()
+	if err := s.persistLocked(); err != nil {
+		return err
+	}
+	if s.persist != nil {
+		s.persist(time.Since(start))
+	}
+	for _, h := range s.hooks {
+		h(action, rec.clone())
+	}
+	return nil
+}
+
 func (s *Store) persistLocked() error {
 	dir := filepath.Dir(s.path)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
@@ -243,9 +478,12 @@ func (s *Store) persistLocked() error {
 	if err != nil {
 		return err
 	}
+	if data, err = s.codec.Encode(data); err != nil {
+		return err
+	}
 
 	tmp := s.path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+	if err := os.WriteFile(tmp, data, s.codec.FileMode()); err != nil {
 		return err
 	}
 	return os.Rename(tmp, s.path)
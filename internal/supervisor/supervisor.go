@@ -0,0 +1,463 @@
+// Package supervisor executes workflow runs one at a time on behalf of the service
+// and the CLI. It owns the cancellation of in-flight runs so that shutdown, control
+// requests and triggers all stop runs the same way.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/autostep/autostep/internal/actions"
+	"github.com/autostep/autostep/internal/eventlog"
+	"github.com/autostep/autostep/internal/events"
+	"github.com/autostep/autostep/internal/fetch"
+	"github.com/autostep/autostep/internal/manifest"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/runner"
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/workflow"
+)
+
+// ErrRebootPending is returned for runs submitted while another run waits for a reboot.
+var ErrRebootPending = errors.New("a run is waiting for reboot; not starting new runs until the next boot")
+
+// Logger is the structured logger passed on to each run's runner.
+type Logger = runner.Logger
+
+// Supervisor runs queued jobs sequentially against a shared state store.
+type Supervisor struct {
+	paths  paths.Paths
+	store  *state.Store
+	logger Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+	exited chan struct{}
+
+	mu     sync.Mutex
+	queue  []*Handle
+	jobs   map[string]*Handle // queued or running, by run ID
+	hooks  []runner.RunHook
+	events eventlog.Sink
+	bus    *events.Bus
+}
+
+// Handle tracks a submitted run.
+type Handle struct {
+	RunID    string
+	Workflow string
+
+	wf     *workflow.Workflow
+	params map[string]string
+	start  int
+	resume bool
+
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	running bool
+	done    chan struct{}
+	err     error
+}
+
+// JobInfo describes a queued or running job.
+type JobInfo struct {
+	RunID    string `json:"run_id"`
+	Workflow string `json:"workflow"`
+	Running  bool   `json:"running"`
+}
+
+// New starts a Supervisor whose runs are interrupted when ctx is cancelled.
+func New(ctx context.Context, p paths.Paths, store *state.Store, logger Logger) *Supervisor {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Supervisor{
+		paths:  p,
+		store:  store,
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+		exited: make(chan struct{}),
+		jobs:   map[string]*Handle{},
+		events: eventlog.Discard,
+		bus:    events.NewBus(),
+	}
+	go s.loop()
+	return s
+}
+
+// Store returns the state store the supervisor records runs in.
+func (s *Supervisor) Store() *state.Store { return s.store }
+
+// OnRunStatus registers hooks passed to the runner of every subsequent run.
+func (s *Supervisor) OnRunStatus(hooks ...runner.RunHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hooks...)
+}
+
+// Events returns the bus on which every run of the supervisor publishes its events.
+func (s *Supervisor) Events() *events.Bus { return s.bus }
+
+// SetEventSink sets the system event log sink of every subsequent run.
+func (s *Supervisor) SetEventSink(sink eventlog.Sink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = sink
+}
+
+// Submit queues a new run of wf under runID with the given parameters. After Shutdown
+// the handle finishes at once with context.Canceled.
+func (s *Supervisor) Submit(wf *workflow.Workflow, runID string, params map[string]string) *Handle {
+	return s.enqueue(&Handle{RunID: runID, Workflow: wf.Name, wf: wf, params: params})
+}
+
+// Resume queues the continuation of an existing run at step start.
+func (s *Supervisor) Resume(wf *workflow.Workflow, runID string, start int) *Handle {
+	return s.enqueue(&Handle{RunID: runID, Workflow: wf.Name, wf: wf, start: start, resume: true})
+}
+
+// SubmitByName loads the named workflow from the manifest and queues a new run of it.
+// The name may select a version as "name@constraint"; otherwise the default is used.
+func (s *Supervisor) SubmitByName(name string, params map[string]string) (*Handle, error) {
+	m, err := manifest.Load(s.paths.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("load manifest: %w", err)
+	}
+	ref, err := m.Select(name)
+	if err != nil {
+		return nil, err
+	}
+	wf, err := s.prepare(s.ctx, *ref, params)
+	if err != nil {
+		return nil, err
+	}
+	return s.Submit(wf, NewRunID(wf.Name), params), nil
+}
+
+// SubmitRef queues a new run under runID of the manifest workflow ref, prepared like
+// the runs of SubmitByName.
+func (s *Supervisor) SubmitRef(ctx context.Context, ref manifest.WorkflowRef, runID string, params map[string]string) (*Handle, error) {
+	wf, err := s.prepare(ctx, ref, params)
+	if err != nil {
+		return nil, err
+	}
+	return s.Submit(wf, runID, params), nil
+}
+
+// prepare loads the workflow of a manifest entry for a run with params. Artifacts with
+// a URL are fetched if needed; parameters and the workflow's artifacts are verified.
+func (s *Supervisor) prepare(ctx context.Context, ref manifest.WorkflowRef, params map[string]string) (*workflow.Workflow, error) {
+	wf, err := workflow.Load(ref.ResolvePath(s.paths.Root))
+	if err != nil {
+		return nil, err
+	}
+	wf.ManifestVersion = ref.Version
+	if _, err := wf.ResolveParams(params); err != nil {
+		return nil, err
+	}
+	if err := ref.FetchArtifacts(ctx, fetch.Default, s.paths.ArtifactsDir); err != nil {
+		return nil, err
+	}
+	if err := ref.VerifyArtifacts(s.paths.ArtifactsDir); err != nil {
+		return nil, err
+	}
+	wf.Artifacts = ref.Aliases(s.paths.ArtifactsDir)
+	return wf, nil
+}
+
+// ResumePending queues every run waiting on a reboot and every run interrupted by a
+// shutdown. An interrupted run restarts at the step that was in flight. Runs still
+// recorded as running by a process that has exited (a crash, or a shutdown that timed
+// out) are marked interrupted first.
+func (s *Supervisor) ResumePending() ([]*Handle, error) {
+	stale, err := s.store.InterruptStale("the process running the run exited before it finished")
+	for _, runID := range stale {
+		s.logger.Warn("run was left running by a process that exited", "run_id", runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mark stale runs interrupted: %w", err)
+	}
+	exports := s.store.Export()
+	if len(exports) == 0 {
+		return nil, nil
+	}
+
+	var handles []*Handle
+	for runID, rec := range exports {
+		var start int
+		switch {
+		case rec.Status == state.StatusPendingReboot && rec.PendingRebootNext != nil:
+			start = *rec.PendingRebootNext
+		case rec.Status == state.StatusInterrupted:
+			start = rec.CurrentStepIndex
+			s.logger.Warn("run was interrupted", "run_id", runID, "workflow", rec.WorkflowName, "step_index", start, "error", rec.LastError)
+		default:
+			continue
+		}
+		wfPath, err := s.workflowPath(rec)
+		if err != nil {
+			s.logger.Error("cannot resume run", "run_id", runID, "workflow", rec.WorkflowName, "error", err)
+			continue
+		}
+		wf, err := workflow.Load(wfPath)
+		if err != nil {
+			s.logger.Error("cannot resume run: failed to load workflow", "run_id", runID, "workflow", rec.WorkflowName, "path", wfPath, "error", err)
+			continue
+		}
+		s.logger.Info("resuming run", "run_id", runID, "workflow", rec.WorkflowName, "step_index", start)
+		handles = append(handles, s.Resume(wf, runID, start))
+	}
+	return handles, nil
+}
+
+// workflowPath returns the file a run was started from, falling back to the manifest
+// for runs recorded before the path was stored.
+func (s *Supervisor) workflowPath(rec *state.RunRecord) (string, error) {
+	if rec.WorkflowPath != "" {
+		return rec.WorkflowPath, nil
+	}
+	m, err := manifest.Load(s.paths.Manifest)
+	if err != nil {
+		return "", fmt.Errorf("load manifest: %w", err)
+	}
+	query := rec.WorkflowName
+	if rec.WorkflowVersion != "" {
+		query += "@=" + rec.WorkflowVersion
+	}
+	ref, err := m.Select(query)
+	if err != nil {
+		return "", fmt.Errorf("manifest entry for workflow %s: %w", rec.WorkflowName, err)
+	}
+	return ref.ResolvePath(s.paths.Root), nil
+}
+
+// Cancel stops a queued or running run. A run that is waiting for a reboot or was
+// interrupted is marked cancelled in the store so it is not resumed.
+func (s *Supervisor) Cancel(runID string) error {
+	s.mu.Lock()
+	h, ok := s.jobs[runID]
+	if ok && !h.running {
+		s.removeQueuedLocked(h)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		if h.running {
+			h.cancel(runner.ErrCancelled)
+			return nil
+		}
+		if h.resume {
+			if err := s.store.MarkRunCancelled(runID, h.start, runner.ErrCancelled.Error()); err != nil {
+				return err
+			}
+		}
+		h.finish(runner.ErrCancelled)
+		return nil
+	}
+
+	rec, found := s.store.Export()[runID]
+	if !found {
+		return fmt.Errorf("run %s not found", runID)
+	}
+	if rec.Finished() {
+		return fmt.Errorf("run %s already %s", runID, rec.Status)
+	}
+	if rec.Status == state.StatusRunning {
+		return fmt.Errorf("run %s is running in another process", runID)
+	}
+	return s.store.MarkRunCancelled(runID, rec.CurrentStepIndex, runner.ErrCancelled.Error())
+}
+
+// Jobs lists queued and running jobs in execution order.
+func (s *Supervisor) Jobs() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []JobInfo
+	for _, h := range s.jobs {
+		if h.running {
+			out = append(out, JobInfo{RunID: h.RunID, Workflow: h.Workflow, Running: true})
+		}
+	}
+	for _, h := range s.queue {
+		out = append(out, JobInfo{RunID: h.RunID, Workflow: h.Workflow})
+	}
+	return out
+}
+
+// Shutdown interrupts the running job, abandons queued jobs and waits up to timeout for
+// the current step to finish or abort. It reports whether the supervisor stopped in time.
+func (s *Supervisor) Shutdown(timeout time.Duration) bool {
+	s.cancel()
+	select {
+	case <-s.exited:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Wait blocks until the run finishes, is cancelled, or is abandoned by shutdown. It returns
+// actions.ErrRebooting if the run stopped to wait for a reboot.
+func (h *Handle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Done is closed when the job has finished.
+func (h *Handle) Done() <-chan struct{} { return h.done }
+
+// NewRunID returns a fresh run ID for the workflow.
+func NewRunID(workflowName string) string {
+	return fmt.Sprintf("%s-%d", workflowName, time.Now().UnixNano())
+}
+
+func (s *Supervisor) enqueue(h *Handle) *Handle {
+	h.done = make(chan struct{})
+	h.ctx, h.cancel = context.WithCancelCause(s.ctx)
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		// Shut down: the loop has abandoned, or is abandoning, what is queued and would
+		// never pick this up. Checked under mu, which abandonQueued takes after cancel.
+		s.mu.Unlock()
+		h.finish(context.Canceled)
+		return h
+	}
+	if _, dup := s.jobs[h.RunID]; dup {
+		s.mu.Unlock()
+		h.finish(fmt.Errorf("run %s already queued", h.RunID))
+		return h
+	}
+	s.jobs[h.RunID] = h
+	s.queue = append(s.queue, h)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return h
+}
+
+func (s *Supervisor) loop() {
+	defer close(s.exited)
+	for {
+		h := s.next()
+		if h == nil {
+			s.abandonQueued()
+			return
+		}
+		s.exec(h)
+	}
+}
+
+// next pops the next queued job, blocking until one arrives or the supervisor stops.
+func (s *Supervisor) next() *Handle {
+	for {
+		if s.ctx.Err() != nil {
+			return nil
+		}
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			h := s.queue[0]
+			s.queue = s.queue[1:]
+			h.running = true
+			s.mu.Unlock()
+			return h
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.wake:
+		case <-s.ctx.Done():
+		}
+	}
+}
+
+func (s *Supervisor) exec(h *Handle) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.jobs, h.RunID)
+		s.mu.Unlock()
+	}()
+	// Resumed runs are exempt: they are the ones the pending reboot was waiting for.
+	if !h.resume && s.RebootPending() {
+		h.finish(ErrRebootPending)
+		return
+	}
+
+	r := runner.New(s.paths, s.store, s.logger)
+	s.mu.Lock()
+	r.OnRunStatus(s.hooks...)
+	r.SetEventSink(s.events)
+	r.SetEventBus(s.bus)
+	s.mu.Unlock()
+	var err error
+	if h.resume {
+		err = r.ContinueWorkflow(h.ctx, h.RunID, h.wf, h.start)
+	} else {
+		err = r.RunWorkflow(h.ctx, h.RunID, h.wf, h.params)
+	}
+	switch {
+	case err == nil && s.status(h.RunID) == state.StatusPendingReboot:
+		s.logger.Info("run requested reboot; will resume automatically on next boot", "run_id", h.RunID, "workflow", h.Workflow)
+		err = actions.ErrRebooting
+	case err == nil:
+		s.logger.Info("run completed", "run_id", h.RunID, "workflow", h.Workflow)
+	case errors.Is(err, runner.ErrInterrupted):
+		s.logger.Warn("run interrupted; will resume on next start", "run_id", h.RunID, "workflow", h.Workflow)
+	case errors.Is(err, runner.ErrCancelled):
+		s.logger.Info("run cancelled", "run_id", h.RunID, "workflow", h.Workflow)
+	default:
+		s.logger.Error("run failed", "run_id", h.RunID, "workflow", h.Workflow, "error", err)
+	}
+	h.finish(err)
+}
+
+func (s *Supervisor) abandonQueued() {
+	s.mu.Lock()
+	queued := s.queue
+	s.queue = nil
+	for _, h := range queued {
+		delete(s.jobs, h.RunID)
+	}
+	s.mu.Unlock()
+	for _, h := range queued {
+		s.logger.Info("queued run abandoned by shutdown", "run_id", h.RunID, "workflow", h.Workflow)
+		h.finish(context.Canceled)
+	}
+}
+
+func (s *Supervisor) removeQueuedLocked(h *Handle) {
+	for i, q := range s.queue {
+		if q == h {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			break
+		}
+	}
+	delete(s.jobs, h.RunID)
+}
+
+// RebootPending reports whether any run is waiting for a reboot to continue.
+func (s *Supervisor) RebootPending() bool {
+	for _, rec := range s.store.Export() {
+		if rec.Status == state.StatusPendingReboot {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Supervisor) status(runID string) string {
+	if rec, ok := s.store.Export()[runID]; ok {
+		return rec.Status
+	}
+	return ""
+}
+
+func (h *Handle) finish(err error) {
+	h.err = err
+	h.cancel(nil)
+	close(h.done)
+}
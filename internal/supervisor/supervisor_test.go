@@ -0,0 +1,185 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/autostep/autostep/internal/logging"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/runner"
+	"github.com/autostep/autostep/internal/state"
+	"github.com/autostep/autostep/internal/workflow"
+)
+
+var testLogger = logging.New(io.Discard, io.Discard, slog.LevelError)
+
+// newSupervisor starts a supervisor on the state file of p; it is shut down when the
+// test ends.
+func newSupervisor(t *testing.T, p paths.Paths) *Supervisor {
+	t.Helper()
+	store, err := state.Open(p.StatePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(context.Background(), p, store, testLogger)
+	t.Cleanup(func() { s.Shutdown(5 * time.Second) })
+	return s
+}
+
+func loadWorkflow(t *testing.T, dir, content string) *workflow.Workflow {
+	t.Helper()
+	path := filepath.Join(dir, "wf.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf, err := workflow.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wf
+}
+
+// waitFor polls the run's record until cond holds.
+func waitFor(t *testing.T, s *Supervisor, runID string, cond func(*state.RunRecord) bool) *state.RunRecord {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		rec, ok := s.Store().Run(runID)
+		if ok && cond(rec) {
+			return rec
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("run %s: condition not reached; record %+v", runID, rec)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func stepPending(idx int) func(*state.RunRecord) bool {
+	return func(rec *state.RunRecord) bool {
+		return rec.Status == state.StatusRunning && rec.Steps[idx].Status == state.StatusPending
+	}
+}
+
+// stopMidStep shuts the supervisor down while the first step of wf is in flight and
+// checks that the run is recorded as interrupted at that step and resumed there.
+func stopMidStep(t *testing.T, wfJSON string) {
+	p := paths.FromRoot(t.TempDir())
+	wf := loadWorkflow(t, p.Root, wfJSON)
+	s := newSupervisor(t, p)
+	h := s.Submit(wf, "run-1", nil)
+	waitFor(t, s, h.RunID, stepPending(0))
+
+	start := time.Now()
+	if !s.Shutdown(5 * time.Second) {
+		t.Fatal("shutdown timed out")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("shutdown took %s", d)
+	}
+	if err := h.Wait(); !errors.Is(err, runner.ErrInterrupted) {
+		t.Fatalf("Wait() = %v, want ErrInterrupted", err)
+	}
+	rec, _ := s.Store().Run(h.RunID)
+	if rec.Status != state.StatusInterrupted || rec.CurrentStepIndex != 0 || rec.Steps[0].Status != state.StatusInterrupted {
+		t.Fatalf("after shutdown: status %s, step %d (%s); want interrupted at step 0", rec.Status, rec.CurrentStepIndex, rec.Steps[0].Status)
+	}
+
+	// The next start re-runs the interrupted step.
+	s2 := newSupervisor(t, p)
+	handles, err := s2.ResumePending()
+	if err != nil || len(handles) != 1 {
+		t.Fatalf("ResumePending() = %d handles, %v; want 1", len(handles), err)
+	}
+	waitFor(t, s2, h.RunID, stepPending(0))
+	if err := s2.Cancel(h.RunID); err != nil {
+		t.Fatal(err)
+	}
+	if err := handles[0].Wait(); !errors.Is(err, runner.ErrCancelled) {
+		t.Fatalf("resumed run: Wait() = %v, want ErrCancelled", err)
+	}
+}
+
+func TestStopDuringSleepStep(t *testing.T) {
+	stopMidStep(t, `{"name":"long","steps":[{"id":"nap","action":"sleep","sleep_seconds":3600}]}`)
+}
+
+func TestStopDuringRunStep(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses the sleep command")
+	}
+	stopMidStep(t, `{"name":"long","steps":[{"id":"wait","action":"run","command":"sleep","args":["3600"]}]}`)
+}
+
+// TestResumeStaleRunningRun checks that a run left running by a process that no longer
+// exists is resumed after its last completed step.
+func TestResumeStaleRunningRun(t *testing.T) {
+	p := paths.FromRoot(t.TempDir())
+	wf := loadWorkflow(t, p.Root, `{"name":"two","steps":[{"id":"a","action":"sleep"},{"id":"b","action":"sleep"}]}`)
+	now := time.Now().UTC()
+	runs := map[string]*state.RunRecord{"run-1": {
+		RunID:        "run-1",
+		WorkflowName: wf.Name,
+		WorkflowPath: wf.Path,
+		Status:       state.StatusRunning,
+		StartedAt:    now,
+		UpdatedAt:    now,
+		Steps:        []state.StepRecord{{StepID: "a", Status: state.StatusCompleted}, {}},
+		TotalSteps:   2,
+		Owner:        &state.Owner{BootID: "an-earlier-boot", PID: os.Getpid()},
+	}}
+	data, err := json.Marshal(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p.StatePath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSupervisor(t, p)
+	handles, err := s.ResumePending()
+	if err != nil || len(handles) != 1 {
+		t.Fatalf("ResumePending() = %d handles, %v; want 1", len(handles), err)
+	}
+	if err := handles[0].Wait(); err != nil {
+		t.Fatal(err)
+	}
+	rec, _ := s.Store().Run("run-1")
+	if rec.Status != state.StatusCompleted || rec.Steps[1].Status != state.StatusCompleted {
+		t.Fatalf("status %s, step b %s; want both completed", rec.Status, rec.Steps[1].Status)
+	}
+	if rec.Steps[0].StartedAt != nil {
+		t.Fatal("completed step a was run again")
+	}
+}
+
+// TestSubmitAfterShutdown checks that a run submitted once the supervisor has stopped
+// finishes at once instead of waiting for a loop that has exited.
+func TestSubmitAfterShutdown(t *testing.T) {
+	p := paths.FromRoot(t.TempDir())
+	wf := loadWorkflow(t, p.Root, `{"name":"quick","steps":[{"id":"a","action":"sleep"}]}`)
+	s := newSupervisor(t, p)
+	if !s.Shutdown(5 * time.Second) {
+		t.Fatal("shutdown timed out")
+	}
+	h := s.Submit(wf, "run-1", nil)
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("handle of a run submitted after shutdown never finished")
+	}
+	if err := h.Wait(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait() = %v, want context.Canceled", err)
+	}
+	if jobs := s.Jobs(); len(jobs) != 0 {
+		t.Fatalf("jobs after shutdown: %v", jobs)
+	}
+}
@@ -0,0 +1,73 @@
+package trigger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fireRetention bounds how long fire records are kept for deduplication.
+const fireRetention = 30 * 24 * time.Hour
+
+// Fire records a trigger that started (or was about to start) a run.
+type Fire struct {
+	Key      string    `json:"key"`
+	Workflow string    `json:"workflow"`
+	Trigger  string    `json:"trigger"`
+	RunID    string    `json:"run_id"`
+	FiredAt  time.Time `json:"fired_at"`
+}
+
+// ledger is the durable trigger bookkeeping stored next to state.json.
+type ledger struct {
+	path string
+
+	BootID    string            `json:"boot_id"`
+	BootCount int               `json:"boot_count"`
+	Versions  map[string]string `json:"versions"`
+	Fires     map[string]Fire   `json:"fires"`
+}
+
+func openLedger(path string) (*ledger, error) {
+	l := &ledger{path: path}
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read triggers: %w", err)
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, l); err != nil {
+			return nil, fmt.Errorf("parse triggers: %w", err)
+		}
+	}
+	if l.Versions == nil {
+		l.Versions = map[string]string{}
+	}
+	if l.Fires == nil {
+		l.Fires = map[string]Fire{}
+	}
+	return l, nil
+}
+
+func (l *ledger) persist() error {
+	cutoff := time.Now().UTC().Add(-fireRetention)
+	for k, f := range l.Fires {
+		if f.FiredAt.Before(cutoff) {
+			delete(l.Fires, k)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
@@ -0,0 +1,382 @@
+// Package trigger starts workflows in response to events observed by the
+// service: boots, job files dropped into the inbox, and manifest version changes.
+// Every fire is recorded in a ledger before the run starts so that a crash
+// between the two never causes the same trigger to run twice.
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autostep/autostep/internal/actions"
+	"github.com/autostep/autostep/internal/bootid"
+	"github.com/autostep/autostep/internal/manifest"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/state"
+)
+
+// claimedDirName holds inbox files that have been taken by the service but not yet cleaned up.
+const claimedDirName = ".claimed"
+
+// Launcher starts a run of the referenced workflow under the given run ID. It should
+// return actions.ErrRebooting (possibly wrapped) if the run requested a reboot.
+type Launcher func(ctx context.Context, ref manifest.WorkflowRef, runID string, params map[string]string) error
+
+// JobFile is the optional JSON content of a file dropped into the inbox.
+type JobFile struct {
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Logger is a minimal logging interface.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Engine evaluates manifest triggers and launches runs for them.
+type Engine struct {
+	paths   paths.Paths
+	store   *state.Store
+	logger  Logger
+	launch  Launcher
+	bootID  func() string
+	started time.Time
+
+	mu     sync.Mutex
+	ledger *ledger
+}
+
+// New constructs an Engine backed by the trigger ledger under the data root.
+func New(p paths.Paths, store *state.Store, logger Logger, launch Launcher) (*Engine, error) {
+	l, err := openLedger(p.TriggersPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{paths: p, store: store, logger: logger, launch: launch, bootID: bootid.Current, started: time.Now(), ledger: l}, nil
+}
+
+// Run fires boot triggers, then polls for file drops and manifest changes until ctx is
+// cancelled or a launched run requests a reboot. Other errors, such as an unreadable
+// manifest or a ledger that cannot be written, are logged and retried on the next poll;
+// boot triggers are retried until they have been evaluated once.
+func (e *Engine) Run(ctx context.Context, loadManifest func() (*manifest.Manifest, error), interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	booted := false
+	for {
+		m, err := loadManifest()
+		if err != nil {
+			e.logger.Printf("trigger: load manifest: %v", err)
+		} else {
+			if !booted {
+				err := e.FireBoot(ctx, m)
+				booted = err == nil
+				if err := e.fatal(ctx, "boot triggers", err); err != nil {
+					return err
+				}
+			}
+			if err := e.fatal(ctx, "manifest_change triggers", e.FireManifestChanges(ctx, m)); err != nil {
+				return err
+			}
+			if err := e.fatal(ctx, "inbox", e.PollInbox(ctx, m)); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// fatal returns err if it ends Run (a requested reboot or cancellation) and logs it otherwise.
+func (e *Engine) fatal(ctx context.Context, what string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, actions.ErrRebooting) || ctx.Err() != nil {
+		return err
+	}
+	e.logger.Printf("trigger: %s: %v (retrying at the next poll)", what, err)
+	return nil
+}
+
+// FireBoot records the current boot and fires boot triggers that are due on it. If the
+// boot cannot be identified, every start of the engine counts as a boot: the triggers
+// fire again after a restart of the service, rather than never again.
+func (e *Engine) FireBoot(ctx context.Context, m *manifest.Manifest) error {
+	id := e.bootID()
+	if id == bootid.Unknown {
+		id = fmt.Sprintf("%s-%d", bootid.Unknown, e.started.UnixNano())
+	}
+	e.mu.Lock()
+	if e.ledger.BootID != id {
+		if strings.HasPrefix(id, bootid.Unknown+"-") {
+			e.logger.Printf("trigger: boot ID unavailable; boot triggers cannot be deduplicated and fire on every service start")
+		}
+		prevID, prevCount := e.ledger.BootID, e.ledger.BootCount
+		e.ledger.BootID = id
+		e.ledger.BootCount++
+		if err := e.ledger.persist(); err != nil {
+			e.ledger.BootID, e.ledger.BootCount = prevID, prevCount
+			e.mu.Unlock()
+			return fmt.Errorf("persist triggers: %w", err)
+		}
+	}
+	count := e.ledger.BootCount
+	e.mu.Unlock()
+
+	for _, ref := range defaults(m) {
+		for _, t := range ref.Triggers {
+			if t.Type != manifest.TriggerBoot {
+				continue
+			}
+			every := t.Every
+			if every <= 0 {
+				every = 1
+			}
+			if count%every != 0 {
+				continue
+			}
+			key := fmt.Sprintf("boot:%s:%s", ref.Name, id)
+			if err := e.fire(ctx, key, ref, t.Type, nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FireManifestChanges fires manifest_change triggers whose workflow's default version
+// differs from the version last recorded for it, so adding a new highest version fires it.
+// The first time a workflow is seen its version is only recorded as the baseline. Nothing
+// fires while fragments are left out of the manifest: the default versions may be wrong
+// until they are fixed.
+func (e *Engine) FireManifestChanges(ctx context.Context, m *manifest.Manifest) error {
+	if len(m.Skipped) > 0 {
+		return nil
+	}
+	for _, ref := range defaults(m) {
+		for _, t := range ref.Triggers {
+			if t.Type != manifest.TriggerManifestChange {
+				continue
+			}
+			e.mu.Lock()
+			last, seen := e.ledger.Versions[ref.Name]
+			if !seen {
+				e.ledger.Versions[ref.Name] = ref.Version
+				err := e.ledger.persist()
+				if err != nil {
+					delete(e.ledger.Versions, ref.Name)
+				}
+				e.mu.Unlock()
+				if err != nil {
+					return fmt.Errorf("persist triggers: %w", err)
+				}
+				e.logger.Printf("trigger manifest_change for workflow %s: version %q recorded as the baseline", ref.Name, ref.Version)
+				continue
+			}
+			e.mu.Unlock()
+			if last == ref.Version {
+				continue
+			}
+			key := fmt.Sprintf("manifest_change:%s:%s:%d", ref.Name, ref.Version, time.Now().UnixNano())
+			name, version := ref.Name, ref.Version
+			if err := e.fire(ctx, key, ref, t.Type, nil, func(l *ledger) { l.Versions[name] = version }); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PollInbox claims matching job files from the inbox and fires their file_drop triggers.
+// A file is claimed by renaming it into the inbox's claimed directory, so concurrent
+// consumers never both take it; claimed files left behind by a crash are finished here too.
+func (e *Engine) PollInbox(ctx context.Context, m *manifest.Manifest) error {
+	claimedDir := filepath.Join(e.paths.InboxDir, claimedDirName)
+	if err := os.MkdirAll(claimedDir, 0o755); err != nil {
+		return fmt.Errorf("make claimed dir: %w", err)
+	}
+
+	entries, err := os.ReadDir(e.paths.InboxDir)
+	if err != nil {
+		return fmt.Errorf("read inbox: %w", err)
+	}
+	for _, ent := range entries {
+		name := ent.Name()
+		if ent.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		ref, ok := matchFileDrop(m, name)
+		if !ok || e.active(ref.Name) {
+			continue
+		}
+		info, err := ent.Info()
+		if err != nil {
+			continue
+		}
+		claimed := fmt.Sprintf("%d-%s", info.ModTime().UnixNano(), name)
+		if err := os.Rename(filepath.Join(e.paths.InboxDir, name), filepath.Join(claimedDir, claimed)); err != nil {
+			e.logger.Printf("trigger: claim %s: %v", name, err)
+		}
+	}
+
+	claimedEntries, err := os.ReadDir(claimedDir)
+	if err != nil {
+		return fmt.Errorf("read claimed dir: %w", err)
+	}
+	for _, ent := range claimedEntries {
+		if ent.IsDir() {
+			continue
+		}
+		claimed := ent.Name()
+		_, original, found := strings.Cut(claimed, "-")
+		if !found {
+			continue
+		}
+		ref, ok := matchFileDrop(m, original)
+		if !ok {
+			e.logger.Printf("trigger: claimed job %s no longer matches any workflow; leaving it in place", claimed)
+			continue
+		}
+		if e.active(ref.Name) {
+			continue
+		}
+		params, perr := readJobFile(filepath.Join(claimedDir, claimed))
+		if perr != nil {
+			e.logger.Printf("trigger: claimed job %s is not a valid job file; leaving it in place: %v", claimed, perr)
+			continue
+		}
+		err := e.fire(ctx, "file_drop:"+claimed, ref, manifest.TriggerFileDrop, params, nil)
+		if err == nil || errors.Is(err, actions.ErrRebooting) || (errors.Is(err, context.Canceled) && e.fired("file_drop:"+claimed)) {
+			// The fire is recorded, so the job file has been consumed either way.
+			if rmErr := os.Remove(filepath.Join(claimedDir, claimed)); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+				e.logger.Printf("trigger: remove claimed job %s: %v", claimed, rmErr)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fire records the trigger in the ledger (applying update atomically with the record) and
+// launches the run; if the ledger cannot be written, nothing is recorded or launched.
+// Fires already in the ledger, and workflows with an unfinished run, are skipped.
+func (e *Engine) fire(ctx context.Context, key string, ref manifest.WorkflowRef, kind string, params map[string]string, update func(*ledger)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if e.fired(key) {
+		return nil
+	}
+	if e.active(ref.Name) {
+		e.logger.Printf("trigger %s for workflow %s skipped: workflow has an unfinished run", kind, ref.Name)
+		return nil
+	}
+
+	runID := fmt.Sprintf("%s-%d", ref.Name, time.Now().UnixNano())
+	e.mu.Lock()
+	versions := maps.Clone(e.ledger.Versions)
+	e.ledger.Fires[key] = Fire{Key: key, Workflow: ref.Name, Trigger: kind, RunID: runID, FiredAt: time.Now().UTC()}
+	if update != nil {
+		update(e.ledger)
+	}
+	err := e.ledger.persist()
+	if err != nil {
+		// Not recorded, so not launched: forget it so the next poll tries again.
+		delete(e.ledger.Fires, key)
+		e.ledger.Versions = versions
+	}
+	e.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("persist triggers: %w", err)
+	}
+
+	e.logger.Printf("trigger %s fired for workflow %s (run %s)", kind, ref.Name, runID)
+	if err := e.launch(ctx, ref, runID, params); err != nil {
+		if errors.Is(err, actions.ErrRebooting) || errors.Is(err, context.Canceled) {
+			return err
+		}
+		e.logger.Printf("triggered run %s failed: %v", runID, err)
+	}
+	return nil
+}
+
+// fired reports whether the ledger already holds the given fire.
+func (e *Engine) fired(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.ledger.Fires[key]
+	return ok
+}
+
+// active reports whether the workflow has a run that has not finished.
+func (e *Engine) active(workflowName string) bool {
+	for _, rec := range e.store.Export() {
+		if rec.WorkflowName != workflowName {
+			continue
+		}
+		if !rec.Finished() {
+			return true
+		}
+	}
+	return false
+}
+
+// readJobFile parses run parameters from a job file. Empty files carry no parameters.
+func readJobFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, nil
+	}
+	var job JobFile
+	if err := json.Unmarshal(content, &job); err != nil {
+		return nil, err
+	}
+	return job.Params, nil
+}
+
+func matchFileDrop(m *manifest.Manifest, fileName string) (manifest.WorkflowRef, bool) {
+	for _, ref := range defaults(m) {
+		for _, t := range ref.Triggers {
+			if t.Type != manifest.TriggerFileDrop {
+				continue
+			}
+			pattern := t.Pattern
+			if pattern == "" {
+				pattern = ref.Name + "*.job"
+			}
+			if ok, _ := filepath.Match(pattern, fileName); ok {
+				return ref, true
+			}
+		}
+	}
+	return manifest.WorkflowRef{}, false
+}
+
+// defaults returns the default version of each workflow in the manifest; triggers of
+// other versions are ignored.
+func defaults(m *manifest.Manifest) []manifest.WorkflowRef {
+	var refs []manifest.WorkflowRef
+	for _, ref := range m.Workflows {
+		if m.IsDefault(ref) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
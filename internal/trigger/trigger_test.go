@@ -0,0 +1,269 @@
+package trigger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/autostep/autostep/internal/bootid"
+	"github.com/autostep/autostep/internal/manifest"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/state"
+)
+
+// recorder is a Logger and Launcher that remembers what the engine did.
+type recorder struct {
+	mu       sync.Mutex
+	logs     []string
+	launched []string
+}
+
+func (r *recorder) Printf(format string, v ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, fmt.Sprintf(format, v...))
+}
+
+func (r *recorder) launch(_ context.Context, ref manifest.WorkflowRef, _ string, _ map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.launched = append(r.launched, ref.Name+"@"+ref.Version)
+	return nil
+}
+
+// take returns the launches since the last call.
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l := r.launched
+	r.launched = nil
+	return l
+}
+
+func (r *recorder) logged(substr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, l := range r.logs {
+		if strings.Contains(l, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// newEngine opens an engine on the data root of p that sees boot ID id, as the service
+// does each time it starts.
+func newEngine(t *testing.T, p paths.Paths, id string, r *recorder) *Engine {
+	t.Helper()
+	store, err := state.Open(p.StatePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(p, store, r, r.launch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.bootID = func() string { return id }
+	return e
+}
+
+func workflows(refs ...manifest.WorkflowRef) *manifest.Manifest {
+	return &manifest.Manifest{Workflows: refs}
+}
+
+func TestLedgerRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "triggers.json")
+	l, err := openLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.BootCount != 0 || len(l.Versions) != 0 || len(l.Fires) != 0 {
+		t.Fatalf("new ledger = %+v, want empty", l)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	recent := Fire{Key: "boot:a:b1", Workflow: "a", Trigger: manifest.TriggerBoot, RunID: "a-1", FiredAt: now.Add(-time.Hour)}
+	l.BootID = "b1"
+	l.BootCount = 3
+	l.Versions["a"] = "1.0.0"
+	l.Fires[recent.Key] = recent
+	l.Fires["boot:a:b0"] = Fire{Key: "boot:a:b0", Workflow: "a", Trigger: manifest.TriggerBoot, RunID: "a-0", FiredAt: now.Add(-fireRetention - time.Hour)}
+	if err := l.persist(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := openLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ledger{path: path, BootID: "b1", BootCount: 3, Versions: map[string]string{"a": "1.0.0"}, Fires: map[string]Fire{recent.Key: recent}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reopened ledger = %+v, want %+v (fires past retention pruned)", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openLedger(path); err == nil {
+		t.Error("openLedger of a malformed file: want error")
+	}
+}
+
+func TestFireBootDedupesAcrossRestarts(t *testing.T) {
+	p := paths.FromRoot(t.TempDir())
+	m := workflows(
+		manifest.WorkflowRef{Name: "each", Triggers: []manifest.Trigger{{Type: manifest.TriggerBoot}}},
+		manifest.WorkflowRef{Name: "second", Triggers: []manifest.Trigger{{Type: manifest.TriggerBoot, Every: 2}}},
+	)
+	r := &recorder{}
+
+	steps := []struct {
+		name    string
+		restart bool
+		bootID  string
+		want    []string
+	}{
+		{"first boot", true, "b1", []string{"each@"}},
+		{"same engine again", false, "b1", nil},
+		{"service restart, same boot", true, "b1", nil},
+		{"reboot", true, "b2", []string{"each@", "second@"}},
+		{"service restart after reboot", true, "b2", nil},
+		{"third boot", true, "b3", []string{"each@"}},
+	}
+	var e *Engine
+	for _, s := range steps {
+		if s.restart {
+			e = newEngine(t, p, s.bootID, r)
+		}
+		if err := e.FireBoot(context.Background(), m); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if got := r.take(); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: launched %v, want %v", s.name, got, s.want)
+		}
+	}
+}
+
+func TestFireBootUnknownID(t *testing.T) {
+	p := paths.FromRoot(t.TempDir())
+	m := workflows(manifest.WorkflowRef{Name: "each", Triggers: []manifest.Trigger{{Type: manifest.TriggerBoot}}})
+	r := &recorder{}
+
+	for i := 1; i <= 2; i++ {
+		e := newEngine(t, p, bootid.Unknown, r)
+		for range 2 {
+			if err := e.FireBoot(context.Background(), m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := r.take(); len(got) != 1 {
+			t.Errorf("start %d: launched %v, want one run per service start", i, got)
+		}
+		if e.ledger.BootCount != i {
+			t.Errorf("start %d: boot count %d, want %d", i, e.ledger.BootCount, i)
+		}
+	}
+	if !r.logged("boot ID unavailable") {
+		t.Errorf("no warning about the unknown boot ID; logs %q", r.logs)
+	}
+}
+
+func TestFireManifestChangesBaseline(t *testing.T) {
+	p := paths.FromRoot(t.TempDir())
+	r := &recorder{}
+	ref := func(version string) manifest.WorkflowRef {
+		return manifest.WorkflowRef{Name: "app", Version: version, Triggers: []manifest.Trigger{{Type: manifest.TriggerManifestChange}}}
+	}
+
+	steps := []struct {
+		name    string
+		restart bool
+		m       *manifest.Manifest
+		want    []string
+	}{
+		{"first sight records the baseline", true, workflows(ref("1.0.0")), nil},
+		{"unchanged", false, workflows(ref("1.0.0")), nil},
+		{"service restart, unchanged", true, workflows(ref("1.0.0")), nil},
+		{"new default version", false, workflows(ref("1.0.0"), ref("1.1.0")), []string{"app@1.1.0"}},
+		{"service restart after firing", true, workflows(ref("1.0.0"), ref("1.1.0")), nil},
+		{"left-out fragment", false, &manifest.Manifest{Workflows: []manifest.WorkflowRef{ref("2.0.0")}, Skipped: []error{errors.New("bad fragment")}}, nil},
+		{"rolled back", false, workflows(ref("1.0.0")), []string{"app@1.0.0"}},
+	}
+	var e *Engine
+	for _, s := range steps {
+		if s.restart {
+			e = newEngine(t, p, "b1", r)
+		}
+		if err := e.FireManifestChanges(context.Background(), s.m); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if got := r.take(); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: launched %v, want %v", s.name, got, s.want)
+		}
+	}
+}
+
+func TestRunKeepsPollingAfterPersistError(t *testing.T) {
+	p := paths.FromRoot(t.TempDir())
+	r := &recorder{}
+	e := newEngine(t, p, "b1", r)
+
+	// A non-empty directory in place of the ledger makes every persist fail.
+	if err := os.MkdirAll(filepath.Join(p.TriggersPath, "block"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m := workflows(manifest.WorkflowRef{Name: "each", Triggers: []manifest.Trigger{{Type: manifest.TriggerBoot}}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Run(ctx, func() (*manifest.Manifest, error) { return m, nil }, 10*time.Millisecond)
+	}()
+
+	waitUntil(t, func() bool { return r.logged("persist triggers") })
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned %v after a persist error", err)
+	default:
+	}
+	if got := r.take(); len(got) != 0 {
+		t.Fatalf("launched %v without recording the fire", got)
+	}
+
+	if err := os.RemoveAll(p.TriggersPath); err != nil {
+		t.Fatal(err)
+	}
+	var launched []string
+	waitUntil(t, func() bool {
+		launched = append(launched, r.take()...)
+		return len(launched) > 0
+	})
+	time.Sleep(50 * time.Millisecond)
+	launched = append(launched, r.take()...)
+	if !reflect.DeepEqual(launched, []string{"each@"}) {
+		t.Errorf("launched %v after the ledger became writable, want the boot trigger once", launched)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run after cancel = %v, want nil", err)
+	}
+}
+
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not reached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
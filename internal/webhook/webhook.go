@@ -0,0 +1,260 @@
+// Package webhook notifies HTTP sinks about run outcomes. Notifications are queued in an
+// on-disk outbox as soon as the run reaches the state, so they survive reboots and
+// boots without networking, and are delivered with backoff until they expire.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/autostep/autostep/internal/bootid"
+	"github.com/autostep/autostep/internal/config"
+	"github.com/autostep/autostep/internal/outbox"
+	"github.com/autostep/autostep/internal/paths"
+	"github.com/autostep/autostep/internal/state"
+)
+
+// Events sent to sinks.
+const (
+	EventCompleted     = "run.completed"
+	EventFailed        = "run.failed"
+	EventPendingReboot = "run.pending_reboot"
+)
+
+// Delivery headers. The signature is the hex HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with the sink's secret, sent as "sha256=<hex>".
+const (
+	HeaderEvent     = "X-Autostep-Event"
+	HeaderDelivery  = "X-Autostep-Delivery"
+	HeaderTimestamp = "X-Autostep-Timestamp"
+	HeaderSignature = "X-Autostep-Signature"
+)
+
+// Logger is a minimal logging interface.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Summary is the JSON body posted to sinks.
+type Summary struct {
+	Event          string             `json:"event"`
+	Host           string             `json:"host"`
+	BootID         string             `json:"boot_id"`
+	RunID          string             `json:"run_id"`
+	Workflow       string             `json:"workflow"`
+	Status         string             `json:"status"`
+	StartedAt      time.Time          `json:"started_at"`
+	UpdatedAt      time.Time          `json:"updated_at"`
+	TotalSteps     int                `json:"total_steps"`
+	CompletedSteps int                `json:"completed_steps"`
+	CurrentStep    int                `json:"current_step_index"`
+	Error          string             `json:"error,omitempty"`
+	PendingBoot    string             `json:"pending_boot_mode,omitempty"`
+	Steps          []state.StepRecord `json:"steps"`
+}
+
+// Dispatcher queues and delivers notifications for the configured sinks.
+type Dispatcher struct {
+	sinks  map[string]config.WebhookConfig
+	outbox *outbox.Outbox
+	logger Logger
+	client *http.Client
+	host   string
+}
+
+// New validates the sink configuration and opens the outbox under the data root.
+func New(p paths.Paths, sinks []config.WebhookConfig, logger Logger) (*Dispatcher, error) {
+	d := &Dispatcher{sinks: map[string]config.WebhookConfig{}, logger: logger, client: &http.Client{Timeout: 30 * time.Second}}
+	for _, s := range sinks {
+		if s.Name == "" {
+			return nil, fmt.Errorf("webhook %s: name is required", s.URL)
+		}
+		if _, dup := d.sinks[s.Name]; dup {
+			return nil, fmt.Errorf("webhook %s: duplicate name", s.Name)
+		}
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("webhook %s: url must be http or https", s.Name)
+		}
+		for _, ev := range s.Events {
+			if ev != EventCompleted && ev != EventFailed && ev != EventPendingReboot {
+				return nil, fmt.Errorf("webhook %s: unknown event %q", s.Name, ev)
+			}
+		}
+		d.sinks[s.Name] = s
+	}
+	ob, err := outbox.Open(filepath.Join(p.OutboxDir, "webhooks"))
+	if err != nil {
+		return nil, err
+	}
+	d.outbox = ob
+	d.host, _ = os.Hostname()
+	return d, nil
+}
+
+// Notify queues a notification of the run's current state for every sink subscribed to
+// it. It has the signature of runner.RunHook.
+func (d *Dispatcher) Notify(rec *state.RunRecord) {
+	var event string
+	switch rec.Status {
+	case state.StatusCompleted:
+		event = EventCompleted
+	case state.StatusFailed:
+		event = EventFailed
+	case state.StatusPendingReboot:
+		event = EventPendingReboot
+	default:
+		return
+	}
+	summary := d.summarize(event, rec)
+	for name, s := range d.sinks {
+		if !subscribed(s, event) {
+			continue
+		}
+		if err := d.outbox.Add(name, summary); err != nil {
+			d.logger.Printf("webhook %s: queue %s for run %s: %v", name, event, rec.RunID, err)
+		}
+	}
+}
+
+// Run delivers due notifications every interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// deliverDue attempts every due notification. A failure for one sink postpones that
+// sink's later notifications to the next round so each sink sees them in order.
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	due, err := d.outbox.Due(time.Now())
+	if err != nil {
+		d.logger.Printf("webhook: read outbox: %v", err)
+		return
+	}
+	blocked := map[string]bool{}
+	for _, m := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		sink, ok := d.sinks[m.Target]
+		if !ok {
+			d.logger.Printf("webhook: dropping notification %s for removed sink %s", m.ID, m.Target)
+			d.outbox.Remove(m)
+			continue
+		}
+		if time.Since(m.CreatedAt) > ttl(sink) {
+			d.logger.Printf("webhook %s: dropping notification %s after %d attempts; older than %s (last error: %s)", sink.Name, m.ID, m.Attempts, ttl(sink), m.LastError)
+			d.outbox.Remove(m)
+			continue
+		}
+		if blocked[sink.Name] {
+			continue
+		}
+		if err := d.post(ctx, sink, m); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			blocked[sink.Name] = true
+			d.logger.Printf("webhook %s: delivery %s failed (attempt %d): %v", sink.Name, m.ID, m.Attempts+1, err)
+			d.outbox.Retry(m, err)
+			continue
+		}
+		d.outbox.Remove(m)
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, sink config.WebhookConfig, m *outbox.Message) error {
+	var summary Summary
+	if err := json.Unmarshal(m.Body, &summary); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewReader(m.Body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, summary.Event)
+	req.Header.Set(HeaderDelivery, m.ID)
+	req.Header.Set(HeaderTimestamp, ts)
+	if sink.Secret != "" {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(sink.Secret, ts, m.Body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sink returned %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) summarize(event string, rec *state.RunRecord) Summary {
+	s := Summary{
+		Event:       event,
+		Host:        d.host,
+		BootID:      bootid.Current(),
+		RunID:       rec.RunID,
+		Workflow:    rec.WorkflowName,
+		Status:      rec.Status,
+		StartedAt:   rec.StartedAt,
+		UpdatedAt:   rec.UpdatedAt,
+		TotalSteps:  rec.TotalSteps,
+		CurrentStep: rec.CurrentStepIndex,
+		Error:       rec.LastError,
+		PendingBoot: rec.PendingBootMode,
+		Steps:       rec.Steps,
+	}
+	for _, st := range rec.Steps {
+		if st.Status == state.StatusCompleted {
+			s.CompletedSteps++
+		}
+	}
+	return s
+}
+
+func subscribed(s config.WebhookConfig, event string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, ev := range s.Events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+func ttl(s config.WebhookConfig) time.Duration {
+	return time.Duration(s.TTLHours) * time.Hour
+}
@@ -5,16 +5,36 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
+	"github.com/autostep/autostep/internal/signing"
 	"gopkg.in/yaml.v3"
 )
 
 // Workflow describes a declarative set of steps.
 type Workflow struct {
-	Version int    `json:"version" yaml:"version"`
-	Name    string `json:"name" yaml:"name"`
-	Steps   []Step `json:"steps" yaml:"steps"`
+	Version int     `json:"version" yaml:"version"`
+	Name    string  `json:"name" yaml:"name"`
+	Params  []Param `json:"params,omitempty" yaml:"params,omitempty"`
+	Steps   []Step  `json:"steps" yaml:"steps"`
+
+	// RunDefaults applies to every run step that does not set the option itself.
+	RunDefaults RunOptions `json:"run_defaults,omitempty" yaml:"run_defaults,omitempty"`
+
+	// Path is the file the workflow was loaded from.
+	Path string `json:"-" yaml:"-"`
+	// ManifestVersion is the version of the manifest entry it was selected through.
+	ManifestVersion string `json:"-" yaml:"-"`
+	// Artifacts maps cache:// names to the hashes of the blobs they stand for in runs.
+	Artifacts map[string]string `json:"-" yaml:"-"`
+}
+
+// Param declares a run parameter referenced from steps as ${param:name}.
+type Param struct {
+	Name     string `json:"name" yaml:"name"`
+	Default  string `json:"default,omitempty" yaml:"default,omitempty"`
+	Required bool   `json:"required,omitempty" yaml:"required,omitempty"`
 }
 
 // Step represents a single action in the workflow DSL.
@@ -44,6 +64,67 @@ type Step struct {
 	Env                []EnvVar    `json:"env,omitempty" yaml:"env,omitempty"`
 	WorkingDir         string      `json:"working_dir,omitempty" yaml:"working_dir,omitempty"`
 	Notes              string      `json:"notes,omitempty" yaml:"notes,omitempty"`
+
+	RunOptions `json:",inline" yaml:",inline"` // for run
+}
+
+// RunOptions restrict the process of a run step.
+type RunOptions struct {
+	RunAs         string   `json:"run_as,omitempty" yaml:"run_as,omitempty"`                   // user[:group] or uid[:gid]; DOMAIN\user on Windows
+	RunAsPassword string   `json:"run_as_password,omitempty" yaml:"run_as_password,omitempty"` // Windows logon password, usually ${secret:name}
+	CleanEnv      *bool    `json:"clean_env,omitempty" yaml:"clean_env,omitempty"`             // start from an empty environment; unset inherits the workflow's
+	EnvAllow      []string `json:"env_allow,omitempty" yaml:"env_allow,omitempty"`             // variables kept from the agent's environment with clean_env
+	Limits        *Limits  `json:"limits,omitempty" yaml:"limits,omitempty"`
+}
+
+// Clean reports whether the process starts from an empty environment.
+func (o RunOptions) Clean() bool {
+	return o.CleanEnv != nil && *o.CleanEnv
+}
+
+// Limits caps the resources of a run step's process. Zero means no limit.
+type Limits struct {
+	CPUSeconds int `json:"cpu_seconds,omitempty" yaml:"cpu_seconds,omitempty"`
+	MemoryMB   int `json:"memory_mb,omitempty" yaml:"memory_mb,omitempty"`
+	OpenFiles  int `json:"open_files,omitempty" yaml:"open_files,omitempty"` // not on Windows
+	Processes  int `json:"processes,omitempty" yaml:"processes,omitempty"`
+}
+
+// withDefaults returns o with unset options taken from d.
+func (o RunOptions) withDefaults(d RunOptions) RunOptions {
+	if o.RunAs == "" {
+		o.RunAs, o.RunAsPassword = d.RunAs, d.RunAsPassword
+	}
+	if o.CleanEnv == nil {
+		o.CleanEnv = d.CleanEnv
+	}
+	if o.EnvAllow == nil {
+		o.EnvAllow = d.EnvAllow
+	}
+	if d.Limits != nil {
+		merged := *d.Limits
+		if o.Limits != nil {
+			merged = o.Limits.withDefaults(merged)
+		}
+		o.Limits = &merged
+	}
+	return o
+}
+
+func (l Limits) withDefaults(d Limits) Limits {
+	if l.CPUSeconds == 0 {
+		l.CPUSeconds = d.CPUSeconds
+	}
+	if l.MemoryMB == 0 {
+		l.MemoryMB = d.MemoryMB
+	}
+	if l.OpenFiles == 0 {
+		l.OpenFiles = d.OpenFiles
+	}
+	if l.Processes == 0 {
+		l.Processes = d.Processes
+	}
+	return l
 }
 
 // Assertion is used for verify steps.
@@ -59,12 +140,147 @@ type EnvVar struct {
 	Value string `json:"value" yaml:"value"`
 }
 
+// ResolveParams validates the parameters supplied for a run against the declared ones
+// and fills in defaults. Undeclared and missing required parameters are errors.
+func (w *Workflow) ResolveParams(given map[string]string) (map[string]string, error) {
+	declared := make(map[string]Param, len(w.Params))
+	for _, p := range w.Params {
+		declared[p.Name] = p
+	}
+	for name := range given {
+		if _, ok := declared[name]; !ok {
+			return nil, fmt.Errorf("workflow %s does not declare parameter %q", w.Name, name)
+		}
+	}
+	resolved := make(map[string]string, len(w.Params))
+	for _, p := range w.Params {
+		v, ok := given[p.Name]
+		switch {
+		case ok:
+			resolved[p.Name] = v
+		case p.Required:
+			return nil, fmt.Errorf("workflow %s requires parameter %q", w.Name, p.Name)
+		default:
+			resolved[p.Name] = p.Default
+		}
+	}
+	return resolved, nil
+}
+
+// refPattern matches ${kind:name} references inside step fields.
+var refPattern = regexp.MustCompile(`\$\{(\w+):([^}]+)\}`)
+
+// Expand returns a copy of the step with every ${kind:name} reference in its string
+// fields replaced by lookup. A reference lookup cannot resolve is an error.
+func (s Step) Expand(lookup func(kind, name string) (string, bool)) (Step, error) {
+	var firstErr error
+	expand := func(v string) string {
+		return refPattern.ReplaceAllStringFunc(v, func(ref string) string {
+			m := refPattern.FindStringSubmatch(ref)
+			val, ok := lookup(m[1], m[2])
+			if !ok {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("step %s: unresolved reference %s", s.ID, ref)
+				}
+				return ref
+			}
+			return val
+		})
+	}
+	expandAny := func(v any) any {
+		if str, ok := v.(string); ok {
+			return expand(str)
+		}
+		return v
+	}
+
+	out := s
+	out.SrcPath = expand(s.SrcPath)
+	out.DstPath = expand(s.DstPath)
+	out.NewName = expand(s.NewName)
+	out.PathRegex = expand(s.PathRegex)
+	out.HiveFile = expand(s.HiveFile)
+	out.Service = expand(s.Service)
+	out.DriverName = expand(s.DriverName)
+	out.DriverPath = expand(s.DriverPath)
+	out.VerifySHA256 = expand(s.VerifySHA256)
+	out.Path = expand(s.Path)
+	out.Type = expand(s.Type)
+	out.Value = expandAny(s.Value)
+	out.Expected = expandAny(s.Expected)
+	out.Command = expand(s.Command)
+	out.WorkingDir = expand(s.WorkingDir)
+	out.RunAs = expand(s.RunAs)
+	out.RunAsPassword = expand(s.RunAsPassword)
+	out.SafeBootMode = expand(s.SafeBootMode)
+	if s.Args != nil {
+		out.Args = make([]string, len(s.Args))
+		for i, a := range s.Args {
+			out.Args[i] = expand(a)
+		}
+	}
+	if s.Env != nil {
+		out.Env = make([]EnvVar, len(s.Env))
+		for i, kv := range s.Env {
+			out.Env[i] = EnvVar{Key: kv.Key, Value: expand(kv.Value)}
+		}
+	}
+	if s.Assertions != nil {
+		out.Assertions = make([]Assertion, len(s.Assertions))
+		for i, a := range s.Assertions {
+			out.Assertions[i] = Assertion{Kind: a.Kind, Path: expand(a.Path), Expected: expandAny(a.Expected)}
+		}
+	}
+	return out, firstErr
+}
+
+// ResolvePaths returns a copy of the step with the fields that name files resolved by
+// path, and its path_regex by pattern. The first error is returned.
+func (s Step) ResolvePaths(path, pattern func(string) (string, error)) (Step, error) {
+	var firstErr error
+	resolve := func(fn func(string) (string, error), v string) string {
+		if v == "" {
+			return v
+		}
+		out, err := fn(v)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("step %s: %w", s.ID, err)
+			}
+			return v
+		}
+		return out
+	}
+
+	out := s
+	out.SrcPath = resolve(path, s.SrcPath)
+	out.DstPath = resolve(path, s.DstPath)
+	out.PathRegex = resolve(pattern, s.PathRegex)
+	out.HiveFile = resolve(path, s.HiveFile)
+	out.DriverPath = resolve(path, s.DriverPath)
+	out.Command = resolve(path, s.Command)
+	out.WorkingDir = resolve(path, s.WorkingDir)
+	if s.Assertions != nil {
+		out.Assertions = make([]Assertion, len(s.Assertions))
+		for i, a := range s.Assertions {
+			if strings.EqualFold(a.Kind, "file_exists") {
+				a.Path = resolve(path, a.Path)
+			}
+			out.Assertions[i] = a
+		}
+	}
+	return out, firstErr
+}
+
 // Load reads a workflow from YAML or JSON based on file extension.
 func Load(path string) (*Workflow, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read workflow %s: %w", path, err)
 	}
+	if err := signing.Check(path, content); err != nil {
+		return nil, err
+	}
 	var wf Workflow
 	switch strings.ToLower(filepath.Ext(path)) {
 	case ".yaml", ".yml":
@@ -76,5 +292,11 @@ func Load(path string) (*Workflow, error) {
 			return nil, fmt.Errorf("parse json %s: %w", path, err)
 		}
 	}
+	for i, step := range wf.Steps {
+		if strings.EqualFold(step.Action, "run") {
+			wf.Steps[i].RunOptions = step.RunOptions.withDefaults(wf.RunDefaults)
+		}
+	}
+	wf.Path = path
 	return &wf, nil
 }
@@ -0,0 +1,37 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wf.yaml")
+	content := `name: x
+run_defaults:
+  clean_env: true
+  run_as: svc
+  limits: { cpu_seconds: 60, memory_mb: 512 }
+steps:
+  - { id: inherit, action: run, command: a }
+  - { id: opt-out, action: run, command: b, clean_env: false, run_as: other, limits: { memory_mb: 256 } }
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherit, optOut := wf.Steps[0].RunOptions, wf.Steps[1].RunOptions
+	if !inherit.Clean() || inherit.RunAs != "svc" || inherit.Limits == nil || *inherit.Limits != (Limits{CPUSeconds: 60, MemoryMB: 512}) {
+		t.Errorf("inheriting step: clean %v, %+v", inherit.Clean(), inherit)
+	}
+	if optOut.Clean() {
+		t.Error("clean_env: false on the step did not override the workflow's clean_env")
+	}
+	if optOut.RunAs != "other" || optOut.Limits == nil || *optOut.Limits != (Limits{CPUSeconds: 60, MemoryMB: 256}) {
+		t.Errorf("overriding step: %+v", optOut)
+	}
+}